@@ -0,0 +1,76 @@
+// Command reconcile checks every user's credit balance against the ledger
+// and reports drift. Run with -fix to post corrective reconciliation entries.
+//
+//	go run ./cmd/reconcile [-fix] [-json]
+//
+// It exits with status 1 if drift or an invariant violation remains.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"trademinutes-user/config"
+	"trademinutes-user/services"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "post corrective ledger entries for drifted balances")
+	asJSON := flag.Bool("json", false, "print the full report as JSON")
+	timeout := flag.Duration("timeout", 30*time.Minute, "maximum run time")
+	flag.Parse()
+
+	if os.Getenv("ENV") != "production" {
+		if err := godotenv.Load(); err != nil {
+			log.Println(".env file not found, assuming production environment variables")
+		}
+	}
+
+	config.ConnectDB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := services.Reconcile(ctx, *fix)
+	if err != nil {
+		log.Fatalf("❌ Reconciliation failed: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		fmt.Printf("Users checked: %d\n", report.UsersChecked)
+		fmt.Printf("Issued: %d  Spent: %d  Balances: %d  Active lots: %d\n",
+			report.TotalIssued, report.TotalSpent, report.TotalBalances, report.TotalLotRemaining)
+		for _, mismatch := range report.Mismatches {
+			status := "drift"
+			if mismatch.Corrected {
+				status = "corrected"
+			} else if mismatch.NeedsReview {
+				status = "needs review"
+			}
+			fmt.Printf("  %s %s <%s>: balance %d, ledger %d (%+d)\n",
+				status, mismatch.UserID.Hex(), mismatch.Email, mismatch.Balance, mismatch.LedgerSum, mismatch.Drift)
+		}
+		for _, violation := range report.Violations {
+			fmt.Printf("  invariant violated: %s\n", violation)
+		}
+		if len(report.Orphans) > 0 {
+			fmt.Printf("Ledgers of %d users that no longer exist net to %d\n", len(report.Orphans), report.OrphanLedgerSum)
+		}
+	}
+
+	if !report.Healthy() {
+		os.Exit(1)
+	}
+	fmt.Println("✅ Ledger and balances agree")
+}
@@ -46,4 +46,33 @@ func GetDB() *mongo.Database {
 
 func GetCollection(collectionName string) *mongo.Collection {
 	return db.Collection(collectionName)
-} 
\ No newline at end of file
+}
+
+// WithTransaction runs fn inside a MongoDB transaction, committing on success
+// and aborting if fn returns an error.
+func WithTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// WithSnapshot runs fn with reads that all see the data as of one moment,
+// without holding a transaction open. The server only keeps that moment
+// readable for a while (minSnapshotHistoryWindowInSeconds, 5 minutes by
+// default), after which reads fail with SnapshotTooOld.
+func WithSnapshot(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := db.Client().StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	return mongo.WithSession(ctx, session, fn)
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CreditGrantRule describes how many credits a grant source awards and for
+// how long they stay spendable. ExpiresInDays of 0 means the credits never expire.
+type CreditGrantRule struct {
+	Source        string `json:"source"`
+	Amount        int    `json:"amount"`
+	ExpiresInDays int    `json:"expiresInDays"`
+}
+
+// defaultCreditGrantRules are used for any source not overridden by CREDIT_GRANT_RULES
+var defaultCreditGrantRules = map[string]CreditGrantRule{
+	"signup":             {Source: "signup", Amount: 200, ExpiresInDays: 365},
+	"profile_completion": {Source: "profile_completion", Amount: 0, ExpiresInDays: 365},
+	"email_verified":     {Source: "email_verified", Amount: 0, ExpiresInDays: 365},
+	"first_review":       {Source: "first_review", Amount: 0, ExpiresInDays: 365},
+	"referral_referrer":  {Source: "referral_referrer", Amount: 50, ExpiresInDays: 365},
+	"referral_referee":   {Source: "referral_referee", Amount: 50, ExpiresInDays: 365},
+}
+
+// CreditGrantRules returns the built-in grant rules overlaid with CREDIT_GRANT_RULES,
+// a JSON array such as [{"source":"signup","amount":150,"expiresInDays":90}].
+func CreditGrantRules() []CreditGrantRule {
+	rules := map[string]CreditGrantRule{}
+	for source, rule := range defaultCreditGrantRules {
+		rules[source] = rule
+	}
+
+	if raw := os.Getenv("CREDIT_GRANT_RULES"); raw != "" {
+		var overrides []CreditGrantRule
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			log.Printf("⚠️  Ignoring invalid CREDIT_GRANT_RULES: %v", err)
+		} else {
+			for _, rule := range overrides {
+				rules[rule.Source] = rule
+			}
+		}
+	}
+
+	list := make([]CreditGrantRule, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Source < list[j].Source })
+	return list
+}
+
+// GetCreditGrantRule returns the configured grant rule for a source
+func GetCreditGrantRule(source string) (CreditGrantRule, bool) {
+	for _, rule := range CreditGrantRules() {
+		if rule.Source == source {
+			return rule, true
+		}
+	}
+	return CreditGrantRule{}, false
+}
+
+// ReferralMaxPerReferrer caps how many referrals one user can be rewarded for
+// (REFERRAL_MAX_PER_REFERRER, default 10).
+func ReferralMaxPerReferrer() int {
+	if v, err := strconv.Atoi(os.Getenv("REFERRAL_MAX_PER_REFERRER")); err == nil && v >= 0 {
+		return v
+	}
+	return 10
+}
+
+// CreditExpiryInterval is how often expired credit lots are swept
+// (CREDIT_EXPIRY_INTERVAL as a Go duration, default 1h).
+func CreditExpiryInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CREDIT_EXPIRY_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+// ReconcileInterval is how often the ledger is reconciled against balances
+// (RECONCILE_INTERVAL as a Go duration). Zero, the default, disables the job.
+func ReconcileInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("RECONCILE_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 0
+}
+
+// ReconcileAutoFix reports whether scheduled reconciliation posts corrective
+// entries for drift it finds (RECONCILE_AUTOFIX, default false).
+func ReconcileAutoFix() bool {
+	fix, _ := strconv.ParseBool(os.Getenv("RECONCILE_AUTOFIX"))
+	return fix
+}
+
+// CreditHoldTTL is how long a credit hold lasts when the caller does not say
+// (CREDIT_HOLD_TTL as a Go duration, default 24h). Expired holds are released.
+func CreditHoldTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CREDIT_HOLD_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
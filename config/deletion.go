@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// DeletionParticipants are the services that must acknowledge an account
+// deletion before the user is removed (DELETION_PARTICIPANTS, comma-separated,
+// default tasks,bookings,favorites,reviews).
+func DeletionParticipants() []string {
+	v := os.Getenv("DELETION_PARTICIPANTS")
+	if v == "" {
+		return []string{"tasks", "bookings", "favorites", "reviews"}
+	}
+	participants := []string{}
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			participants = append(participants, p)
+		}
+	}
+	return participants
+}
+
+// DeletionRetryInterval is how long to wait for acknowledgements before asking
+// again (DELETION_RETRY_INTERVAL as a Go duration, default 15m).
+func DeletionRetryInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("DELETION_RETRY_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 15 * time.Minute
+}
+
+// DeletionTimeout is how long a deletion may wait for acknowledgements before
+// it is marked timed out for an admin to look at (DELETION_TIMEOUT, default 72h).
+func DeletionTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("DELETION_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return 72 * time.Hour
+}
+
+// SoftDeleteRetention is how long a deleted user can still be restored before
+// the purge job removes them for good (SOFT_DELETE_RETENTION, default 720h).
+func SoftDeleteRetention() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("SOFT_DELETE_RETENTION")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// PurgeInterval is how often to look for deleted users past their retention
+// period (PURGE_INTERVAL, default 1h).
+func PurgeInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("PURGE_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+// AccountDeletionCoolingOff is how long after asking to delete their account a
+// user can still cancel (ACCOUNT_DELETION_COOLING_OFF, default 336h).
+func AccountDeletionCoolingOff() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_COOLING_OFF")); err == nil && d >= 0 {
+		return d
+	}
+	return 14 * 24 * time.Hour
+}
+
+// ReauthMaxAge is how recent a login must be to confirm a sensitive action
+// without a password, e.g. for OAuth users (REAUTH_MAX_AGE, default 5m).
+func ReauthMaxAge() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("REAUTH_MAX_AGE")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
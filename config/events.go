@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EventBroker selects where outbox events are published: "nats" or "memory"
+// (EVENT_BROKER). It defaults to memory, which only logs them, outside
+// production only; in production it must be set, so it is empty if not.
+func EventBroker() string {
+	if v := os.Getenv("EVENT_BROKER"); v != "" {
+		return v
+	}
+	if Production() {
+		return ""
+	}
+	return "memory"
+}
+
+// Production reports whether the service runs in production (ENV=production)
+func Production() bool {
+	return os.Getenv("ENV") == "production"
+}
+
+// NATSURL is the NATS server events are published to (NATS_URL, default nats://127.0.0.1:4222).
+func NATSURL() string {
+	if v := os.Getenv("NATS_URL"); v != "" {
+		return v
+	}
+	return "nats://127.0.0.1:4222"
+}
+
+// NATSJetStream reports whether events are published to JetStream with
+// acknowledgements instead of core NATS (NATS_JETSTREAM, default true). Core
+// NATS does not persist messages, so events published while a consumer is
+// down are lost to it.
+func NATSJetStream() bool {
+	if v, err := strconv.ParseBool(os.Getenv("NATS_JETSTREAM")); err == nil {
+		return v
+	}
+	return true
+}
+
+// OutboxRelayInterval is how often the outbox is polled for new events
+// (OUTBOX_RELAY_INTERVAL as a Go duration, default 1s).
+func OutboxRelayInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("OUTBOX_RELAY_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+// OutboxRetention is how long published events stay in the outbox
+// (OUTBOX_RETENTION as a Go duration, default 168h).
+func OutboxRetention() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("OUTBOX_RETENTION")); err == nil && d > 0 {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+// WebhookMaxAttempts is how many times a webhook delivery is tried before it
+// is dead-lettered (WEBHOOK_MAX_ATTEMPTS, default 8).
+func WebhookMaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return 8
+}
+
+// WebhookDeliveryInterval is how often due webhook deliveries are sent
+// (WEBHOOK_DELIVERY_INTERVAL as a Go duration, default 5s).
+func WebhookDeliveryInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("WEBHOOK_DELIVERY_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Second
+}
+
+// WebhookTimeout bounds a single webhook request (WEBHOOK_TIMEOUT as a Go duration, default 10s).
+func WebhookTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return 10 * time.Second
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExportDir is where finished data export archives are kept until they expire
+// (EXPORT_DIR, default trademinutes-exports in the system temp directory).
+func ExportDir() string {
+	if v := os.Getenv("EXPORT_DIR"); v != "" {
+		return v
+	}
+	return filepath.Join(os.TempDir(), "trademinutes-exports")
+}
+
+// ExportLinkTTL is how long a finished export can be downloaded before it is
+// deleted (EXPORT_LINK_TTL as a Go duration, default 24h).
+func ExportLinkTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("EXPORT_LINK_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+// ExportInterval is how often queued exports are built (EXPORT_INTERVAL, default 30s).
+func ExportInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("EXPORT_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// ExportSigningKey signs export download links (EXPORT_SIGNING_KEY, falling back to JWT_SECRET).
+func ExportSigningKey() []byte {
+	if v := os.Getenv("EXPORT_SIGNING_KEY"); v != "" {
+		return []byte(v)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+// PublicAPIURL is the externally reachable base URL of this service, used in
+// links sent to users (PUBLIC_API_URL, default http://localhost:$PORT).
+func PublicAPIURL() string {
+	if v := os.Getenv("PUBLIC_API_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://localhost:" + port
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// TrustedProxies are the load balancers whose X-Forwarded-For is believed
+// (TRUSTED_PROXIES, comma-separated IPs or CIDRs, default none). Without any,
+// a request's client IP is the address it came from.
+func TrustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			log.Printf("⚠️  Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
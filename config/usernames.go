@@ -0,0 +1,15 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// UsernameChangeCooldown is how long a user must wait between username
+// changes (USERNAME_CHANGE_COOLDOWN as a Go duration, default 720h).
+func UsernameChangeCooldown() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("USERNAME_CHANGE_COOLDOWN")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
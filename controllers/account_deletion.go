@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+	"trademinutes-user/utils"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DeleteAccountHandler schedules the current user's account for deletion once
+// the cooling-off period ends. Password users confirm with their password;
+// OAuth users must have signed in again within the last few minutes.
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Password       string `json:"password"`
+		ForfeitCredits bool   `json:"forfeitCredits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
+			http.Error(w, "Invalid password", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		// OAuth users have no password, so they prove it is them by signing in again
+		issuedAt, ok := r.Context().Value(middleware.IssuedAtKey).(time.Time)
+		if !ok || time.Since(issuedAt) > config.ReauthMaxAge() {
+			http.Error(w, "Recent sign-in required", http.StatusForbidden)
+			return
+		}
+	}
+
+	status, err := services.ScheduleAccountDeletion(ctx, user.ID, request.ForfeitCredits)
+	if errors.Is(err, services.ErrActiveHolds) || errors.Is(err, services.ErrCreditsNotForfeited) {
+		balance, balanceErr := services.GetBalance(ctx, user.ID)
+		if balanceErr != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   err.Error(),
+			"balance": balance,
+		})
+		return
+	}
+	if errors.Is(err, services.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to schedule deletion of %s: %v", email, err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	scheduledFor := time.Unix(status.DeletionScheduledFor, 0).UTC()
+	log.Printf("🗑️  Account %s scheduled for deletion at %s", email, scheduledFor.Format(time.RFC3339))
+
+	sendAccountEmail(email, "Your TradeMinutes account will be deleted",
+		"We received a request to delete your TradeMinutes account.\n\n"+
+			"Your account and everything stored with it will be permanently deleted on "+
+			scheduledFor.Format("2 January 2006 at 15:04 MST")+". "+
+			"Until then you can cancel by signing in and choosing to keep your account.\n\n"+
+			"If you did not ask for this, sign in and cancel the deletion, then change your password.\n")
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Account scheduled for deletion",
+		"deletion": status,
+	})
+}
+
+// GetAccountDeletionHandler reports whether the current user's account is scheduled for deletion
+func GetAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user struct {
+		services.AccountStatus `bson:",inline"`
+	}
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"scheduled":    user.DeletionScheduledFor != 0,
+		"requestedAt":  user.DeletionRequestedAt,
+		"scheduledFor": user.DeletionScheduledFor,
+	})
+}
+
+// CancelAccountDeletionHandler keeps the current user's account if its deletion is still in the cooling-off period
+func CancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = services.CancelAccountDeletion(ctx, user.ID)
+	if errors.Is(err, services.ErrNoDeletionScheduled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to cancel deletion of %s: %v", email, err)
+		http.Error(w, "Failed to cancel account deletion", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("✅ Account deletion cancelled by %s", email)
+
+	sendAccountEmail(email, "Your TradeMinutes account will not be deleted",
+		"The deletion of your TradeMinutes account has been cancelled and your account stays as it is.\n")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Account deletion cancelled",
+	})
+}
+
+// sendAccountEmail emails the user about a change to their account when email
+// is configured. A failure is only logged.
+func sendAccountEmail(to, subject, body string) {
+	if !utils.EmailConfigured() {
+		return
+	}
+	if err := utils.SendEmail(to, subject, body); err != nil {
+		log.Printf("Failed to email %s: %v", to, err)
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DeactivateAccountHandler hides the current user's profile and blocks their
+// login until they reactivate
+func DeactivateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := services.DeactivateUser(ctx, user.ID); err != nil {
+		log.Printf("Failed to deactivate %s: %v", email, err)
+		http.Error(w, "Failed to deactivate account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Account %s deactivated", email)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Account deactivated",
+	})
+}
+
+// ReactivateAccountHandler reactivates a deactivated account and logs the user in.
+// OAuth users reactivate by logging in with "reactivate": true instead.
+func ReactivateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": strings.ToLower(request.Email)})).Decode(&user)
+	if err != nil {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if rejectSuspended(ctx, w, user.ID) {
+		return
+	}
+
+	if err := services.ReactivateUser(ctx, user.ID); err != nil {
+		log.Printf("Failed to reactivate %s: %v", user.Email, err)
+		http.Error(w, "Failed to reactivate account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("✅ Account %s reactivated", user.Email)
+	recordLogin(r, user.ID, services.LoginReactivate, "")
+
+	// Generate JWT token
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email": user.Email,
+		"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+		"iat":   time.Now().Unix(),
+	})
+
+	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Remove password from response
+	user.Password = ""
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Account reactivated",
+		"token":   tokenString,
+		"user":    user,
+	})
+}
+
+// AdminListDeletedUsersHandler lists the soft-deleted users that can still be restored (admin only)
+func AdminListDeletedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users, err := services.ListDeletedUsers(ctx)
+	if err != nil {
+		log.Printf("Failed to list deleted users: %v", err)
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  users,
+		"count": len(users),
+	})
+}
+
+// AdminRestoreUserHandler restores a soft-deleted user, as long as their
+// retention period has not run out (admin only)
+func AdminRestoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	adminEmail, _ := r.Context().Value(middleware.EmailKey).(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = services.RestoreUser(ctx, id)
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrUserNotDeleted), errors.Is(err, services.ErrRestoreTooLate):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to restore user %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to restore user", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("♻️  User %s restored by %s", id.Hex(), adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "User restored",
+	})
+}
+
+// recordLogin notes a successful login in the user's login history. A failure
+// is only logged, so it never blocks the login itself.
+func recordLogin(r *http.Request, userID primitive.ObjectID, method, provider string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := services.LoginContext{
+		IP:        clientIP(r),
+		DeviceID:  strings.TrimSpace(r.Header.Get("X-Device-ID")),
+		UserAgent: r.UserAgent(),
+	}
+	// Tokens are issued for 7 days
+	expiresAt := time.Now().Add(time.Hour * 24 * 7)
+	if err := services.RecordLogin(ctx, userID, method, provider, client, expiresAt); err != nil {
+		log.Printf("Failed to record login for %s: %v", userID.Hex(), err)
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetAchievementsHandler returns the current user's stats and their progress towards every achievement
+func GetAchievementsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	progress, err := services.GetAchievementProgress(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to fetch achievements for %s: %v", email, err)
+		http.Error(w, "Failed to fetch achievements", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stats":        user.Stats,
+		"achievements": progress,
+	})
+}
+
+// ReportActivityHandler lets other services report a completed task or
+// session, or a review, which updates the user's stats and achievements.
+// Reporting the same eventId twice is not an error (service key required).
+func ReportActivityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var request struct {
+		EventID string  `json:"eventId"`
+		Type    string  `json:"type"`
+		UserId  string  `json:"userId"`
+		Minutes int     `json:"minutes"`
+		Rating  float64 `json:"rating"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(request.UserId)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	earned, err := services.ApplyActivity(ctx, services.Activity{
+		ID:      request.EventID,
+		Type:    request.Type,
+		UserID:  objectID,
+		Minutes: request.Minutes,
+		Rating:  request.Rating,
+	})
+	switch {
+	case errors.Is(err, services.ErrUnknownActivity), errors.Is(err, services.ErrInvalidActivity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrActivityApplied):
+		// Idempotent: a retried report is not an error for the caller
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Activity already applied",
+			"applied": false,
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to apply %s activity %s for %s: %v", request.Type, request.EventID, request.UserId, err)
+		http.Error(w, "Failed to apply activity", http.StatusInternalServerError)
+		return
+	}
+
+	for _, achievement := range earned {
+		log.Printf("🏆 User %s earned %s", request.UserId, achievement.Key)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":            "Activity applied",
+		"applied":            true,
+		"achievementsEarned": earned,
+	})
+}
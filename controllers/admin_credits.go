@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminAdjustCreditsHandler records a manual credit adjustment for a user (admin only)
+func AdminAdjustCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		UserId     string `json:"userId"`
+		Delta      int    `json:"delta"`
+		ReasonCode string `json:"reasonCode"`
+		Note       string `json:"note"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(request.UserId)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adjustment, err := services.RequestAdjustment(ctx, objectID, request.Delta, request.ReasonCode, request.Note, adminEmail)
+	if err != nil {
+		writeAdjustmentError(w, err)
+		return
+	}
+
+	message := "Credit adjustment applied"
+	if adjustment.Status == services.AdjustmentPending {
+		message = "Credit adjustment requires approval from a second admin"
+	}
+
+	log.Printf("Credit adjustment %s (%+d, %s) requested by %s: %s", adjustment.ID.Hex(), adjustment.Delta, adjustment.ReasonCode, adminEmail, adjustment.Status)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    message,
+		"adjustment": adjustment,
+	})
+}
+
+// AdminListCreditAdjustmentsHandler lists credit adjustments, optionally filtered by status or user (admin only)
+func AdminListCreditAdjustmentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var userID *primitive.ObjectID
+	if id := r.URL.Query().Get("userId"); id != "" {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = &objectID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adjustments, err := services.ListAdjustments(ctx, r.URL.Query().Get("status"), userID)
+	if err != nil {
+		log.Printf("Failed to list credit adjustments: %v", err)
+		http.Error(w, "Failed to fetch adjustments", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":              adjustments,
+		"count":             len(adjustments),
+		"approvalThreshold": services.AdjustmentApprovalThreshold(),
+	})
+}
+
+// AdminApproveCreditAdjustmentHandler applies a pending adjustment as the second admin (admin only)
+func AdminApproveCreditAdjustmentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid adjustment ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adjustment, err := services.ApproveAdjustment(ctx, objectID, adminEmail)
+	if err != nil {
+		writeAdjustmentError(w, err)
+		return
+	}
+
+	log.Printf("Credit adjustment %s approved by %s", adjustment.ID.Hex(), adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Credit adjustment approved and applied",
+		"adjustment": adjustment,
+	})
+}
+
+// AdminRejectCreditAdjustmentHandler rejects a pending adjustment (admin only)
+func AdminRejectCreditAdjustmentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid adjustment ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Note string `json:"note"`
+	}
+	// The note is optional, so an empty body is fine
+	json.NewDecoder(r.Body).Decode(&request)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adjustment, err := services.RejectAdjustment(ctx, objectID, adminEmail, request.Note)
+	if err != nil {
+		writeAdjustmentError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Credit adjustment rejected",
+		"adjustment": adjustment,
+	})
+}
+
+func writeAdjustmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAdjustmentData), errors.Is(err, services.ErrInvalidReasonCode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrAdjustmentNotFound):
+		http.Error(w, "Adjustment not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrAdjustmentNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInsufficientCredits):
+		http.Error(w, "Adjustment would make the balance negative", http.StatusConflict)
+	default:
+		log.Printf("Credit adjustment failed: %v", err)
+		http.Error(w, "Failed to process adjustment", http.StatusInternalServerError)
+	}
+}
+
+// AdminGetReconciliationHandler returns the most recent ledger reconciliation report (admin only)
+func AdminGetReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report, err := services.LatestReconciliationReport(ctx)
+	if err != nil {
+		log.Printf("Failed to fetch reconciliation report: %v", err)
+		http.Error(w, "Failed to fetch reconciliation report", http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.Error(w, "No reconciliation has run yet", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report":  report,
+		"healthy": report.Healthy(),
+	})
+}
+
+// AdminRunReconciliationHandler reconciles the ledger now; ?fix=true posts
+// corrective entries for any drift found (admin only)
+func AdminRunReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, _ := r.Context().Value(middleware.EmailKey).(string)
+	fix := r.URL.Query().Get("fix") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	report, err := services.Reconcile(ctx, fix)
+	if err != nil {
+		log.Printf("❌ Ledger reconciliation failed: %v", err)
+		http.Error(w, "Failed to reconcile ledger", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Ledger reconciliation run by %s (fix=%t): %d mismatches", adminEmail, fix, len(report.Mismatches))
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report":  report,
+		"healthy": report.Healthy(),
+	})
+}
@@ -3,19 +3,24 @@ package controllers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"trademinutes-user/config"
+	"trademinutes-user/events"
 	"trademinutes-user/middleware"
+	"trademinutes-user/services"
 
 	"github.com/ElioCloud/shared-models/models"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,20 +36,30 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	// Everything else on the user is set by the server or through the profile endpoints
+	var request struct {
+		Name         string `json:"name"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		ReferralCode string `json:"referralCode,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Validate required fields
-	if user.Email == "" || user.Password == "" || user.Name == "" {
+	if request.Email == "" || request.Password == "" || request.Name == "" {
 		http.Error(w, "Email, password, and name are required", http.StatusBadRequest)
 		return
 	}
+	fields, err := services.ValidateProfileFields(map[string]interface{}{"name": request.Name})
+	if writeValidationError(w, err) {
+		return
+	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
@@ -55,31 +70,57 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Deleted users keep their email until they are purged, so they can still be restored
 	var existingUser models.User
-	err = collection.FindOne(ctx, bson.M{"email": strings.ToLower(user.Email)}).Decode(&existingUser)
+	err = collection.FindOne(ctx, bson.M{"email": strings.ToLower(request.Email)}).Decode(&existingUser)
 	if err == nil {
 		http.Error(w, "User already exists", http.StatusConflict)
 		return
 	}
 
 	// Create new user
-	user.ID = primitive.NewObjectID()
-	user.Email = strings.ToLower(user.Email)
-	user.Password = string(hashedPassword)
-	user.Credits = 200 // Starting credits
-	user.CreatedAt = time.Now().Unix()
-	// user.Verified = false // Commented out as Verified field doesn't exist in models.User
-
-	_, err = collection.InsertOne(ctx, user)
+	user := models.User{
+		ID:        primitive.NewObjectID(),
+		Email:     strings.ToLower(request.Email),
+		Name:      fields["name"].(string),
+		Password:  string(hashedPassword),
+		Credits:   0, // Starting credits come from the signup bonus below
+		CreatedAt: time.Now().Unix(),
+	}
+
+	err = config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if _, err := collection.InsertOne(sessCtx, user); err != nil {
+			return err
+		}
+		entry, err := services.AwardBonusTx(sessCtx, user.ID, services.BonusSignup)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			user.Credits = entry.BalanceAfter
+		}
+		referral, err := services.RecordSignupTx(sessCtx, user, request.ReferralCode, signupContext(r))
+		if err != nil {
+			return err
+		}
+		return events.Record(sessCtx, events.UserRegistered, user.ID, userRegisteredData(user, "", referral))
+	})
+	if errors.Is(err, services.ErrInvalidReferralCode) {
+		http.Error(w, "Invalid referral code", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
+		log.Printf("Failed to create user: %v", err)
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	recordLogin(r, user.ID, services.LoginSignup, "")
 
 	// Generate JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"email": user.Email,
 		"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+		"iat":   time.Now().Unix(),
 	})
 
 	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
@@ -127,7 +168,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": strings.ToLower(loginRequest.Email)}).Decode(&user)
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": strings.ToLower(loginRequest.Email)})).Decode(&user)
 	if err != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
@@ -140,10 +181,27 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Deactivated users must reactivate before they can log in again
+	status, err := services.GetAccountStatus(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to fetch account status for %s: %v", user.Email, err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if status.DeactivatedAt != 0 {
+		http.Error(w, "Account is deactivated; reactivate it with POST /api/auth/reactivate", http.StatusForbidden)
+		return
+	}
+	if rejectSuspended(ctx, w, user.ID) {
+		return
+	}
+	recordLogin(r, user.ID, services.LoginPassword, "")
+
 	// Generate JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"email": user.Email,
 		"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+		"iat":   time.Now().Unix(),
 	})
 
 	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
@@ -186,7 +244,7 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
@@ -198,7 +256,8 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-// GetUserByIDHandler returns a user by ID
+// GetUserByIDHandler returns a user's public profile by ID, with the private
+// fields the caller may see. A token is optional.
 func GetUserByIDHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -223,24 +282,20 @@ func GetUserByIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := config.GetDB().Collection("MyClusterCol")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user models.User
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	profile, err := services.GetVisibleProfile(ctx, objectID)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	// Remove password from response
-	user.Password = ""
-
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(services.NewPublicProfile(*profile, profileViewer(ctx, r)))
 }
 
-// GetAllUsersHandler returns all users (for admin)
+// GetAllUsersHandler lists users' public profiles, shaped by the caller's
+// token when there is one
 func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -252,64 +307,52 @@ func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add debugging
-	fmt.Println("🔍 GetAllUsersHandler called")
-	fmt.Println("🔍 Request URL:", r.URL.String())
-
 	// Get search query parameter
 	searchQuery := r.URL.Query().Get("q")
-	fmt.Println("🔍 Search query:", searchQuery)
 
 	// Check if database is connected
 	db := config.GetDB()
 	if db == nil {
-		fmt.Println("❌ Database is nil")
+		log.Printf("❌ Database is not connected")
 		http.Error(w, "Database connection failed", http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("✅ Database connected:", db.Name())
 
 	collection := db.Collection("MyClusterCol")
-	fmt.Println("✅ Collection accessed:", collection.Name())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var filter bson.M
 	if searchQuery != "" {
-		// Search by name or email (case-insensitive)
-		filter = bson.M{
-			"$or": []bson.M{
-				{"name": bson.M{"$regex": searchQuery, "$options": "i"}},
-				{"email": bson.M{"$regex": searchQuery, "$options": "i"}},
-			},
+		// Search by name (case-insensitive); admins may search by email too
+		filter = services.NameFilter(searchQuery)
+		if email, _ := r.Context().Value(middleware.EmailKey).(string); middleware.IsAdmin(email) {
+			filter = services.NameOrEmailFilter(searchQuery)
 		}
 	} else {
 		filter = bson.M{}
 	}
-	fmt.Println("🔍 Filter:", filter)
+	// Deleted and deactivated users are hidden
+	filter = services.Visible(filter)
 
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
-		fmt.Println("❌ Database query error:", err)
+		log.Printf("Failed to fetch users: %v", err)
 		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
 		return
 	}
 	defer cursor.Close(ctx)
 
-	var users []models.User
-	if err = cursor.All(ctx, &users); err != nil {
-		fmt.Println("❌ Cursor decode error:", err)
+	var profiles []services.UserProfile
+	if err = cursor.All(ctx, &profiles); err != nil {
+		log.Printf("Failed to decode users: %v", err)
 		http.Error(w, "Failed to decode users", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println("✅ Found", len(users), "users")
-
-	// Remove passwords from response
-	for i := range users {
-		users[i].Password = ""
-	}
+	// Only public profiles are listed, shaped by the caller's token
+	users := services.NewPublicProfiles(profiles, profileViewer(ctx, r))
 
 	// Return in the format expected by the frontend
 	response := map[string]interface{}{
@@ -317,11 +360,14 @@ func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 		"count": len(users),
 	}
 
-	fmt.Println("✅ Sending response with", len(users), "users")
 	json.NewEncoder(w).Encode(response)
 }
 
-// AdminDeleteUserHandler deletes a user (for admin)
+// AdminDeleteUserHandler deletes a user (for admin). By default the user is
+// soft-deleted: hidden at once and restorable until SOFT_DELETE_RETENTION
+// passes. With ?immediate=true the permanent deletion starts straight away;
+// the user is removed once every service that holds their data (tasks,
+// bookings, favorites, reviews) has confirmed.
 func AdminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -333,97 +379,67 @@ func AdminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract user ID from URL
-	userID := strings.TrimPrefix(r.URL.Path, "/api/admin/delete/")
-	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+	requestedBy, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok || requestedBy == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	userID := mux.Vars(r)["id"]
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
 
-	collection := config.GetDB().Collection("MyClusterCol")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Delete user
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	if err != nil {
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
-		return
-	}
-
-	if result.DeletedCount == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	// TODO: Cascade delete user's tasks, bookings, favorites, reviews
-	// This would require connections to other services
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "User deleted successfully",
-	})
-}
+	if r.URL.Query().Get("immediate") != "true" {
+		status, err := services.SoftDeleteUser(ctx, objectID, requestedBy)
+		if errors.Is(err, services.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to delete user %s: %v", userID, err)
+			http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+			return
+		}
 
-// UpdateCreditsHandler updates user credits
-func UpdateCreditsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		log.Printf("🗑️  User %s deleted by %s, restorable until %d", userID, requestedBy, status.PurgeAt())
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	email, ok := r.Context().Value(middleware.EmailKey).(string)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":   "User deleted successfully",
+			"deletedAt": status.DeletedAt,
+			"purgeAt":   status.PurgeAt(),
+		})
 		return
 	}
 
-	var request struct {
-		Credits int `json:"credits"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	request, err := services.StartDeletion(ctx, objectID, requestedBy)
+	if errors.Is(err, services.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-
-	collection := config.GetDB().Collection("MyClusterCol")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"email": email},
-		bson.M{"$set": bson.M{"credits": request.Credits}},
-	)
-
 	if err != nil {
-		http.Error(w, "Failed to update credits", http.StatusInternalServerError)
+		log.Printf("Failed to delete user %s: %v", userID, err)
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
+	log.Printf("🗑️  Deletion %s of user %s requested by %s", request.ID.Hex(), userID, requestedBy)
 
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Credits updated successfully",
-		"credits": request.Credits,
+		"message":   "User deletion started",
+		"requestId": request.ID.Hex(),
+		"status":    request.Status,
+		"deletion":  request,
 	})
 }
 
-// DeductCreditsHandler deducts credits from user account
+// DeductCreditsHandler deducts credits from a user account for another service (service key required)
 func DeductCreditsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -436,9 +452,12 @@ func DeductCreditsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var request struct {
-		UserId  string `json:"userId"`
-		Credits int    `json:"credits"`
-		Reason  string `json:"reason"`
+		UserId    string `json:"userId"`
+		Credits   int    `json:"credits"`
+		Reason    string `json:"reason"`
+		Reference string `json:"reference"` // e.g. booking ID, kept on the ledger entry for refunds
+		// IdempotencyKey makes retries safe: a repeated key returns the original deduction
+		IdempotencyKey string `json:"idempotencyKey"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -451,59 +470,41 @@ func DeductCreditsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := config.GetDB().Collection("MyClusterCol")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// First, get current user to check available credits
-	var user models.User
 	objectID, err := primitive.ObjectIDFromHex(request.UserId)
 	if err != nil {
 		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
 
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Deduct credits, spending the earliest-expiring lots first
+	entry, err := services.DeductCredits(ctx, objectID, request.Credits, request.Reason, request.Reference, request.IdempotencyKey)
+	if errors.Is(err, services.ErrUserNotFound) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-
-	if user.Credits < request.Credits {
+	if errors.Is(err, services.ErrInsufficientCredits) {
 		http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
 		return
 	}
-
-	// Deduct credits
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$inc": bson.M{"credits": -request.Credits}},
-	)
-
-	if err != nil {
-		http.Error(w, "Failed to deduct credits", http.StatusInternalServerError)
-		return
-	}
-
-	if result.MatchedCount == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
+	if errors.Is(err, services.ErrUserSuspended) {
+		http.Error(w, "Account is suspended", http.StatusForbidden)
 		return
 	}
-
-	// Get updated user to return remaining credits
-	var updatedUser models.User
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&updatedUser)
 	if err != nil {
-		http.Error(w, "Failed to get updated user", http.StatusInternalServerError)
+		log.Printf("Failed to deduct credits: %v", err)
+		http.Error(w, "Failed to deduct credits", http.StatusInternalServerError)
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":           "Credits deducted successfully",
 		"credits_deducted":  request.Credits,
-		"remaining_credits": updatedUser.Credits,
+		"remaining_credits": entry.BalanceAfter,
 		"reason":            request.Reason,
+		"transaction_id":    entry.ID.Hex(), // Pass to the reversal API to refund this deduction
 	})
 }
 
@@ -520,9 +521,11 @@ func OAuthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var oauthData struct {
-		Email    string `json:"email"`
-		Name     string `json:"name"`
-		Provider string `json:"provider,omitempty"`
+		Email        string `json:"email"`
+		Name         string `json:"name"`
+		Provider     string `json:"provider,omitempty"`
+		ReferralCode string `json:"referralCode,omitempty"` // Only used when the OAuth login creates a new user
+		Reactivate   bool   `json:"reactivate,omitempty"`   // Log in to a deactivated account, reactivating it
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&oauthData); err != nil {
@@ -545,10 +548,38 @@ func OAuthHandler(w http.ResponseWriter, r *http.Request) {
 	err := collection.FindOne(ctx, bson.M{"email": strings.ToLower(oauthData.Email)}).Decode(&existingUser)
 
 	if err == nil {
+		status, err := services.GetAccountStatus(ctx, existingUser.ID)
+		if err != nil {
+			log.Printf("Failed to fetch account status for %s: %v", existingUser.Email, err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+		if status.DeletedAt != 0 || status.DeletionStatus != "" {
+			http.Error(w, "This account has been deleted", http.StatusForbidden)
+			return
+		}
+		if rejectSuspended(ctx, w, existingUser.ID) {
+			return
+		}
+		if status.DeactivatedAt != 0 {
+			if !oauthData.Reactivate {
+				http.Error(w, "Account is deactivated; log in with \"reactivate\": true to reactivate it", http.StatusForbidden)
+				return
+			}
+			if err := services.ReactivateUser(ctx, existingUser.ID); err != nil {
+				log.Printf("Failed to reactivate %s: %v", existingUser.Email, err)
+				http.Error(w, "Failed to reactivate account", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("✅ Account %s reactivated", existingUser.Email)
+		}
+		recordLogin(r, existingUser.ID, services.LoginOAuth, oauthData.Provider)
+
 		// User exists, generate JWT token
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 			"email": existingUser.Email,
 			"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+			"iat":   time.Now().Unix(),
 		})
 
 		tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
@@ -574,21 +605,44 @@ func OAuthHandler(w http.ResponseWriter, r *http.Request) {
 		ID:        primitive.NewObjectID(),
 		Email:     strings.ToLower(oauthData.Email),
 		Name:      oauthData.Name,
-		Password:  "",  // OAuth users don't have passwords
-		Credits:   200, // Starting credits
+		Password:  "", // OAuth users don't have passwords
+		Credits:   0,  // Starting credits come from the signup bonus below
 		CreatedAt: time.Now().Unix(),
 	}
 
-	_, err = collection.InsertOne(ctx, user)
+	err = config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if _, err := collection.InsertOne(sessCtx, user); err != nil {
+			return err
+		}
+		entry, err := services.AwardBonusTx(sessCtx, user.ID, services.BonusSignup)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			user.Credits = entry.BalanceAfter
+		}
+		referral, err := services.RecordSignupTx(sessCtx, user, oauthData.ReferralCode, signupContext(r))
+		if err != nil {
+			return err
+		}
+		return events.Record(sessCtx, events.UserRegistered, user.ID, userRegisteredData(user, oauthData.Provider, referral))
+	})
+	if errors.Is(err, services.ErrInvalidReferralCode) {
+		http.Error(w, "Invalid referral code", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
+		log.Printf("Failed to create OAuth user: %v", err)
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	recordLogin(r, user.ID, services.LoginSignup, oauthData.Provider)
 
 	// Generate JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"email": user.Email,
 		"exp":   time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+		"iat":   time.Now().Unix(),
 	})
 
 	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
@@ -607,3 +661,16 @@ func OAuthHandler(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(response)
 }
+
+// userRegisteredData is the user.registered payload for a newly created user
+func userRegisteredData(user models.User, provider string, referral *services.Referral) events.UserRegisteredData {
+	data := events.UserRegisteredData{
+		Email:    user.Email,
+		Name:     user.Name,
+		Provider: provider,
+	}
+	if referral != nil && referral.Status == services.ReferralPending {
+		data.ReferredBy = referral.ReferrerID.Hex()
+	}
+	return data
+}
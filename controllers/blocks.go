@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListBlocksHandler returns the users the current user has blocked
+func ListBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	viewer := profileViewer(ctx, r)
+	if !viewer.LoggedIn {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	blocks, err := services.ListBlocks(ctx, viewer.UserID)
+	if err != nil {
+		log.Printf("Failed to list blocks of %s: %v", viewer.UserID.Hex(), err)
+		http.Error(w, "Failed to fetch blocked users", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  blocks,
+		"count": len(blocks),
+	})
+}
+
+// BlockUserHandler blocks a user for the current user
+func BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	blockedID, err := primitive.ObjectIDFromHex(mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	viewer := profileViewer(ctx, r)
+	if !viewer.LoggedIn {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	block, err := services.BlockUser(ctx, viewer.UserID, blockedID)
+	switch {
+	case errors.Is(err, services.ErrCannotBlockSelf):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to block %s for %s: %v", blockedID.Hex(), viewer.UserID.Hex(), err)
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "User blocked",
+		"block":   block,
+	})
+}
+
+// UnblockUserHandler lifts one of the current user's blocks
+func UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	blockedID, err := primitive.ObjectIDFromHex(mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	viewer := profileViewer(ctx, r)
+	if !viewer.LoggedIn {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := services.UnblockUser(ctx, viewer.UserID, blockedID); err != nil {
+		log.Printf("Failed to unblock %s for %s: %v", blockedID.Hex(), viewer.UserID.Hex(), err)
+		http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "User unblocked",
+	})
+}
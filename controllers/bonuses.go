@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminListBonusRulesHandler returns every one-time bonus rule (admin only)
+func AdminListBonusRulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rules, err := services.ListBonusRules(ctx)
+	if err != nil {
+		log.Printf("Failed to list bonus rules: %v", err)
+		http.Error(w, "Failed to fetch bonus rules", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  rules,
+		"count": len(rules),
+	})
+}
+
+// AdminUpdateBonusRuleHandler creates or replaces a bonus rule (admin only)
+func AdminUpdateBonusRuleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Description   string `json:"description"`
+		Amount        int    `json:"amount"`
+		ExpiresInDays int    `json:"expiresInDays"`
+		Enabled       *bool  `json:"enabled"` // Required, so leaving it out never disables a rule
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Enabled == nil {
+		http.Error(w, "enabled is required", http.StatusBadRequest)
+		return
+	}
+	rule := services.BonusRule{
+		Key:           mux.Vars(r)["key"],
+		Description:   request.Description,
+		Amount:        request.Amount,
+		ExpiresInDays: request.ExpiresInDays,
+		Enabled:       *request.Enabled,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	saved, err := services.SaveBonusRule(ctx, rule, adminEmail)
+	if errors.Is(err, services.ErrInvalidBonusRule) || errors.Is(err, services.ErrBonusRuleNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save bonus rule: %v", err)
+		http.Error(w, "Failed to save bonus rule", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Bonus rule %s updated by %s", saved.Key, adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Bonus rule saved",
+		"rule":    saved,
+	})
+}
+
+// AwardBonusHandler lets other services trigger a one-time bonus, e.g. first_review
+// from the reviews service or email_verified once an address is confirmed
+func AwardBonusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var request struct {
+		UserId string `json:"userId"`
+		Rule   string `json:"rule"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(request.UserId)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry, err := services.AwardBonus(ctx, objectID, request.Rule)
+	switch {
+	case errors.Is(err, services.ErrBonusRuleNotFound):
+		http.Error(w, "Bonus rule not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrBonusAlreadyGranted):
+		// Idempotent: awarding twice is not an error for the caller
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Bonus already granted",
+			"granted": false,
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to award bonus %s to %s: %v", request.Rule, request.UserId, err)
+		http.Error(w, "Failed to award bonus", http.StatusInternalServerError)
+		return
+	}
+
+	if entry == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Bonus rule is disabled",
+			"granted": false,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Bonus granted",
+		"granted": true,
+		"entry":   entry,
+	})
+}
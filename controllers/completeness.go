@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminListCompletenessItemsHandler returns every profile completeness item with its weight (admin only)
+func AdminListCompletenessItemsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := services.ListCompletenessItems(ctx)
+	if err != nil {
+		log.Printf("Failed to list completeness items: %v", err)
+		http.Error(w, "Failed to fetch completeness items", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  items,
+		"count": len(items),
+	})
+}
+
+// AdminUpdateCompletenessWeightHandler sets the weight of a profile completeness item (admin only)
+func AdminUpdateCompletenessWeightHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Weight *int `json:"weight"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Weight == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err := services.SaveCompletenessWeight(ctx, mux.Vars(r)["key"], *request.Weight, adminEmail)
+	switch {
+	case errors.Is(err, services.ErrCompletenessItemNotFound):
+		http.Error(w, "Completeness item not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrInvalidCompletenessWeight):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Failed to save completeness weight: %v", err)
+		http.Error(w, "Failed to save completeness weight", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Completeness weight %s set to %d by %s", item.Key, item.Weight, adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Completeness weight saved",
+		"item":    item,
+	})
+}
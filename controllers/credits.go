@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetCreditLotsHandler returns the current user's credit lots with their source and expiry
+func GetCreditLotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	lots, err := services.ListLots(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to list credit lots for %s: %v", email, err)
+		http.Error(w, "Failed to fetch credit lots", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credits": user.Credits,
+		"data":    lots,
+		"count":   len(lots),
+	})
+}
+
+// GetCreditStatementHandler returns the current user's credit statement for a period as JSON, CSV or PDF
+func GetCreditStatementHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	from, to, err := parseStatementPeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "pdf" {
+		http.Error(w, "Format must be json, csv or pdf", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	statement, err := services.BuildStatement(ctx, user.ID, from, to)
+	if err != nil {
+		log.Printf("Failed to build statement for %s: %v", email, err)
+		http.Error(w, "Failed to build statement", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("trademinutes-statement-%s-%s", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		err = services.WriteStatementCSV(w, user, statement)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.pdf"`)
+		err = services.WriteStatementPDF(w, user, statement)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(statement)
+	}
+	if err != nil {
+		log.Printf("Failed to write %s statement for %s: %v", format, email, err)
+	}
+}
+
+// AdminExportStatementsHandler streams every user's statement for a period as one CSV (admin only)
+func AdminExportStatementsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	from, to, err := parseStatementPeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Large exports can take a while; stop when the client goes away
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="trademinutes-statements-%s-%s.csv"`, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+
+	if err := services.ExportStatementsCSV(ctx, w, from, to, flush); err != nil {
+		// Headers are already sent, so all we can do is log and cut the stream short
+		log.Printf("❌ Statement export failed: %v", err)
+	}
+}
+
+// parseStatementPeriod reads the inclusive from/to dates (YYYY-MM-DD, UTC) of a
+// statement, defaulting to the current month. The returned end is exclusive.
+func parseStatementPeriod(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid from date, expected YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid to date, expected YYYY-MM-DD")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// GetCreditHistoryHandler returns the current user's ledger, newest first, with
+// the refund status of each deduction (?limit=50&page=1)
+func GetCreditHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := int64(50)
+	if v, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && v > 0 && v <= 200 {
+		limit = v
+	}
+	page := int64(1)
+	if v, err := strconv.ParseInt(r.URL.Query().Get("page"), 10, 64); err == nil && v > 0 {
+		page = v
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	history, total, err := services.ListHistory(ctx, user.ID, limit, (page-1)*limit)
+	if err != nil {
+		log.Printf("Failed to list credit history for %s: %v", email, err)
+		http.Error(w, "Failed to fetch credit history", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credits": user.Credits,
+		"data":    history,
+		"count":   len(history),
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// ReverseCreditsHandler refunds all or part of a deduction, e.g. when a booking
+// is cancelled. Mounted for other services (service key) and for admins.
+func ReverseCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var request struct {
+		TransactionId  string `json:"transactionId"`
+		Amount         int    `json:"amount"` // Omit or 0 to refund everything not yet refunded
+		Reason         string `json:"reason"`
+		IdempotencyKey string `json:"idempotencyKey"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	entryID, err := primitive.ObjectIDFromHex(request.TransactionId)
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	// Admin calls carry the admin's email; service calls have none
+	actor, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		actor = "service"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reversal, original, err := services.ReverseEntry(ctx, services.ReversalRequest{
+		EntryID:        entryID,
+		Amount:         request.Amount,
+		Reason:         request.Reason,
+		Actor:          actor,
+		IdempotencyKey: request.IdempotencyKey,
+	})
+	switch {
+	case errors.Is(err, services.ErrEntryNotFound):
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrEntryNotReversible), errors.Is(err, services.ErrInvalidRefundAmount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrRefundExceedsCharge):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to reverse transaction %s: %v", request.TransactionId, err)
+		http.Error(w, "Failed to reverse transaction", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("✅ Refunded %d credits of transaction %s (%s)", reversal.Amount, request.TransactionId, actor)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":           "Credits refunded",
+		"reversal":          reversal,
+		"refundStatus":      original.RefundStatus(),
+		"refundable":        original.Refundable(),
+		"remaining_credits": reversal.BalanceAfter,
+	})
+}
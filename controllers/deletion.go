@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminGetDeletionHandler returns the progress of a deletion, including each
+// participant service's acknowledgement (admin only)
+func AdminGetDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid deletion ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request, err := services.GetDeletion(ctx, id)
+	if errors.Is(err, services.ErrDeletionNotFound) {
+		http.Error(w, "Deletion not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch deletion %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to fetch deletion", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deletion": request,
+	})
+}
+
+// AdminRetryDeletionHandler re-sends a pending or timed out deletion to the
+// services that have not confirmed yet and restarts its timeout (admin only)
+func AdminRetryDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid deletion ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request, err := services.RetryDeletion(ctx, id)
+	switch {
+	case errors.Is(err, services.ErrDeletionNotFound):
+		http.Error(w, "Deletion not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrDeletionNotRetrying):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to retry deletion %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to retry deletion", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Deletion retried",
+		"deletion": request,
+	})
+}
+
+// AckDeletionHandler lets a participant service report that it has deleted
+// (or failed to delete) a user's data (service key required).
+// Body: {"service": "tasks", "status": "done"|"failed", "error": "..."}
+func AckDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid deletion ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Service string `json:"service"`
+		Status  string `json:"status"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Status != services.ParticipantDone && request.Status != services.ParticipantFailed {
+		http.Error(w, "Status must be done or failed", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	deletion, err := services.AckDeletion(ctx, id, request.Service, request.Status == services.ParticipantDone, request.Error)
+	switch {
+	case errors.Is(err, services.ErrDeletionNotFound):
+		http.Error(w, "Deletion not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrUnknownParticipant):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Failed to acknowledge deletion %s for %s: %v", id.Hex(), request.Service, err)
+		http.Error(w, "Failed to acknowledge deletion", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Acknowledgement recorded",
+		"status":  deletion.Status,
+	})
+}
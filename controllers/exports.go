@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RequestDataExportHandler queues an export of everything stored about the
+// current user. The user is notified with a download link once it is built.
+func RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	export, err := services.RequestExport(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to queue data export for %s: %v", email, err)
+		http.Error(w, "Failed to request data export", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("📦 Data export %s requested by %s", export.ID.Hex(), email)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Data export requested; you will be notified when it is ready",
+		"export":  export,
+	})
+}
+
+// GetDataExportHandler returns the status of one of the current user's
+// exports, with a signed download link once it is ready
+func GetDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	exportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	export, err := services.GetExport(ctx, user.ID, exportID)
+	if errors.Is(err, services.ErrExportNotFound) {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch data export %s: %v", exportID.Hex(), err)
+		http.Error(w, "Failed to fetch data export", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"export": export,
+	}
+	if export.Status == services.ExportReady {
+		response["downloadUrl"] = services.ExportDownloadURL(export)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// DownloadDataExportHandler serves an export archive. It needs no login: the
+// signed, expiring link is the credential.
+func DownloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := r.URL.Query()
+	export, err := services.OpenExportDownload(ctx, mux.Vars(r)["id"], query.Get("expires"), query.Get("signature"))
+	switch {
+	case errors.Is(err, services.ErrInvalidExportLink):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrExportNotFound), errors.Is(err, services.ErrExportNotReady):
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to open data export: %v", err)
+		http.Error(w, "Failed to download data export", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(export.FilePath)
+	if err != nil {
+		log.Printf("Failed to open data export %s: %v", export.ID.Hex(), err)
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="trademinutes-data-`+export.ID.Hex()+`.zip"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, "", time.Unix(export.CompletedAt, 0), file)
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trademinutes-user/services"
+)
+
+// MatchUsersHandler ranks users who can help with a set of skills, explaining
+// each score. Skills are given as ?skills=Go,Python or repeated ?skill=.
+func MatchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	query := r.URL.Query()
+	request := services.UserMatchRequest{College: query.Get("college")}
+	for _, list := range query["skills"] {
+		request.Skills = append(request.Skills, strings.Split(list, ",")...)
+	}
+	request.Skills = append(request.Skills, query["skill"]...)
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		request.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	viewer := profileViewer(ctx, r)
+	if !viewer.LoggedIn {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := services.MatchUsers(ctx, request, viewer)
+	switch {
+	case errors.Is(err, services.ErrNoMatchSkills):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("User matching failed: %v", err)
+		http.Error(w, "Failed to match users", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trademinutes-user/services"
+)
+
+// NearbyUsersHandler finds users within a radius of a point, nearest first.
+// Only approximate positions and rounded distances are returned. A token is
+// optional; it decides whose locations may be searched.
+func NearbyUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	query := r.URL.Query()
+	var search services.NearbySearch
+	var err error
+	if search.Latitude, err = strconv.ParseFloat(query.Get("lat"), 64); err != nil {
+		http.Error(w, "lat is required and must be a number", http.StatusBadRequest)
+		return
+	}
+	if search.Longitude, err = strconv.ParseFloat(query.Get("lng"), 64); err != nil {
+		http.Error(w, "lng is required and must be a number", http.StatusBadRequest)
+		return
+	}
+	if radius := query.Get("radiusKm"); radius != "" {
+		if search.RadiusKm, err = strconv.ParseFloat(radius, 64); err != nil || search.RadiusKm <= 0 {
+			http.Error(w, services.ErrInvalidRadius.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		search.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := services.NearbyUsers(ctx, search, profileViewer(ctx, r))
+	switch {
+	case errors.Is(err, services.ErrInvalidCoordinates), errors.Is(err, services.ErrInvalidRadius):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Nearby search failed: %v", err)
+		http.Error(w, "Failed to find nearby users", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetPrivacyHandler returns who may see the current user's private profile fields
+func GetPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	settings, err := services.GetPrivacySettings(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to fetch privacy settings for %s: %v", email, err)
+		http.Error(w, "Failed to fetch privacy settings", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"privacy":      settings,
+		"visibilities": services.Visibilities,
+	})
+}
+
+// UpdatePrivacyHandler changes who may see some of the current user's private profile fields
+func UpdatePrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var updates services.PrivacySettings
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	settings, err := services.UpdatePrivacySettings(ctx, user.ID, updates)
+	if writeValidationError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update privacy settings for %s: %v", email, err)
+		http.Error(w, "Failed to update privacy settings", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Privacy settings updated",
+		"privacy": settings,
+	})
+}
+
+// profileViewer identifies who is asking for a profile. Requests without a
+// token, or from a user that no longer exists, are anonymous.
+func profileViewer(ctx context.Context, r *http.Request) services.Viewer {
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		return services.Viewer{}
+	}
+	var user models.User
+	err := config.GetDB().Collection("MyClusterCol").FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		return services.Viewer{}
+	}
+	return services.Viewer{UserID: user.ID, LoggedIn: true}
+}
@@ -4,21 +4,27 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"trademinutes-user/config"
+	"trademinutes-user/events"
 	"trademinutes-user/middleware"
+	"trademinutes-user/services"
 	"trademinutes-user/utils"
 
 	"github.com/ElioCloud/shared-models/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // GetProfileHandler returns the current user's profile
@@ -43,20 +49,31 @@ func GetProfileHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	var profile services.UserProfile
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&profile)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Remove password from response
-	user.Password = ""
+	profile.Password = ""
+
+	completeness, err := services.GetProfileCompleteness(ctx, profile.User)
+	if err != nil {
+		log.Printf("Failed to score profile of %s: %v", email, err)
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+		return
+	}
 
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(struct {
+		services.UserProfile
+		Completeness *services.ProfileCompleteness `json:"completeness"`
+	}{profile, completeness})
 }
 
-// GetProfileByIDHandler returns a user's profile by ID
+// GetProfileByIDHandler returns a user's public profile by ID, with the
+// private fields the caller may see. A token is optional.
 func GetProfileByIDHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -81,21 +98,21 @@ func GetProfileByIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := config.GetDB().Collection("MyClusterCol")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user models.User
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
-	if err != nil {
+	profile, err := services.GetVisibleProfile(ctx, objectID)
+	if errors.Is(err, services.ErrUserNotFound) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to fetch profile %s: %v", userID, err)
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+		return
+	}
 
-	// Remove password from response
-	user.Password = ""
-
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(services.NewPublicProfile(*profile, profileViewer(ctx, r)))
 }
 
 // UpdateProfileInfoHandler updates user profile information
@@ -116,90 +133,256 @@ func UpdateProfileInfoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req models.User
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		log.Printf("Error decoding request body: %v\n", err)
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
+	// Update only non-empty values, once they pass validation
+	update, err := profileInfoUpdate(body)
+	if writeValidationError(w, err) {
+		return
+	}
+
 	collection := config.GetDB().Collection("MyClusterCol")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	update := bson.M{}
-
-	// Update only non-zero values (if they are provided)
-	if req.Program != "" {
-		update["program"] = req.Program
-	}
-	if req.Location != "" {
-		update["location"] = req.Location
-	}
-	if req.College != "" {
-		update["college"] = req.College
-	}
-	if req.YearOfStudy != "" {
-		update["yearOfStudy"] = req.YearOfStudy
-	}
-	if req.Bio != "" {
-		update["bio"] = req.Bio
-	}
-	if len(req.Skills) > 0 {
-		update["skills"] = req.Skills
-	}
-	if req.ProfilePictureURL != "" {
-		update["profilePictureURL"] = req.ProfilePictureURL
-	}
-	if (req.Stats != models.ProfileStats{}) {
-		update["stats"] = req.Stats
-	}
-	if len(req.Achievements) > 0 {
-		update["achievements"] = req.Achievements
-	}
-
 	// Check if profile was previously incomplete
 	var existingUser models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&existingUser)
+	err = collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&existingUser)
 	if err != nil {
 		log.Printf("Failed to fetch existing user: %v\n", err)
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	wasIncomplete := existingUser.College == "" || existingUser.Program == "" || existingUser.YearOfStudy == ""
-	isNowComplete := req.College != "" && req.Program != "" && req.YearOfStudy != ""
+	if skills, ok := update["skills"].([]string); ok {
+		if update["skills"], err = services.NormalizeSkills(ctx, skills); err != nil {
+			log.Printf("Failed to normalize skills: %v\n", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	// If profile is being completed for the first time, set credits to 200 (not increment)
-	if wasIncomplete && isNowComplete {
-		update["credits"] = 200
+	if location, ok := update["location"].(string); ok {
+		place, err := services.ResolveLocation(ctx, location, existingUser.Latitude, existingUser.Longitude)
+		if err != nil {
+			log.Printf("Failed to resolve location: %v\n", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+		// A null place clears the previous location's
+		update["place"] = place
 	}
 
+	wasIncomplete := !services.ProfileComplete(existingUser)
+	isNowComplete := (update["college"] != nil || existingUser.College != "") &&
+		(update["program"] != nil || existingUser.Program != "") &&
+		(update["yearOfStudy"] != nil || existingUser.YearOfStudy != "")
+
 	if len(update) == 0 {
 		http.Error(w, "No valid fields to update", http.StatusBadRequest)
 		return
 	}
 
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"email": email},
-		bson.M{"$set": update},
-	)
-
+	err = updateProfileFields(ctx, existingUser.ID, update)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update profile: %v\n", err)
 		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
 
-	if result.MatchedCount == 0 {
+	if wasIncomplete && isNowComplete {
+		profileCompleted(ctx, existingUser.ID, email)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Profile updated successfully",
+	})
+}
+
+// PatchProfileHandler edits the current user's profile with a JSON Merge
+// Patch (application/merge-patch+json, or plain application/json) or a JSON
+// Patch (application/json-patch+json) and returns the updated profile.
+// Unlike update-info, it can clear fields.
+func PatchProfileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		format = ""
+	}
+	if format == "application/json" {
+		format = services.MergePatch
+	}
+	if format != services.MergePatch && format != services.JSONPatch {
+		w.Header().Set("Accept-Patch", services.MergePatch+", "+services.JSONPatch)
+		http.Error(w, "Unsupported patch format", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(r.Body, maxProfilePatchSize+1))
+	if err != nil || len(patch) > maxProfilePatchSize {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := services.PatchProfile(ctx, user.ID, format, patch)
+	if writeValidationError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidPatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, services.ErrPatchTestFailed), errors.Is(err, services.ErrProfileConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
+	case err != nil:
+		log.Printf("Failed to patch profile of %s: %v\n", email, err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
 	}
 
+	if len(result.Set) > 0 || len(result.Removed) > 0 {
+		log.Printf("✏️  Profile of %s patched (set %v, removed %v)", email, result.Set, result.Removed)
+	}
+	if !services.ProfileComplete(result.Before) && services.ProfileComplete(result.After) {
+		profileCompleted(ctx, user.ID, email)
+	}
+
+	json.NewEncoder(w).Encode(result.After)
+}
+
+// profileInfoFields are the fields update-info accepts, keyed by their lowercased name
+var profileInfoFields = map[string]string{
+	"program":     "program",
+	"location":    "location",
+	"college":     "college",
+	"yearofstudy": "yearOfStudy",
+	"bio":         "bio",
+	"skills":      "skills",
+}
+
+// profileInfoUpdate validates an update-info body and returns the fields to
+// set. Names match case-insensitively, as they did when the body was decoded
+// into a user; empty values and unknown fields are ignored, but system-owned
+// fields are rejected.
+func profileInfoUpdate(body map[string]json.RawMessage) (bson.M, error) {
+	invalid := &services.ValidationError{}
+	fields := map[string]interface{}{}
+	for key, raw := range body {
+		if services.IsSystemOwnedField(key) {
+			if string(raw) != "null" {
+				invalid.Add(key, "is managed by the server and cannot be set")
+			}
+			continue
+		}
+		name, ok := profileInfoFields[strings.ToLower(key)]
+		if !ok {
+			continue
+		}
+		if name == "skills" {
+			var skills []string
+			if err := json.Unmarshal(raw, &skills); err != nil {
+				invalid.Add(name, "must be a list of strings")
+			} else if len(skills) > 0 {
+				fields[name] = skills
+			}
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			invalid.Add(name, "must be a string")
+		} else if value != "" {
+			fields[name] = value
+		}
+	}
+
+	clean, err := services.ValidateProfileFields(fields)
+	var fieldErrors *services.ValidationError
+	if errors.As(err, &fieldErrors) {
+		invalid.Fields = append(invalid.Fields, fieldErrors.Fields...)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+
+	update := bson.M{}
+	for name, value := range clean {
+		if value != "" {
+			update[name] = value
+		}
+	}
+	return update, nil
+}
+
+// writeValidationError answers 422 with every invalid field if err is a
+// validation error, and reports whether it did
+func writeValidationError(w http.ResponseWriter, err error) bool {
+	var invalid *services.ValidationError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	sort.Slice(invalid.Fields, func(i, j int) bool { return invalid.Fields[i].Field < invalid.Fields[j].Field })
+	w.WriteHeader(http.StatusUnprocessableEntity)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Profile updated successfully",
+		"error":  "Validation failed",
+		"fields": invalid.Fields,
 	})
+	return true
+}
+
+// maxProfilePatchSize caps the size of a profile patch body in bytes
+const maxProfilePatchSize = 64 << 10
+
+// profileCompleted awards the one-time profile completion bonus and completes
+// the user's referral. The ledger guarantees the bonus is only granted once.
+func profileCompleted(ctx context.Context, userID primitive.ObjectID, email string) {
+	_, err := services.AwardBonus(ctx, userID, services.BonusProfileCompletion)
+	if err != nil && !errors.Is(err, services.ErrBonusAlreadyGranted) {
+		log.Printf("Failed to award profile completion bonus to %s: %v\n", email, err)
+	}
+	if err := services.CompleteReferral(ctx, userID); err != nil {
+		log.Printf("Failed to complete referral for %s: %v\n", email, err)
+	}
 }
 
 // UploadImageHandler handles profile picture uploads
@@ -278,7 +461,7 @@ func UploadImageHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	var existingUser models.User
-	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&existingUser)
+	err = collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&existingUser)
 	if err != nil {
 		log.Printf("Failed to fetch existing user: %v", err)
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -289,25 +472,20 @@ func UploadImageHandler(w http.ResponseWriter, r *http.Request) {
 	oldProfilePictureURL := existingUser.ProfilePictureURL
 
 	// Update user's profile picture URL in database
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"email": email},
-		bson.M{"$set": bson.M{"profilePictureURL": imageData}},
-	)
+	err = updateProfileFields(ctx, existingUser.ID, bson.M{"profilePictureURL": imageData})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update profile picture: %v\n", err)
 		http.Error(w, "Failed to save profile picture", http.StatusInternalServerError)
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
 	// Clean up old profile picture if it exists and is different
 	if oldProfilePictureURL != "" && oldProfilePictureURL != imageData {
-		go cleanupOldProfilePicture(oldProfilePictureURL)
+		go cleanupOldProfilePicture(oldProfilePictureURL, email)
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -382,7 +560,7 @@ func UploadCoverImageHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Fallback to base64 storage
 		log.Printf("⚠️  Cloudinary upload failed for user %s: %v, falling back to base64", email, err)
-		log.Printf("🔍 Cloudinary config check - Cloud Name: %s, API Key: %s, API Secret: %s",
+		log.Printf("🔍 Cloudinary config check - Cloud Name: %s, API Key: %s, API Secret set: %t",
 			os.Getenv("CLOUDINARY_CLOUD_NAME"),
 			os.Getenv("CLOUDINARY_API_KEY"),
 			os.Getenv("CLOUDINARY_API_SECRET") != "")
@@ -396,7 +574,7 @@ func UploadCoverImageHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	var existingUser models.User
-	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&existingUser)
+	err = collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&existingUser)
 	if err != nil {
 		log.Printf("Failed to fetch existing user: %v", err)
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -407,25 +585,20 @@ func UploadCoverImageHandler(w http.ResponseWriter, r *http.Request) {
 	oldCoverImageURL := existingUser.CoverImageURL
 
 	// Update user's cover image URL in database
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"email": email},
-		bson.M{"$set": bson.M{"coverImageURL": imageData}},
-	)
+	err = updateProfileFields(ctx, existingUser.ID, bson.M{"coverImageURL": imageData})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update cover image: %v\n", err)
 		http.Error(w, "Failed to save cover image", http.StatusInternalServerError)
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
 	// Clean up old cover image if it exists and is different
 	if oldCoverImageURL != "" && oldCoverImageURL != imageData {
-		go cleanupOldCoverImage(oldCoverImageURL)
+		go cleanupOldCoverImage(oldCoverImageURL, email)
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -434,10 +607,36 @@ func UploadCoverImageHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// updateProfileFields sets fields on a user's profile and records a
+// user.profile_updated event listing them, in one transaction
+func updateProfileFields(ctx context.Context, userID primitive.ObjectID, fields bson.M) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		result, err := config.GetDB().Collection("MyClusterCol").UpdateOne(sessCtx, services.NotDeleted(bson.M{"_id": userID}), bson.M{"$set": fields})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return events.Record(sessCtx, events.ProfileUpdated, userID, events.ProfileUpdatedData{Fields: names})
+	})
+}
+
 // Helper functions for cleanup
-func cleanupOldProfilePicture(oldProfilePictureURL string) error {
+func cleanupOldProfilePicture(oldProfilePictureURL, email string) error {
 	// Check if it's a Cloudinary URL
 	if strings.Contains(oldProfilePictureURL, "cloudinary.com") {
+		// Only images uploaded for this user are theirs to delete
+		if !utils.IsUploadedBy(oldProfilePictureURL, email) {
+			log.Printf("⚠️  Not deleting old profile picture of %s: it was not uploaded for them", email)
+			return nil
+		}
 		publicID := utils.ExtractPublicIDFromURL(oldProfilePictureURL)
 		if publicID != "" {
 			if err := utils.DeleteImageFromCloudinary(publicID); err != nil {
@@ -454,9 +653,14 @@ func cleanupOldProfilePicture(oldProfilePictureURL string) error {
 	return nil
 }
 
-func cleanupOldCoverImage(oldCoverImageURL string) error {
+func cleanupOldCoverImage(oldCoverImageURL, email string) error {
 	// Check if it's a Cloudinary URL
 	if strings.Contains(oldCoverImageURL, "cloudinary.com") {
+		// Only images uploaded for this user are theirs to delete
+		if !utils.IsUploadedBy(oldCoverImageURL, email) {
+			log.Printf("⚠️  Not deleting old cover image of %s: it was not uploaded for them", email)
+			return nil
+		}
 		publicID := utils.ExtractPublicIDFromURL(oldCoverImageURL)
 		if publicID != "" {
 			if err := utils.DeleteImageFromCloudinary(publicID); err != nil {
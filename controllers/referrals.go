@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetReferralsHandler returns the current user's referral code, share link and referral stats
+func GetReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	dashboard, err := services.GetReferralDashboard(ctx, user)
+	if err != nil {
+		log.Printf("Failed to build referral dashboard for %s: %v", email, err)
+		http.Error(w, "Failed to fetch referrals", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(dashboard)
+}
+
+// signupContext extracts the client IP and device ID used for referral fraud checks
+func signupContext(r *http.Request) services.SignupContext {
+	return services.SignupContext{
+		IP:       clientIP(r),
+		DeviceID: strings.TrimSpace(r.Header.Get("X-Device-ID")),
+	}
+}
+
+// clientIP returns the originating client IP. X-Forwarded-For is only
+// believed when the request comes from one of TRUSTED_PROXIES: its hops are
+// read from the right, and the first that is not a trusted proxy is the client.
+// Hops further left are whatever the client sent, so they are never used.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	proxies := config.TrustedProxies()
+	trusted := func(addr netip.Addr) bool {
+		for _, proxy := range proxies {
+			if proxy.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || !trusted(addr) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			break
+		}
+		if !trusted(addr) {
+			return addr.Unmap().String()
+		}
+	}
+	return host
+}
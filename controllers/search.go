@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trademinutes-user/services"
+)
+
+// SearchUsersHandler searches users by text, with filters, sorting, cursor
+// pagination and facet counts. A token is optional; it decides which private
+// fields are shown.
+func SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	query := r.URL.Query()
+	search := services.UserSearch{
+		Query:       query.Get("q"),
+		College:     query.Get("college"),
+		Program:     query.Get("program"),
+		YearOfStudy: query.Get("yearOfStudy"),
+		Skill:       query.Get("skill"),
+		Sort:        query.Get("sort"),
+		Cursor:      query.Get("cursor"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		search.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := services.SearchUsers(ctx, search, profileViewer(ctx, r))
+	switch {
+	case errors.Is(err, services.ErrInvalidSearchSort):
+		http.Error(w, "Invalid sort; use relevance (with q), newest, rating or name", http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrInvalidCursor):
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("User search failed: %v", err)
+		http.Error(w, "Failed to search users", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+)
+
+// SkillAutocompleteHandler suggests catalog skills for what the user has typed so far
+func SkillAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	skills, err := services.AutocompleteSkills(ctx, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		log.Printf("Skill autocomplete failed: %v", err)
+		http.Error(w, "Failed to fetch skills", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  skills,
+		"count": len(skills),
+	})
+}
+
+// AdminListSkillsHandler returns the whole skills catalog, merged skills included (admin only)
+func AdminListSkillsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	skills, err := services.ListSkills(ctx)
+	if err != nil {
+		log.Printf("Failed to list skills: %v", err)
+		http.Error(w, "Failed to fetch skills", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  skills,
+		"count": len(skills),
+	})
+}
+
+// AdminSaveSkillHandler adds a skill to the catalog or replaces its aliases and category (admin only)
+func AdminSaveSkillHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Name     string   `json:"name"`
+		Aliases  []string `json:"aliases"`
+		Category string   `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	skill, err := services.SaveSkill(ctx, request.Name, request.Aliases, request.Category, adminEmail)
+	switch {
+	case errors.Is(err, services.ErrInvalidSkill):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrSkillConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to save skill: %v", err)
+		http.Error(w, "Failed to save skill", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Skill %s saved by %s", skill.ID, adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Skill saved",
+		"skill":   skill,
+	})
+}
+
+// AdminMergeSkillsHandler merges duplicate skills into one and rewrites the
+// users listing them (admin only)
+func AdminMergeSkillsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Sources []string `json:"sources"`
+		Target  string   `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Rewriting users can take a while on a large catalog
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := services.MergeSkills(ctx, request.Sources, request.Target, adminEmail)
+	switch {
+	case errors.Is(err, services.ErrInvalidSkill):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrSkillNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrSkillConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to merge skills %v into %s: %v", request.Sources, request.Target, err)
+		http.Error(w, "Failed to merge skills; run the merge again to finish it", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🔀 Skills %v merged into %s by %s (%d users updated)", request.Sources, request.Target, adminEmail, result.UsersUpdated)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Skills merged",
+		"result":  result,
+	})
+}
+
+// SetSkillLevelsHandler replaces how well the current user says they know
+// each of their skills, e.g. {"Go": "expert", "Python": "beginner"}
+func SetSkillLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var levels map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	viewer := profileViewer(ctx, r)
+	if !viewer.LoggedIn {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	skillLevels, err := services.SetSkillLevels(ctx, viewer.UserID, levels)
+	if writeValidationError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrProfileConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to set skill levels of %s: %v", viewer.UserID.Hex(), err)
+		http.Error(w, "Failed to update skill levels", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Skill levels updated",
+		"skillLevels": skillLevels,
+	})
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminSuspendUserHandler suspends a user for a duration, or permanently (admin only)
+func AdminSuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		ReasonCode string `json:"reasonCode"`
+		Note       string `json:"note"`
+		Duration   string `json:"duration"` // e.g. "72h"; ignored for permanent bans
+		Permanent  bool   `json:"permanent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var duration time.Duration
+	if !request.Permanent {
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	adminEmail, _ := r.Context().Value(middleware.EmailKey).(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	suspension, err := services.SuspendUser(ctx, userID, request.ReasonCode, request.Note, duration, request.Permanent, adminEmail)
+	if err != nil {
+		writeSuspensionError(w, err)
+		return
+	}
+
+	log.Printf("⛔ User %s suspended by %s (%s)", userID.Hex(), adminEmail, request.ReasonCode)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "User suspended",
+		"suspension": suspension,
+	})
+}
+
+// AdminListSuspensionsHandler lists a user's suspensions, newest first, with the active one if any (admin only)
+func AdminListSuspensionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	suspensions, err := services.ListSuspensions(ctx, userID)
+	if err != nil {
+		writeSuspensionError(w, err)
+		return
+	}
+
+	var active *services.Suspension
+	for i := range suspensions {
+		if suspensions[i].Active() {
+			active = &suspensions[i]
+			break
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active":      active,
+		"suspensions": suspensions,
+	})
+}
+
+// AdminLiftSuspensionHandler ends an active suspension early (admin only)
+func AdminLiftSuspensionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	suspensionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid suspension ID", http.StatusBadRequest)
+		return
+	}
+
+	adminEmail, _ := r.Context().Value(middleware.EmailKey).(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	suspension, err := services.LiftSuspension(ctx, suspensionID, adminEmail)
+	if err != nil {
+		writeSuspensionError(w, err)
+		return
+	}
+
+	log.Printf("✅ Suspension %s of user %s lifted by %s", suspensionID.Hex(), suspension.UserID.Hex(), adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Suspension lifted",
+		"suspension": suspension,
+	})
+}
+
+// AdminUpdateAppealHandler records the status of an appeal against a
+// suspension; "granted" also lifts it (admin only)
+func AdminUpdateAppealHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	suspensionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid suspension ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	adminEmail, _ := r.Context().Value(middleware.EmailKey).(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	suspension, err := services.UpdateAppeal(ctx, suspensionID, request.Status, request.Note, adminEmail)
+	if err != nil {
+		writeSuspensionError(w, err)
+		return
+	}
+
+	log.Printf("📝 Appeal on suspension %s set to %s by %s", suspensionID.Hex(), request.Status, adminEmail)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Appeal updated",
+		"suspension": suspension,
+	})
+}
+
+func writeSuspensionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidReasonCode), errors.Is(err, services.ErrInvalidSuspension),
+		errors.Is(err, services.ErrInvalidAppealStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrSuspensionNotFound):
+		http.Error(w, "Suspension not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrSuspensionNotActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Suspension request failed: %v", err)
+		http.Error(w, "Failed to process suspension", http.StatusInternalServerError)
+	}
+}
+
+// rejectSuspended answers 403 with the reason if the user is suspended, and
+// reports whether it did
+func rejectSuspended(ctx context.Context, w http.ResponseWriter, userID primitive.ObjectID) bool {
+	suspension, err := services.ActiveSuspension(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to check suspension for %s: %v", userID.Hex(), err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return true
+	}
+	if suspension != nil {
+		http.Error(w, suspension.Message(), http.StatusForbidden)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetProfileByUsernameHandler returns a user's public profile by username,
+// with the private fields the caller may see. Old usernames redirect
+// permanently to the user's current one. A token is optional.
+func GetProfileByUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	profile, redirect, err := services.ResolveUsername(ctx, mux.Vars(r)["username"])
+	if errors.Is(err, services.ErrUsernameNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to resolve username: %v", err)
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+		return
+	}
+
+	if redirect != "" {
+		http.Redirect(w, r, "/api/profile/u/"+url.PathEscape(redirect), http.StatusMovedPermanently)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.NewPublicProfile(*profile, profileViewer(ctx, r)))
+}
+
+// SetUsernameHandler sets or changes the current user's username
+func SetUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	username, err := services.SetUsername(ctx, user.ID, strings.TrimSpace(request.Username))
+	switch {
+	case errors.Is(err, services.ErrInvalidUsername), errors.Is(err, services.ErrUsernameReserved):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrUsernameTaken):
+		http.Error(w, "Username is taken", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrUsernameCooldown):
+		message := "Username was changed too recently"
+		if ends, err := services.UsernameCooldownEnds(ctx, user.ID); err == nil && !ends.IsZero() {
+			message += "; it can be changed again after " + ends.UTC().Format(time.RFC3339)
+		}
+		http.Error(w, message, http.StatusTooManyRequests)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to set username for %s: %v", email, err)
+		http.Error(w, "Failed to set username", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🏷️  %s is now @%s", email, username.Username)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Username updated",
+		"username": username.Username,
+	})
+}
+
+// CheckUsernameHandler reports whether the current user could take a username
+func CheckUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	collection := config.GetDB().Collection("MyClusterCol")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := collection.FindOne(ctx, services.NotDeleted(bson.M{"email": email})).Decode(&user)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"username":  username,
+		"available": true,
+	}
+	err = services.CheckUsername(ctx, username, user.ID)
+	switch {
+	case errors.Is(err, services.ErrInvalidUsername), errors.Is(err, services.ErrUsernameReserved),
+		errors.Is(err, services.ErrUsernameTaken):
+		response["available"] = false
+		response["reason"] = err.Error()
+	case err != nil:
+		log.Printf("Failed to check username %q: %v", username, err)
+		http.Error(w, "Failed to check username", http.StatusInternalServerError)
+		return
+	}
+
+	ends, err := services.UsernameCooldownEnds(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to check username cooldown for %s: %v", email, err)
+		http.Error(w, "Failed to check username", http.StatusInternalServerError)
+		return
+	}
+	if !ends.IsZero() {
+		response["changeAllowedAfter"] = ends.Unix()
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
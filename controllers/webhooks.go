@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trademinutes-user/middleware"
+	"trademinutes-user/services"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// webhookRequest is the body accepted when creating or updating a subscription
+type webhookRequest struct {
+	URL         string   `json:"url"`
+	EventTypes  []string `json:"eventTypes"`
+	Secret      string   `json:"secret"` // Optional on create; ignored on update
+	Description string   `json:"description"`
+	Active      *bool    `json:"active"` // Update only, defaults to true
+}
+
+// AdminCreateWebhookHandler registers a webhook subscription (admin only).
+// The signing secret is returned in this response and never again.
+func AdminCreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	adminEmail, ok := r.Context().Value(middleware.EmailKey).(string)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subscription, secret, err := services.CreateWebhook(ctx, services.WebhookSubscription{
+		URL:         request.URL,
+		EventTypes:  request.EventTypes,
+		Secret:      request.Secret,
+		Description: request.Description,
+	}, adminEmail)
+	if errors.Is(err, services.ErrInvalidWebhookURL) || errors.Is(err, services.ErrInvalidWebhookEvent) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create webhook: %v", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Webhook %s to %s created by %s", subscription.ID.Hex(), subscription.URL, adminEmail)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Webhook created",
+		"webhook": subscription,
+		"secret":  secret,
+	})
+}
+
+// AdminListWebhooksHandler returns every webhook subscription (admin only)
+func AdminListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subscriptions, err := services.ListWebhooks(ctx)
+	if err != nil {
+		log.Printf("Failed to list webhooks: %v", err)
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  subscriptions,
+		"count": len(subscriptions),
+	})
+}
+
+// AdminUpdateWebhookHandler changes a subscription's URL, event types, description or active flag (admin only)
+func AdminUpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	var request webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	active := request.Active == nil || *request.Active
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subscription, err := services.UpdateWebhook(ctx, id, services.WebhookSubscription{
+		URL:         request.URL,
+		EventTypes:  request.EventTypes,
+		Description: request.Description,
+		Active:      active,
+	})
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrInvalidWebhookURL), errors.Is(err, services.ErrInvalidWebhookEvent):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Failed to update webhook %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Webhook updated",
+		"webhook": subscription,
+	})
+}
+
+// AdminDeleteWebhookHandler removes a webhook subscription (admin only)
+func AdminDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = services.DeleteWebhook(ctx, id)
+	if errors.Is(err, services.ErrWebhookNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete webhook %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Webhook deleted",
+	})
+}
+
+// AdminListWebhookDeliveriesHandler returns a subscription's recent deliveries
+// with their attempt logs (?status=pending|succeeded|dead&limit=50, admin only)
+func AdminListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := int64(50)
+	if v, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && v > 0 && v <= 200 {
+		limit = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deliveries, err := services.ListWebhookDeliveries(ctx, id, r.URL.Query().Get("status"), limit)
+	if err != nil {
+		log.Printf("Failed to list deliveries for webhook %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":  deliveries,
+		"count": len(deliveries),
+	})
+}
+
+// AdminRedeliverWebhookHandler sends a delivery again, e.g. once a dead endpoint is fixed (admin only)
+func AdminRedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["deliveryId"])
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	delivery, err := services.Redeliver(ctx, id)
+	if errors.Is(err, services.ErrDeliveryNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to redeliver %s: %v", id.Hex(), err)
+		http.Error(w, "Failed to redeliver", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Delivery queued",
+		"delivery": delivery,
+	})
+}
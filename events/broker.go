@@ -0,0 +1,164 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"trademinutes-user/config"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Broker publishes outbox messages. Publish must only return nil once the
+// broker has accepted the message; the relay retries anything else.
+type Broker interface {
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// NewBrokerFromEnv builds the broker selected by EVENT_BROKER ("nats" or
+// "memory"). It fails when none is selected in production, and for unknown ones.
+func NewBrokerFromEnv() (Broker, error) {
+	switch kind := config.EventBroker(); kind {
+	case "nats":
+		if !config.NATSJetStream() {
+			log.Println("⚠️  Publishing events to core NATS, which does not persist them; set NATS_JETSTREAM=true")
+		}
+		return NewNATSBroker(config.NATSURL(), config.NATSJetStream())
+	case "memory":
+		return NewMemoryBroker(), nil
+	case "":
+		return nil, errors.New("EVENT_BROKER must be set in production")
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BROKER %q", kind)
+	}
+}
+
+// Fanout publishes every message to each of brokers. If any of them fails the
+// message is retried on all of them, so each must tolerate duplicates.
+type Fanout []Broker
+
+func (f Fanout) Publish(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, broker := range f {
+		if err := broker.Publish(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f Fanout) Close() error {
+	var errs []error
+	for _, broker := range f {
+		if err := broker.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NATSBroker publishes each event on Subject(type). With JetStream the
+// publish only counts once the stream has acknowledged it, and is
+// deduplicated on the event ID. With core NATS it is flushed to the server
+// before counting as published, but the server does not keep it: subscribers
+// that are not connected at that moment never get it.
+type NATSBroker struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+// NewNATSBroker connects to the NATS server at url
+func NewNATSBroker(url string, jetStream bool) (*NATSBroker, error) {
+	conn, err := nats.Connect(url, nats.Name("trademinutes-user"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	broker := &NATSBroker{conn: conn}
+	if jetStream {
+		if broker.js, err = conn.JetStream(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to open JetStream: %v", err)
+		}
+	}
+	return broker, nil
+}
+
+func (b *NATSBroker) Publish(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	natsMsg := nats.NewMsg(Subject(msg.Type))
+	natsMsg.Data = data
+	natsMsg.Header.Set(nats.MsgIdHdr, msg.ID)
+
+	if b.js != nil {
+		_, err := b.js.PublishMsg(natsMsg, nats.Context(ctx))
+		return err
+	}
+	if err := b.conn.PublishMsg(natsMsg); err != nil {
+		return err
+	}
+	return b.conn.FlushWithContext(ctx)
+}
+
+func (b *NATSBroker) Close() error {
+	return b.conn.Drain()
+}
+
+// memoryBrokerLimit is how many recent messages a MemoryBroker keeps
+const memoryBrokerLimit = 1000
+
+// MemoryBroker keeps recent messages in memory and hands them to subscribers
+// synchronously. It is meant for tests and local development.
+type MemoryBroker struct {
+	mu          sync.Mutex
+	messages    []Message
+	subscribers []func(Message)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, msg Message) error {
+	b.mu.Lock()
+	b.messages = append(b.messages, msg)
+	if len(b.messages) > memoryBrokerLimit {
+		b.messages = b.messages[len(b.messages)-memoryBrokerLimit:]
+	}
+	subscribers := append([]func(Message){}, b.subscribers...)
+	b.mu.Unlock()
+
+	if len(subscribers) == 0 {
+		log.Printf("📣 %s event %s for user %s", msg.Type, msg.ID, msg.UserID)
+	}
+	for _, subscriber := range subscribers {
+		subscriber(msg)
+	}
+	return nil
+}
+
+// Subscribe registers fn to receive every message published from now on
+func (b *MemoryBroker) Subscribe(fn func(Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Messages returns the most recent messages published, oldest first
+func (b *MemoryBroker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message{}, b.messages...)
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
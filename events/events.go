@@ -0,0 +1,172 @@
+// Package events records domain events in a transactional outbox and relays
+// them to a message broker. Events are written with Record in the same
+// transaction as the state change they describe, so an event exists if and
+// only if the change committed; the Relay then publishes them at least once.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const OutboxCollection = "outbox"
+
+// Event types
+const (
+	UserRegistered = "user.registered"
+	UserDeleted    = "user.deleted"
+	// UserDeletionRequested asks other services to delete a user's data and
+	// acknowledge through POST /api/internal/deletions/{id}/ack
+	UserDeletionRequested = "user.deletion_requested"
+	ProfileUpdated        = "user.profile_updated"
+	// UserDeactivated and UserReactivated tell other services to hide or show
+	// a user's content again, after self-deactivation or an admin soft delete
+	UserDeactivated = "user.deactivated"
+	UserReactivated = "user.reactivated"
+	// UserSuspended and UserUnsuspended follow admin suspensions. A temporary
+	// suspension ends at its expiresAt without an unsuspended event.
+	UserSuspended   = "user.suspended"
+	UserUnsuspended = "user.unsuspended"
+	CreditsChanged  = "credits.changed"
+	// AchievementEarned is recorded once per achievement a user is awarded
+	AchievementEarned = "user.achievement_earned"
+)
+
+// Types lists every event type, for validating subscriptions
+var Types = []string{UserRegistered, UserDeleted, UserDeletionRequested, ProfileUpdated, UserDeactivated, UserReactivated, UserSuspended, UserUnsuspended, CreditsChanged, AchievementEarned}
+
+// IsType reports whether eventType is a known event type
+func IsType(eventType string) bool {
+	for _, t := range Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is an outbox record. Data holds the JSON payload exactly as it will be published.
+type Event struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Type        string             `bson:"type"`
+	UserID      primitive.ObjectID `bson:"userId"`
+	Data        string             `bson:"data"`
+	OccurredAt  int64              `bson:"occurredAt"`
+	PublishedAt int64              `bson:"publishedAt,omitempty"`
+	// Relay bookkeeping
+	Attempts      int    `bson:"attempts"`
+	LastError     string `bson:"lastError,omitempty"`
+	NextAttemptAt int64  `bson:"nextAttemptAt"`
+	LockedUntil   int64  `bson:"lockedUntil"`
+}
+
+// Message is the envelope published to brokers and other sinks
+type Message struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	UserID     string          `json:"userId"`
+	OccurredAt int64           `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Payloads
+
+type UserRegisteredData struct {
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	Provider   string `json:"provider,omitempty"`   // OAuth provider, empty for password signups
+	ReferredBy string `json:"referredBy,omitempty"` // Referrer's user ID
+}
+
+type UserDeletedData struct {
+	Email string `json:"email"`
+}
+
+type UserDeletionRequestedData struct {
+	RequestID string   `json:"requestId"`
+	Email     string   `json:"email"`
+	Services  []string `json:"services"` // Services still expected to acknowledge
+	Attempt   int      `json:"attempt"`
+}
+
+type ProfileUpdatedData struct {
+	Fields []string `json:"fields"` // Names of the fields that were set
+}
+
+type UserDeactivatedData struct {
+	Reason  string `json:"reason"`            // "deactivated" by the user or "deleted" by an admin
+	PurgeAt int64  `json:"purgeAt,omitempty"` // When a deleted user will be removed for good
+}
+
+type UserReactivatedData struct {
+	Restored bool `json:"restored"` // True when an admin restored a deleted user
+}
+
+type UserSuspendedData struct {
+	SuspensionID string `json:"suspensionId"`
+	ReasonCode   string `json:"reasonCode"`
+	ExpiresAt    int64  `json:"expiresAt,omitempty"` // 0 for a permanent ban
+}
+
+type UserUnsuspendedData struct {
+	SuspensionID string `json:"suspensionId"`
+	Reason       string `json:"reason"` // "lifted" by an admin or "appeal_granted"
+}
+
+type CreditsChangedData struct {
+	EntryID      string `json:"entryId"`
+	EntryType    string `json:"entryType"`
+	Amount       int    `json:"amount"`
+	BalanceAfter int    `json:"balanceAfter"`
+	Reference    string `json:"reference,omitempty"`
+}
+
+type AchievementEarnedData struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+}
+
+// Record writes an event to the outbox. It takes a session context because it
+// must run inside the transaction that makes the change the event describes.
+func Record(sessCtx mongo.SessionContext, eventType string, userID primitive.ObjectID, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %v", eventType, err)
+	}
+
+	now := time.Now().Unix()
+	_, err = config.GetCollection(OutboxCollection).InsertOne(sessCtx, Event{
+		ID:            primitive.NewObjectID(),
+		Type:          eventType,
+		UserID:        userID,
+		Data:          string(payload),
+		OccurredAt:    now,
+		NextAttemptAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record %s event: %v", eventType, err)
+	}
+	return nil
+}
+
+// Message returns the envelope published for an event
+func (e Event) Message() Message {
+	return Message{
+		ID:         e.ID.Hex(),
+		Type:       e.Type,
+		UserID:     e.UserID.Hex(),
+		OccurredAt: e.OccurredAt,
+		Data:       json.RawMessage(e.Data),
+	}
+}
+
+// Subject is the broker subject an event type is published on, e.g. trademinutes.user.registered
+func Subject(eventType string) string {
+	return "trademinutes." + eventType
+}
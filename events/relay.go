@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxRetryDelay = 5 * time.Minute
+
+// Relay publishes outbox events to a broker. An event is only marked published
+// after the broker accepts it, so a crash in between publishes it again:
+// delivery is at least once and consumers should deduplicate on the event ID.
+// Several instances can run side by side; each event is leased to one at a time.
+type Relay struct {
+	Broker    Broker
+	Interval  time.Duration // How often to poll the outbox
+	BatchSize int           // Events published per poll, at most
+	Lease     time.Duration // How long an instance holds an event while publishing it
+	Retention time.Duration // How long published events are kept
+}
+
+// NewRelay returns a relay for broker using the OUTBOX_* settings
+func NewRelay(broker Broker) *Relay {
+	return &Relay{
+		Broker:    broker,
+		Interval:  config.OutboxRelayInterval(),
+		BatchSize: 100,
+		Lease:     30 * time.Second,
+		Retention: config.OutboxRetention(),
+	}
+}
+
+// Start polls the outbox in the background until the process exits
+func (r *Relay) Start() {
+	go func() {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			if _, err := r.RunOnce(ctx); err != nil {
+				log.Printf("❌ Outbox relay failed: %v", err)
+			}
+			if err := r.purge(ctx); err != nil {
+				log.Printf("⚠️  Outbox purge failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// RunOnce publishes up to BatchSize due events, oldest first, and returns how many were published
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	published := 0
+	for published < r.BatchSize {
+		event, err := r.claim(ctx)
+		if err != nil {
+			return published, err
+		}
+		if event == nil {
+			return published, nil
+		}
+
+		if err := r.Broker.Publish(ctx, event.Message()); err != nil {
+			log.Printf("⚠️  Failed to publish %s event %s (attempt %d): %v", event.Type, event.ID.Hex(), event.Attempts+1, err)
+			if err := r.fail(ctx, event, err); err != nil {
+				return published, err
+			}
+			continue
+		}
+
+		_, err = config.GetCollection(OutboxCollection).UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{
+			"$set":   bson.M{"publishedAt": time.Now().Unix(), "lockedUntil": 0},
+			"$inc":   bson.M{"attempts": 1},
+			"$unset": bson.M{"lastError": ""},
+		})
+		if err != nil {
+			// The lease runs out and the event is published again
+			return published, fmt.Errorf("failed to mark event %s published: %v", event.ID.Hex(), err)
+		}
+		published++
+	}
+	return published, nil
+}
+
+// claim leases the oldest due, unpublished event that no other relay holds
+func (r *Relay) claim(ctx context.Context) (*Event, error) {
+	now := time.Now()
+	var event Event
+	err := config.GetCollection(OutboxCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"publishedAt":   bson.M{"$exists": false},
+			"nextAttemptAt": bson.M{"$lte": now.Unix()},
+			"lockedUntil":   bson.M{"$lte": now.Unix()},
+		},
+		bson.M{"$set": bson.M{"lockedUntil": now.Add(r.Lease).Unix()}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&event)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox event: %v", err)
+	}
+	return &event, nil
+}
+
+// fail releases an event for a later attempt with exponential backoff
+func (r *Relay) fail(ctx context.Context, event *Event, publishErr error) error {
+	delay := time.Second << uint(min(event.Attempts, 16))
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	_, err := config.GetCollection(OutboxCollection).UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{
+		"$set": bson.M{
+			"lastError":     publishErr.Error(),
+			"nextAttemptAt": time.Now().Add(delay).Unix(),
+			"lockedUntil":   0,
+		},
+		"$inc": bson.M{"attempts": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reschedule event %s: %v", event.ID.Hex(), err)
+	}
+	return nil
+}
+
+// purge deletes events published longer ago than Retention
+func (r *Relay) purge(ctx context.Context) error {
+	if r.Retention <= 0 {
+		return nil
+	}
+	_, err := config.GetCollection(OutboxCollection).DeleteMany(ctx, bson.M{
+		"publishedAt": bson.M{"$lt": time.Now().Add(-r.Retention).Unix()},
+	})
+	return err
+}
@@ -0,0 +1,34 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"trademinutes-user/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceKeyMetadata is the gRPC counterpart of the X-Service-Key HTTP header
+var ServiceKeyMetadata = strings.ToLower(middleware.ServiceKeyHeader)
+
+// ServiceKeyInterceptor rejects calls that do not carry a valid service key,
+// the same check ServiceKeyMiddleware applies to /api/internal routes
+func ServiceKeyInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var key string
+	if values := md.Get(ServiceKeyMetadata); len(values) > 0 {
+		key = values[0]
+	}
+
+	if !middleware.ValidServiceKey(key) {
+		log.Printf("Service key missing or invalid for %s\n", info.FullMethod)
+		return nil, status.Error(codes.Unauthenticated, "invalid service key")
+	}
+
+	return handler(ctx, req)
+}
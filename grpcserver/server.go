@@ -0,0 +1,246 @@
+// Package grpcserver exposes user lookups and credit movements to other
+// TradeMinutes services over gRPC. It is a thin layer over the services
+// package, so it behaves exactly like the equivalent HTTP routes.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"trademinutes-user/proto/userpb"
+	"trademinutes-user/services"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements userpb.UserServiceServer
+type Server struct {
+	userpb.UnimplementedUserServiceServer
+}
+
+// Start listens on addr (e.g. ":9090") and serves the user service in the
+// background, requiring the service key on every call
+func Start(addr string) (*grpc.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(ServiceKeyInterceptor))
+	userpb.RegisterUserServiceServer(server, &Server{})
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("❌ gRPC server stopped: %v", err)
+		}
+	}()
+	return server, nil
+}
+
+func (s *Server) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	userID, err := parseID(req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+	user, err := services.GetUser(ctx, userID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return userToProto(user), nil
+}
+
+func (s *Server) BatchGetUsers(ctx context.Context, req *userpb.BatchGetUsersRequest) (*userpb.BatchGetUsersResponse, error) {
+	ids := make([]primitive.ObjectID, 0, len(req.GetUserIds()))
+	for _, id := range req.GetUserIds() {
+		userID, err := parseID(id, "user_ids")
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, userID)
+	}
+
+	users, err := services.GetUsers(ctx, ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	found := make(map[primitive.ObjectID]bool, len(users))
+	resp := &userpb.BatchGetUsersResponse{}
+	for i := range users {
+		found[users[i].ID] = true
+		resp.Users = append(resp.Users, userToProto(&users[i]))
+	}
+	for _, id := range ids {
+		if !found[id] {
+			resp.NotFound = append(resp.NotFound, id.Hex())
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *userpb.GetBalanceRequest) (*userpb.Balance, error) {
+	userID, err := parseID(req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+	balance, err := services.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.Balance{
+		UserId:    balance.UserID.Hex(),
+		Credits:   int64(balance.Credits),
+		Held:      int64(balance.Held),
+		Available: int64(balance.Available),
+	}, nil
+}
+
+func (s *Server) Deduct(ctx context.Context, req *userpb.DeductRequest) (*userpb.LedgerEntry, error) {
+	userID, err := parseID(req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+	if req.GetAmount() <= 0 {
+		return nil, toStatus(services.ErrInvalidAmount)
+	}
+	entry, err := services.DeductCredits(ctx, userID, int(req.GetAmount()), req.GetReason(), req.GetReference(), req.GetIdempotencyKey())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return entryToProto(entry), nil
+}
+
+func (s *Server) Hold(ctx context.Context, req *userpb.HoldRequest) (*userpb.CreditHold, error) {
+	userID, err := parseID(req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(req.GetTtlSeconds()) * time.Second
+	hold, err := services.PlaceHold(ctx, userID, int(req.GetAmount()), req.GetReason(), req.GetReference(), ttl, req.GetIdempotencyKey())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return holdToProto(hold), nil
+}
+
+func (s *Server) Capture(ctx context.Context, req *userpb.CaptureRequest) (*userpb.CaptureResponse, error) {
+	holdID, err := parseID(req.GetHoldId(), "hold_id")
+	if err != nil {
+		return nil, err
+	}
+	hold, entry, err := services.CaptureHold(ctx, holdID, int(req.GetAmount()), req.GetReason())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.CaptureResponse{Hold: holdToProto(hold), Entry: entryToProto(entry)}, nil
+}
+
+func (s *Server) Release(ctx context.Context, req *userpb.ReleaseRequest) (*userpb.CreditHold, error) {
+	holdID, err := parseID(req.GetHoldId(), "hold_id")
+	if err != nil {
+		return nil, err
+	}
+	hold, err := services.ReleaseHold(ctx, holdID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return holdToProto(hold), nil
+}
+
+func (s *Server) Transfer(ctx context.Context, req *userpb.TransferRequest) (*userpb.TransferResponse, error) {
+	fromID, err := parseID(req.GetFromUserId(), "from_user_id")
+	if err != nil {
+		return nil, err
+	}
+	toID, err := parseID(req.GetToUserId(), "to_user_id")
+	if err != nil {
+		return nil, err
+	}
+	debit, credit, err := services.Transfer(ctx, fromID, toID, int(req.GetAmount()), req.GetReason(), req.GetReference(), req.GetIdempotencyKey())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.TransferResponse{Debit: entryToProto(debit), Credit: entryToProto(credit)}, nil
+}
+
+func parseID(id, field string) (primitive.ObjectID, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, status.Errorf(codes.InvalidArgument, "invalid %s", field)
+	}
+	return objectID, nil
+}
+
+// toStatus maps service errors onto gRPC status codes
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrHoldNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, services.ErrInsufficientCredits), errors.Is(err, services.ErrHoldNotActive):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrUserSuspended):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, services.ErrInvalidAmount), errors.Is(err, services.ErrCaptureExceedsHold),
+		errors.Is(err, services.ErrSelfTransfer), errors.Is(err, services.ErrTooManyUsers):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		log.Printf("❌ gRPC call failed: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func userToProto(user *models.User) *userpb.User {
+	return &userpb.User{
+		Id:                user.ID.Hex(),
+		Name:              user.Name,
+		Email:             user.Email,
+		College:           user.College,
+		Program:           user.Program,
+		YearOfStudy:       user.YearOfStudy,
+		Bio:               user.Bio,
+		Skills:            user.Skills,
+		ProfilePictureUrl: user.ProfilePictureURL,
+		CoverImageUrl:     user.CoverImageURL,
+		Location:          user.Location,
+		Latitude:          user.Latitude,
+		Longitude:         user.Longitude,
+		Credits:           int64(user.Credits),
+		CreatedAt:         user.CreatedAt,
+	}
+}
+
+func entryToProto(entry *services.LedgerEntry) *userpb.LedgerEntry {
+	return &userpb.LedgerEntry{
+		Id:           entry.ID.Hex(),
+		UserId:       entry.UserID.Hex(),
+		Type:         entry.Type,
+		Amount:       int64(entry.Amount),
+		BalanceAfter: int64(entry.BalanceAfter),
+		Note:         entry.Note,
+		Reference:    entry.Reference,
+		CreatedAt:    entry.CreatedAt,
+	}
+}
+
+func holdToProto(hold *services.CreditHold) *userpb.CreditHold {
+	return &userpb.CreditHold{
+		Id:        hold.ID.Hex(),
+		UserId:    hold.UserID.Hex(),
+		Amount:    int64(hold.Amount),
+		Captured:  int64(hold.Captured),
+		Status:    hold.Status,
+		Reason:    hold.Reason,
+		Reference: hold.Reference,
+		ExpiresAt: hold.ExpiresAt,
+		CreatedAt: hold.CreatedAt,
+	}
+}
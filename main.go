@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 
 	"trademinutes-user/config"
+	"trademinutes-user/events"
+	"trademinutes-user/grpcserver"
 	"trademinutes-user/routes"
+	"trademinutes-user/services"
 	"trademinutes-user/utils"
 )
 
@@ -42,6 +47,19 @@ func main() {
 	config.ConnectDB()
 	fmt.Println("✅ Connected to MongoDB:", config.GetDB().Name())
 
+	// Create indexes (unique ledger idempotency keys, lot lookups, ...)
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := services.EnsureIndexes(indexCtx); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.SeedSkillCatalog(indexCtx); err != nil {
+		log.Fatal(err)
+	}
+	cancelIndexes()
+
+	// Place locations set before structured locations were stored
+	services.StartLocationBackfill()
+
 	// Initialize Cloudinary
 	if err := utils.InitCloudinary(); err != nil {
 		log.Printf("⚠️  Cloudinary initialization failed: %v", err)
@@ -50,6 +68,35 @@ func main() {
 		fmt.Println("✅ Cloudinary initialized successfully")
 	}
 
+	// Expire credit lots in the background
+	services.StartCreditExpiryJob(config.CreditExpiryInterval())
+
+	// Publish domain events from the outbox
+	broker, err := events.NewBrokerFromEnv()
+	if err != nil {
+		log.Fatalf("❌ Failed to set up event broker: %v", err)
+	}
+	events.NewRelay(events.Fanout{broker, services.WebhookSink{}, services.AchievementsSink{}}).Start()
+	fmt.Println("✅ Relaying outbox events to", config.EventBroker(), "plus webhooks and achievements")
+
+	// Send queued webhook deliveries
+	services.StartWebhookDeliveryJob(config.WebhookDeliveryInterval())
+
+	// Drive account deletions to completion
+	services.StartDeletionJob()
+
+	// Build requested data exports and delete expired ones
+	services.StartExportJob(config.ExportInterval())
+
+	// Permanently delete soft-deleted users once their retention period ends,
+	// and accounts whose owners asked for deletion once it can no longer be cancelled
+	services.StartPurgeJob(config.PurgeInterval())
+
+	// Reconcile the ledger against balances, if scheduled
+	if interval := config.ReconcileInterval(); interval > 0 {
+		services.StartReconciliationJob(interval, config.ReconcileAutoFix())
+	}
+
 	// Set up router
 	router := mux.NewRouter()
 
@@ -65,6 +112,16 @@ func main() {
 
 	routes.SetupRoutes(router)
 
+	// Start the internal gRPC API on its own port
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	if _, err := grpcserver.Start(":" + grpcPort); err != nil {
+		log.Fatalf("❌ Failed to start gRPC server: %v", err)
+	}
+	fmt.Println("🚀 gRPC user service running on port", grpcPort)
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// IsAdmin reports whether the given email is listed in ADMIN_EMAILS
+// (a comma-separated list of administrator email addresses).
+func IsAdmin(email string) bool {
+	if email == "" {
+		return false
+	}
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if strings.EqualFold(strings.TrimSpace(admin), email) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminMiddleware only lets administrators through. It must run after
+// JWTMiddleware so the caller's email is available in the request context.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, ok := r.Context().Value(EmailKey).(string)
+		if !ok || email == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !IsAdmin(email) {
+			log.Printf("Admin access denied for email: %s\n", email)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"log"
 
+	"trademinutes-user/services"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -14,6 +16,10 @@ type contextKey string
 
 const EmailKey = contextKey("email")
 
+// IssuedAtKey holds the time.Time the caller's token was issued, for actions
+// that need a recent login. Tokens issued before iat was added do not set it.
+const IssuedAtKey = contextKey("iat")
+
 func JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -55,11 +61,50 @@ func JWTMiddleware(next http.Handler) http.Handler {
 
 		log.Printf("JWT token validated for email: %s\n", email)
 
+		// Tokens issued before a deletion or suspension stay valid, so both are checked on every request
+		deleted, err := services.IsDeletedEmail(r.Context(), email)
+		if err != nil {
+			log.Printf("Failed to check deletion for %s: %v\n", email, err)
+			http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+			return
+		}
+		if deleted {
+			http.Error(w, "This account has been deleted", http.StatusUnauthorized)
+			return
+		}
+		suspension, err := services.ActiveSuspensionByEmail(r.Context(), email)
+		if err != nil {
+			log.Printf("Failed to check suspension for %s: %v\n", email, err)
+			http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+			return
+		}
+		if suspension != nil {
+			http.Error(w, suspension.Message(), http.StatusForbidden)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), EmailKey, email)
+		if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+			ctx = context.WithValue(ctx, IssuedAtKey, iat.Time)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// OptionalJWTMiddleware is JWTMiddleware for routes anyone may call: requests
+// without an Authorization header pass through anonymously, without EmailKey.
+// A token that is sent must still be valid.
+func OptionalJWTMiddleware(next http.Handler) http.Handler {
+	authenticated := JWTMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
 // JWTAuthMiddleware is an alias for JWTMiddleware for backward compatibility
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return JWTMiddleware(next)
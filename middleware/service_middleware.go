@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+)
+
+// ServiceKeyHeader carries the shared key other TradeMinutes services authenticate with
+const ServiceKeyHeader = "X-Service-Key"
+
+// ValidServiceKey reports whether key matches SERVICE_API_KEY. An unset
+// SERVICE_API_KEY rejects every key.
+func ValidServiceKey(key string) bool {
+	expected := os.Getenv("SERVICE_API_KEY")
+	if expected == "" || key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1
+}
+
+// ServiceKeyMiddleware only lets through requests from other TradeMinutes services
+func ServiceKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ValidServiceKey(r.Header.Get(ServiceKeyHeader)) {
+			log.Printf("Service key missing or invalid for %s\n", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,14 +3,14 @@ package routes
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
 	"time"
 	"trademinutes-user/config"
 	"trademinutes-user/controllers"
 	"trademinutes-user/middleware"
+	"trademinutes-user/services"
 
-	"github.com/ElioCloud/shared-models/models"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -21,25 +21,147 @@ func SetupRoutes(router *mux.Router) {
 	authRouter.HandleFunc("/register", controllers.RegisterHandler).Methods("POST", "OPTIONS")
 	authRouter.HandleFunc("/login", controllers.LoginHandler).Methods("POST", "OPTIONS")
 	authRouter.HandleFunc("/github", controllers.OAuthHandler).Methods("POST", "OPTIONS")
+	authRouter.HandleFunc("/reactivate", controllers.ReactivateAccountHandler).Methods("POST", "OPTIONS")
 	authRouter.Handle("/profile", middleware.JWTAuthMiddleware(http.HandlerFunc(controllers.ProfileHandler))).Methods("GET", "OPTIONS")
-	authRouter.HandleFunc("/user/{id}", controllers.GetUserByIDHandler).Methods("GET", "OPTIONS")
-	authRouter.HandleFunc("/users", controllers.GetAllUsersHandler).Methods("GET", "OPTIONS")
-	authRouter.HandleFunc("/admin/delete/{id}", controllers.AdminDeleteUserHandler).Methods("DELETE", "OPTIONS")
-	authRouter.HandleFunc("/update-credits", controllers.UpdateCreditsHandler).Methods("PUT", "OPTIONS")
-	authRouter.HandleFunc("/deduct-credits", controllers.DeductCreditsHandler).Methods("POST", "OPTIONS")
+	authRouter.Handle("/user/{id}", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.GetUserByIDHandler))).Methods("GET", "OPTIONS")
+	authRouter.Handle("/users", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.GetAllUsersHandler))).Methods("GET", "OPTIONS")
+	authRouter.Handle("/admin/delete/{id}", middleware.JWTMiddleware(middleware.AdminMiddleware(http.HandlerFunc(controllers.AdminDeleteUserHandler)))).Methods("DELETE", "OPTIONS")
+
+	// Public profile routes (token optional; it decides which private fields are shown)
+	router.Handle("/api/profile/u/{username}", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.GetProfileByUsernameHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/api/profile/{userId:[0-9a-fA-F]{24}}", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.GetProfileByIDHandler))).Methods("GET", "OPTIONS")
+
+	// Skills catalog (public)
+	router.HandleFunc("/api/skills/autocomplete", controllers.SkillAutocompleteHandler).Methods("GET", "OPTIONS")
+
+	// User search and nearby users (token optional)
+	router.Handle("/api/users/search", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.SearchUsersHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/api/users/nearby", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.NearbyUsersHandler))).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/users/{userId:[0-9a-fA-F]{24}}/endorsements", controllers.GetEndorsementsHandler).Methods("GET", "OPTIONS")
+
+	// Matching and endorsement routes (protected)
+	usersRouter := router.PathPrefix("/api/users").Subrouter()
+	usersRouter.Use(middleware.JWTMiddleware)
+	usersRouter.HandleFunc("/match", controllers.MatchUsersHandler).Methods("GET", "OPTIONS")
+	usersRouter.HandleFunc("/{userId}/endorsements", controllers.EndorseSkillHandler).Methods("POST", "OPTIONS")
+	usersRouter.HandleFunc("/{userId}/endorsements/{skill}", controllers.RemoveEndorsementHandler).Methods("DELETE", "OPTIONS")
 
 	// Profile routes (protected)
 	profileRouter := router.PathPrefix("/api/profile").Subrouter()
 	profileRouter.Use(middleware.JWTMiddleware)
+	profileRouter.HandleFunc("", controllers.PatchProfileHandler).Methods("PATCH", "OPTIONS")
 	profileRouter.HandleFunc("/get", controllers.GetProfileHandler).Methods("GET", "OPTIONS")
-	profileRouter.HandleFunc("/{userId}", controllers.GetProfileByIDHandler).Methods("GET", "OPTIONS")
+	profileRouter.HandleFunc("/deactivate", controllers.DeactivateAccountHandler).Methods("POST", "OPTIONS")
+	profileRouter.HandleFunc("/username", controllers.SetUsernameHandler).Methods("PUT", "OPTIONS")
+	profileRouter.HandleFunc("/username/available", controllers.CheckUsernameHandler).Methods("GET", "OPTIONS")
+	profileRouter.HandleFunc("/privacy", controllers.GetPrivacyHandler).Methods("GET", "OPTIONS")
+	profileRouter.HandleFunc("/privacy", controllers.UpdatePrivacyHandler).Methods("PUT", "OPTIONS")
+	profileRouter.HandleFunc("/skill-levels", controllers.SetSkillLevelsHandler).Methods("PUT", "OPTIONS")
+	profileRouter.HandleFunc("/blocks", controllers.ListBlocksHandler).Methods("GET", "OPTIONS")
+	profileRouter.HandleFunc("/blocks/{userId}", controllers.BlockUserHandler).Methods("PUT", "OPTIONS")
+	profileRouter.HandleFunc("/blocks/{userId}", controllers.UnblockUserHandler).Methods("DELETE", "OPTIONS")
 	profileRouter.HandleFunc("/update-info", controllers.UpdateProfileInfoHandler).Methods("POST", "OPTIONS")
 	profileRouter.HandleFunc("/upload-image", controllers.UploadImageHandler).Methods("POST", "OPTIONS")
 	profileRouter.HandleFunc("/upload-cover-image", controllers.UploadCoverImageHandler).Methods("POST", "OPTIONS")
 
+	// Credit routes (protected)
+	creditsRouter := router.PathPrefix("/api/credits").Subrouter()
+	creditsRouter.Use(middleware.JWTMiddleware)
+	creditsRouter.HandleFunc("/lots", controllers.GetCreditLotsHandler).Methods("GET", "OPTIONS")
+	creditsRouter.HandleFunc("/statement", controllers.GetCreditStatementHandler).Methods("GET", "OPTIONS")
+	creditsRouter.HandleFunc("/history", controllers.GetCreditHistoryHandler).Methods("GET", "OPTIONS")
+
+	// Account routes (protected). Export downloads are authorised by their signed link instead.
+	router.HandleFunc("/api/account/export/{id}/download", controllers.DownloadDataExportHandler).Methods("GET", "OPTIONS")
+	accountRouter := router.PathPrefix("/api/account").Subrouter()
+	accountRouter.Use(middleware.JWTMiddleware)
+	accountRouter.HandleFunc("/export", controllers.RequestDataExportHandler).Methods("POST", "OPTIONS")
+	accountRouter.HandleFunc("/export/{id}", controllers.GetDataExportHandler).Methods("GET", "OPTIONS")
+	accountRouter.HandleFunc("", controllers.DeleteAccountHandler).Methods("DELETE", "OPTIONS")
+	accountRouter.HandleFunc("/deletion", controllers.GetAccountDeletionHandler).Methods("GET", "OPTIONS")
+	accountRouter.HandleFunc("/deletion/cancel", controllers.CancelAccountDeletionHandler).Methods("POST", "OPTIONS")
+
+	// Stats and achievements (protected)
+	router.Handle("/api/achievements", middleware.JWTMiddleware(http.HandlerFunc(controllers.GetAchievementsHandler))).Methods("GET", "OPTIONS")
+
+	// Referral dashboard (protected)
+	router.Handle("/api/referrals", middleware.JWTMiddleware(http.HandlerFunc(controllers.GetReferralsHandler))).Methods("GET", "OPTIONS")
+
+	// Admin credit adjustment routes (protected, admin only)
+	adminCreditsRouter := router.PathPrefix("/api/admin/credits").Subrouter()
+	adminCreditsRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminCreditsRouter.HandleFunc("/adjustments", controllers.AdminAdjustCreditsHandler).Methods("POST", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/adjustments", controllers.AdminListCreditAdjustmentsHandler).Methods("GET", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/adjustments/{id}/approve", controllers.AdminApproveCreditAdjustmentHandler).Methods("POST", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/adjustments/{id}/reject", controllers.AdminRejectCreditAdjustmentHandler).Methods("POST", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/statements", controllers.AdminExportStatementsHandler).Methods("GET", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/reversals", controllers.ReverseCreditsHandler).Methods("POST", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/reconciliation", controllers.AdminGetReconciliationHandler).Methods("GET", "OPTIONS")
+	adminCreditsRouter.HandleFunc("/reconciliation", controllers.AdminRunReconciliationHandler).Methods("POST", "OPTIONS")
+
+	// Admin bonus rule routes (protected, admin only)
+	adminBonusRouter := router.PathPrefix("/api/admin/bonus-rules").Subrouter()
+	adminBonusRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminBonusRouter.HandleFunc("", controllers.AdminListBonusRulesHandler).Methods("GET", "OPTIONS")
+	adminBonusRouter.HandleFunc("/{key}", controllers.AdminUpdateBonusRuleHandler).Methods("PUT", "OPTIONS")
+
+	// Admin profile completeness routes (protected, admin only)
+	adminCompletenessRouter := router.PathPrefix("/api/admin/profile-completeness").Subrouter()
+	adminCompletenessRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminCompletenessRouter.HandleFunc("", controllers.AdminListCompletenessItemsHandler).Methods("GET", "OPTIONS")
+	adminCompletenessRouter.HandleFunc("/{key}", controllers.AdminUpdateCompletenessWeightHandler).Methods("PUT", "OPTIONS")
+
+	// Admin skills catalog routes (protected, admin only)
+	adminSkillsRouter := router.PathPrefix("/api/admin/skills").Subrouter()
+	adminSkillsRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminSkillsRouter.HandleFunc("", controllers.AdminListSkillsHandler).Methods("GET", "OPTIONS")
+	adminSkillsRouter.HandleFunc("", controllers.AdminSaveSkillHandler).Methods("POST", "OPTIONS")
+	adminSkillsRouter.HandleFunc("/merge", controllers.AdminMergeSkillsHandler).Methods("POST", "OPTIONS")
+
+	// Admin webhook routes (protected, admin only)
+	adminWebhooksRouter := router.PathPrefix("/api/admin/webhooks").Subrouter()
+	adminWebhooksRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminWebhooksRouter.HandleFunc("", controllers.AdminCreateWebhookHandler).Methods("POST", "OPTIONS")
+	adminWebhooksRouter.HandleFunc("", controllers.AdminListWebhooksHandler).Methods("GET", "OPTIONS")
+	adminWebhooksRouter.HandleFunc("/{id}", controllers.AdminUpdateWebhookHandler).Methods("PUT", "OPTIONS")
+	adminWebhooksRouter.HandleFunc("/{id}", controllers.AdminDeleteWebhookHandler).Methods("DELETE", "OPTIONS")
+	adminWebhooksRouter.HandleFunc("/{id}/deliveries", controllers.AdminListWebhookDeliveriesHandler).Methods("GET", "OPTIONS")
+	adminWebhooksRouter.HandleFunc("/deliveries/{deliveryId}/redeliver", controllers.AdminRedeliverWebhookHandler).Methods("POST", "OPTIONS")
+
+	// Admin account deletion routes (protected, admin only)
+	adminDeletionsRouter := router.PathPrefix("/api/admin/deletions").Subrouter()
+	adminDeletionsRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminDeletionsRouter.HandleFunc("/{id}", controllers.AdminGetDeletionHandler).Methods("GET", "OPTIONS")
+	adminDeletionsRouter.HandleFunc("/{id}/retry", controllers.AdminRetryDeletionHandler).Methods("POST", "OPTIONS")
+
+	// Admin account routes (protected, admin only)
+	adminAccountsRouter := router.PathPrefix("/api/admin/accounts").Subrouter()
+	adminAccountsRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminAccountsRouter.HandleFunc("/deleted", controllers.AdminListDeletedUsersHandler).Methods("GET", "OPTIONS")
+	adminAccountsRouter.HandleFunc("/{id}/restore", controllers.AdminRestoreUserHandler).Methods("POST", "OPTIONS")
+	adminAccountsRouter.HandleFunc("/{id}/suspend", controllers.AdminSuspendUserHandler).Methods("POST", "OPTIONS")
+	adminAccountsRouter.HandleFunc("/{id}/suspensions", controllers.AdminListSuspensionsHandler).Methods("GET", "OPTIONS")
+
+	// Admin suspension routes (protected, admin only)
+	adminSuspensionsRouter := router.PathPrefix("/api/admin/suspensions").Subrouter()
+	adminSuspensionsRouter.Use(middleware.JWTMiddleware, middleware.AdminMiddleware)
+	adminSuspensionsRouter.HandleFunc("/{id}/lift", controllers.AdminLiftSuspensionHandler).Methods("POST", "OPTIONS")
+	adminSuspensionsRouter.HandleFunc("/{id}/appeal", controllers.AdminUpdateAppealHandler).Methods("PUT", "OPTIONS")
+
+	// Internal routes for other TradeMinutes services (service key required)
+	internalRouter := router.PathPrefix("/api/internal").Subrouter()
+	internalRouter.Use(middleware.ServiceKeyMiddleware)
+	internalRouter.HandleFunc("/bonuses/award", controllers.AwardBonusHandler).Methods("POST", "OPTIONS")
+	internalRouter.HandleFunc("/credits/deduct", controllers.DeductCreditsHandler).Methods("POST", "OPTIONS")
+	internalRouter.HandleFunc("/credits/reversals", controllers.ReverseCreditsHandler).Methods("POST", "OPTIONS")
+	internalRouter.HandleFunc("/deletions/{id}/ack", controllers.AckDeletionHandler).Methods("POST", "OPTIONS")
+	internalRouter.HandleFunc("/activity", controllers.ReportActivityHandler).Methods("POST", "OPTIONS")
+
 	// Public admin routes (for admin dashboard)
-	router.HandleFunc("/api/users", controllers.GetAllUsersHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/admin/delete/{id}", controllers.AdminDeleteUserHandler).Methods("DELETE", "OPTIONS")
+	router.Handle("/api/users", middleware.OptionalJWTMiddleware(http.HandlerFunc(controllers.GetAllUsersHandler))).Methods("GET", "OPTIONS")
+
+	// Admin user deletion (protected, admin only)
+	router.Handle("/api/admin/delete/{id}", middleware.JWTMiddleware(middleware.AdminMiddleware(http.HandlerFunc(controllers.AdminDeleteUserHandler)))).Methods("DELETE", "OPTIONS")
 
 	// Public endpoint for admin page (no authentication required)
 	router.HandleFunc("/api/admin/users", func(w http.ResponseWriter, r *http.Request) {
@@ -62,37 +184,29 @@ func SetupRoutes(router *mux.Router) {
 
 		var filter bson.M
 		if searchQuery != "" {
-			// Search by name or email (case-insensitive)
-			filter = bson.M{
-				"$or": []bson.M{
-					{"name": bson.M{"$regex": searchQuery, "$options": "i"}},
-					{"email": bson.M{"$regex": searchQuery, "$options": "i"}},
-				},
-			}
+			// Search by name (case-insensitive); emails may be private
+			filter = services.NameFilter(searchQuery)
 		} else {
 			filter = bson.M{}
 		}
+		filter = services.Visible(filter)
 
-		fmt.Println("🔍 Executing database query with filter:", filter)
 		cursor, err := collection.Find(ctx, filter)
 		if err != nil {
-			fmt.Println("❌ Database query error:", err)
+			log.Printf("Failed to fetch users: %v", err)
 			http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
 			return
 		}
-		fmt.Println("✅ Database query successful")
 		defer cursor.Close(ctx)
 
-		var users []models.User
-		if err = cursor.All(ctx, &users); err != nil {
+		var profiles []services.UserProfile
+		if err = cursor.All(ctx, &profiles); err != nil {
 			http.Error(w, "Failed to decode users", http.StatusInternalServerError)
 			return
 		}
 
-		// Remove passwords from response
-		for i := range users {
-			users[i].Password = ""
-		}
+		// Only public profiles are listed, as anyone would see them
+		users := services.NewPublicProfiles(profiles, services.Viewer{})
 
 		// Return in the format expected by the frontend
 		response := map[string]interface{}{
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrActiveHolds         = errors.New("credits are on hold for pending bookings; complete or cancel them first")
+	ErrCreditsNotForfeited = errors.New("remaining credits must be forfeited to delete the account")
+	ErrNoDeletionScheduled = errors.New("no account deletion is scheduled")
+)
+
+// ScheduleAccountDeletion starts the cooling-off period after which a user's
+// own account is deleted. Users with credits on hold must settle them first,
+// and users with a balance must agree to forfeit it; that balance is
+// recorded, and no more is forfeited. Asking again while a deletion is
+// scheduled returns the existing schedule.
+func ScheduleAccountDeletion(ctx context.Context, userID primitive.ObjectID, forfeitCredits bool) (*AccountStatus, error) {
+	status, err := liveAccountStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status.DeletionScheduledFor != 0 {
+		return status, nil
+	}
+
+	balance, err := GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Held > 0 {
+		return nil, ErrActiveHolds
+	}
+	if balance.Credits > 0 && !forfeitCredits {
+		return nil, ErrCreditsNotForfeited
+	}
+
+	now := time.Now()
+	status.DeletionRequestedAt = now.Unix()
+	status.DeletionScheduledFor = now.Add(config.AccountDeletionCoolingOff()).Unix()
+	forfeit := max(balance.Credits, 0)
+	status.DeletionForfeitCredits = &forfeit
+	result, err := config.GetCollection(UsersCollection).UpdateOne(ctx,
+		NotDeleted(bson.M{"_id": userID, "deletionScheduledFor": bson.M{"$exists": false}}),
+		bson.M{"$set": bson.M{
+			"deletionRequestedAt":    status.DeletionRequestedAt,
+			"deletionScheduledFor":   status.DeletionScheduledFor,
+			"deletionForfeitCredits": forfeit,
+		}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule account deletion: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		// Scheduled concurrently
+		return liveAccountStatus(ctx, userID)
+	}
+	return status, nil
+}
+
+// CancelAccountDeletion ends a scheduled deletion's cooling-off period early, keeping the account
+func CancelAccountDeletion(ctx context.Context, userID primitive.ObjectID) error {
+	result, err := config.GetCollection(UsersCollection).UpdateOne(ctx,
+		NotDeleted(bson.M{"_id": userID, "deletionScheduledFor": bson.M{"$exists": true}}),
+		bson.M{"$unset": bson.M{"deletionRequestedAt": "", "deletionScheduledFor": "", "deletionForfeitCredits": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to cancel account deletion: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoDeletionScheduled
+	}
+	return nil
+}
+
+// RunScheduledDeletions deletes every account whose cooling-off period is
+// over: holds placed since the request are released, the balance the user
+// agreed to forfeit is, as far as it remains, and the deletion saga is
+// started. It returns how many started.
+func RunScheduledDeletions(ctx context.Context) (int, error) {
+	cursor, err := config.GetCollection(UsersCollection).Find(ctx,
+		NotDeleted(bson.M{"deletionScheduledFor": bson.M{"$lte": time.Now().Unix()}}),
+		options.Find().SetProjection(bson.M{"_id": 1, "deletionRequestedAt": 1, "deletionForfeitCredits": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch scheduled deletions: %v", err)
+	}
+	var users []struct {
+		ID          primitive.ObjectID `bson:"_id"`
+		RequestedAt int64              `bson:"deletionRequestedAt"`
+		Forfeit     *int               `bson:"deletionForfeitCredits"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, fmt.Errorf("failed to decode scheduled deletions: %v", err)
+	}
+
+	started := 0
+	for _, user := range users {
+		if err := settleCredits(ctx, user.ID, user.RequestedAt, user.Forfeit); err != nil {
+			log.Printf("❌ Failed to settle credits before deleting %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		if _, err := StartDeletion(ctx, user.ID, "self"); err != nil {
+			log.Printf("❌ Failed to delete user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		started++
+	}
+	return started, nil
+}
+
+// settleCredits releases a user's active holds and forfeits up to the balance
+// they agreed to forfeit in one transaction; credits received since are left
+// alone. Deletions scheduled before that balance was recorded forfeit all of
+// it, as their users agreed to then. The forfeiture is keyed on the deletion
+// request, so retrying after a failed saga start never posts it twice.
+func settleCredits(ctx context.Context, userID primitive.ObjectID, requestedAt int64, agreed *int) error {
+	idempotencyKey := "forfeiture:" + userID.Hex() + ":" + strconv.FormatInt(requestedAt, 10)
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		cursor, err := config.GetCollection(HoldsCollection).Find(sessCtx, bson.M{"userId": userID, "status": HoldActive})
+		if err != nil {
+			return fmt.Errorf("failed to fetch credit holds: %v", err)
+		}
+		var holds []CreditHold
+		if err := cursor.All(sessCtx, &holds); err != nil {
+			return fmt.Errorf("failed to decode credit holds: %v", err)
+		}
+		for _, hold := range holds {
+			if _, err := closeHold(sessCtx, hold.ID, HoldReleased); err != nil {
+				return err
+			}
+		}
+
+		var user struct {
+			Credits int `bson:"credits"`
+		}
+		if err := config.GetCollection(UsersCollection).FindOne(sessCtx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return fmt.Errorf("failed to fetch balance: %v", err)
+		}
+		forfeit := user.Credits
+		if agreed != nil {
+			forfeit = min(forfeit, *agreed)
+		}
+		if forfeit <= 0 {
+			return nil
+		}
+		_, err = applyDebit(sessCtx, LedgerEntry{
+			UserID:         userID,
+			Type:           EntryTypeForfeiture,
+			Amount:         -forfeit,
+			Note:           "Account deleted by user",
+			IdempotencyKey: idempotencyKey,
+		})
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
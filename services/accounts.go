@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUserNotDeleted = errors.New("user is not deleted")
+	// ErrRestoreTooLate means the user is already being purged and can no longer be restored
+	ErrRestoreTooLate = errors.New("user is already being permanently deleted")
+)
+
+// AccountStatus is the lifecycle state kept on a user document next to the shared user model
+type AccountStatus struct {
+	DeactivatedAt  int64  `bson:"deactivatedAt,omitempty" json:"deactivatedAt,omitempty"`
+	DeletedAt      int64  `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy      string `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	DeletionStatus string `bson:"deletionStatus,omitempty" json:"deletionStatus,omitempty"` // Set once the deletion saga has started; hides the user like DeletedAt
+	// Set while a deletion the user asked for is in its cooling-off period
+	DeletionRequestedAt  int64 `bson:"deletionRequestedAt,omitempty" json:"deletionRequestedAt,omitempty"`
+	DeletionScheduledFor int64 `bson:"deletionScheduledFor,omitempty" json:"deletionScheduledFor,omitempty"`
+	// The balance the user agreed to forfeit when scheduling the deletion
+	DeletionForfeitCredits *int `bson:"deletionForfeitCredits,omitempty" json:"deletionForfeitCredits,omitempty"`
+}
+
+// PurgeAt is when a deleted user becomes due for permanent deletion, or 0 if not deleted
+func (s AccountStatus) PurgeAt() int64 {
+	if s.DeletedAt == 0 {
+		return 0
+	}
+	return s.DeletedAt + int64(config.SoftDeleteRetention().Seconds())
+}
+
+// NotDeleted adds the condition that hides soft-deleted users, including
+// those being purged, to a users filter. Every lookup made on behalf of a
+// client goes through it.
+func NotDeleted(filter bson.M) bson.M {
+	filter["deletedAt"] = bson.M{"$exists": false}
+	filter["deletionStatus"] = bson.M{"$exists": false}
+	return filter
+}
+
+// IsDeletedEmail reports whether the user with the given email is deleted or
+// being purged, for checks made with only a token's claims. Unknown emails are not.
+func IsDeletedEmail(ctx context.Context, email string) (bool, error) {
+	count, err := config.GetCollection(UsersCollection).CountDocuments(ctx, bson.M{
+		"email": email,
+		"$or": bson.A{
+			bson.M{"deletedAt": bson.M{"$exists": true}},
+			bson.M{"deletionStatus": bson.M{"$exists": true}},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to look up user: %v", err)
+	}
+	return count > 0, nil
+}
+
+// Visible is NotDeleted that also hides deactivated users, for lookups of
+// other people's profiles
+func Visible(filter bson.M) bson.M {
+	filter = NotDeleted(filter)
+	filter["deactivatedAt"] = bson.M{"$exists": false}
+	return filter
+}
+
+// GetAccountStatus returns a user's lifecycle state
+func GetAccountStatus(ctx context.Context, userID primitive.ObjectID) (*AccountStatus, error) {
+	var status AccountStatus
+	err := config.GetCollection(UsersCollection).FindOne(ctx, bson.M{"_id": userID}).Decode(&status)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account status: %v", err)
+	}
+	return &status, nil
+}
+
+// DeactivateUser hides a user's profile and blocks their login until they
+// reactivate. Deactivating an already deactivated user is a no-op.
+func DeactivateUser(ctx context.Context, userID primitive.ObjectID) error {
+	changed, err := changeAccountState(ctx,
+		NotDeleted(bson.M{"_id": userID, "deactivatedAt": bson.M{"$exists": false}}),
+		bson.M{"$set": bson.M{"deactivatedAt": time.Now().Unix()}},
+		events.UserDeactivated, events.UserDeactivatedData{Reason: "deactivated"})
+	if err != nil || changed {
+		return err
+	}
+	_, err = liveAccountStatus(ctx, userID)
+	return err
+}
+
+// ReactivateUser undoes DeactivateUser. Reactivating an active user is a no-op.
+func ReactivateUser(ctx context.Context, userID primitive.ObjectID) error {
+	changed, err := changeAccountState(ctx,
+		NotDeleted(bson.M{"_id": userID, "deactivatedAt": bson.M{"$exists": true}}),
+		bson.M{"$unset": bson.M{"deactivatedAt": ""}},
+		events.UserReactivated, events.UserReactivatedData{})
+	if err != nil || changed {
+		return err
+	}
+	_, err = liveAccountStatus(ctx, userID)
+	return err
+}
+
+// SoftDeleteUser marks a user deleted. They disappear from every lookup at
+// once but can be restored until the retention period ends and the purge job
+// deletes them for good. Deleting a deleted user returns their existing status.
+func SoftDeleteUser(ctx context.Context, userID primitive.ObjectID, deletedBy string) (*AccountStatus, error) {
+	now := time.Now().Unix()
+	status := AccountStatus{DeletedAt: now, DeletedBy: deletedBy}
+	changed, err := changeAccountState(ctx,
+		NotDeleted(bson.M{"_id": userID}),
+		bson.M{"$set": bson.M{"deletedAt": now, "deletedBy": deletedBy}},
+		events.UserDeactivated, events.UserDeactivatedData{Reason: "deleted", PurgeAt: status.PurgeAt()})
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return GetAccountStatus(ctx, userID)
+	}
+	return &status, nil
+}
+
+// RestoreUser undoes SoftDeleteUser, as long as the user has not started being purged
+func RestoreUser(ctx context.Context, userID primitive.ObjectID) error {
+	status, err := GetAccountStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var data interface{}
+	eventType := events.UserReactivated
+	if status.DeactivatedAt != 0 {
+		// They deactivated themselves before being deleted; they stay hidden until they reactivate
+		eventType = ""
+	} else {
+		data = events.UserReactivatedData{Restored: true}
+	}
+
+	changed, err := changeAccountState(ctx,
+		bson.M{"_id": userID, "deletedAt": bson.M{"$exists": true}, "deletionStatus": bson.M{"$exists": false}},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+		eventType, data)
+	if err != nil || changed {
+		return err
+	}
+
+	if status, err = GetAccountStatus(ctx, userID); err != nil {
+		return err
+	}
+	if status.DeletionStatus != "" {
+		return ErrRestoreTooLate
+	}
+	return ErrUserNotDeleted
+}
+
+// DeletedUser is a soft-deleted user an admin may still restore
+type DeletedUser struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	Name          string             `bson:"name" json:"name"`
+	Email         string             `bson:"email" json:"email"`
+	AccountStatus `bson:",inline"`
+	PurgeAt       int64 `bson:"-" json:"purgeAt"`
+}
+
+// ListDeletedUsers returns the soft-deleted users that have not started being
+// purged, most recently deleted first
+func ListDeletedUsers(ctx context.Context) ([]DeletedUser, error) {
+	cursor, err := config.GetCollection(UsersCollection).Find(ctx,
+		bson.M{"deletedAt": bson.M{"$exists": true}, "deletionStatus": bson.M{"$exists": false}},
+		options.Find().
+			SetSort(bson.D{{Key: "deletedAt", Value: -1}}).
+			SetProjection(bson.M{"name": 1, "email": 1, "deletedAt": 1, "deletedBy": 1, "deactivatedAt": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deleted users: %v", err)
+	}
+	users := []DeletedUser{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode deleted users: %v", err)
+	}
+	for i := range users {
+		users[i].PurgeAt = users[i].AccountStatus.PurgeAt()
+	}
+	return users, nil
+}
+
+// PurgeDeletedUsers starts the deletion saga for every user deleted longer
+// ago than the retention period and returns how many were started
+func PurgeDeletedUsers(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-config.SoftDeleteRetention()).Unix()
+	cursor, err := config.GetCollection(UsersCollection).Find(ctx,
+		bson.M{"deletedAt": bson.M{"$lte": cutoff}, "deletionStatus": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch deleted users: %v", err)
+	}
+	var users []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, fmt.Errorf("failed to decode deleted users: %v", err)
+	}
+
+	started := 0
+	for _, user := range users {
+		if _, err := StartDeletion(ctx, user.ID, "retention"); err != nil {
+			log.Printf("❌ Failed to purge user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		started++
+	}
+	return started, nil
+}
+
+// StartPurgeJob runs PurgeDeletedUsers and RunScheduledDeletions on the given
+// interval until the process exits
+func StartPurgeJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			started, err := PurgeDeletedUsers(ctx)
+			if err != nil {
+				log.Printf("❌ Purge job failed: %v", err)
+			} else if started > 0 {
+				log.Printf("🗑️  Started permanent deletion of %d users past retention", started)
+			}
+			started, err = RunScheduledDeletions(ctx)
+			if err != nil {
+				log.Printf("❌ Scheduled account deletions failed: %v", err)
+			} else if started > 0 {
+				log.Printf("🗑️  Started deletion of %d accounts past their cooling-off period", started)
+			}
+			cancel()
+		}
+	}()
+}
+
+// changeAccountState applies update to the user matching filter and records
+// the event in the same transaction. It reports false, recording nothing, if
+// no user matched. An empty eventType records no event.
+func changeAccountState(ctx context.Context, filter, update bson.M, eventType string, data interface{}) (bool, error) {
+	changed := false
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var user struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err := config.GetCollection(UsersCollection).FindOneAndUpdate(sessCtx, filter, update).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update account status: %v", err)
+		}
+		changed = true
+		if eventType == "" {
+			return nil
+		}
+		return events.Record(sessCtx, eventType, user.ID, data)
+	})
+	return changed, err
+}
+
+// liveAccountStatus is GetAccountStatus that treats deleted users as not found
+func liveAccountStatus(ctx context.Context, userID primitive.ObjectID) (*AccountStatus, error) {
+	status, err := GetAccountStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status.DeletedAt != 0 {
+		return nil, ErrUserNotFound
+	}
+	return status, nil
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ActivityCollection records every activity already counted, so replays are no-ops
+const ActivityCollection = "processedActivity"
+
+// Activity types other services report through POST /api/internal/activity
+const (
+	ActivityTaskCompleted    = "task.completed"
+	ActivitySessionCompleted = "session.completed"
+	ActivityReviewReceived   = "review.received"
+)
+
+// Counters behind a user's stats and achievements, kept under "counters" on the user document
+const (
+	CounterCreditsEarned     = "creditsEarned"
+	CounterProfileComplete   = "profileComplete" // 1 once the profile has been complete
+	CounterTasksCompleted    = "tasksCompleted"
+	CounterSessionsConducted = "sessionsConducted"
+	CounterMinutesHelping    = "minutesHelping"
+	CounterReviews           = "reviews"
+	CounterRatingTotal       = "ratingTotal"
+)
+
+var (
+	ErrUnknownActivity = errors.New("unknown activity type")
+	ErrInvalidActivity = errors.New("invalid activity")
+	ErrActivityApplied = errors.New("activity already applied")
+)
+
+// AchievementRule awards an achievement once a counter reaches Threshold
+type AchievementRule struct {
+	Key         string `json:"key"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Counter     string `json:"counter"`
+	Threshold   int    `json:"threshold"`
+}
+
+// AchievementRules are evaluated after every activity. Keys are stored with
+// awarded achievements and must never change.
+var AchievementRules = []AchievementRule{
+	{Key: "profile_complete", Title: "All About Me", Description: "Completed your profile", Counter: CounterProfileComplete, Threshold: 1},
+	{Key: "first_credits", Title: "First Earnings", Description: "Earned your first credits from another user", Counter: CounterCreditsEarned, Threshold: 1},
+	{Key: "credits_100", Title: "Time Banker", Description: "Earned 100 credits from other users", Counter: CounterCreditsEarned, Threshold: 100},
+	{Key: "first_task", Title: "Helping Hand", Description: "Completed your first task", Counter: CounterTasksCompleted, Threshold: 1},
+	{Key: "tasks_10", Title: "Go-To Helper", Description: "Completed 10 tasks", Counter: CounterTasksCompleted, Threshold: 10},
+	{Key: "tasks_50", Title: "Community Pillar", Description: "Completed 50 tasks", Counter: CounterTasksCompleted, Threshold: 50},
+	{Key: "first_session", Title: "First Session", Description: "Conducted your first session", Counter: CounterSessionsConducted, Threshold: 1},
+	{Key: "sessions_25", Title: "Seasoned Mentor", Description: "Conducted 25 sessions", Counter: CounterSessionsConducted, Threshold: 25},
+	{Key: "hours_10", Title: "Ten Hours Given", Description: "Spent 10 hours helping others", Counter: CounterMinutesHelping, Threshold: 600},
+	{Key: "hours_100", Title: "Hundred Hours Given", Description: "Spent 100 hours helping others", Counter: CounterMinutesHelping, Threshold: 6000},
+	{Key: "reviews_5", Title: "Well Reviewed", Description: "Received 5 reviews", Counter: CounterReviews, Threshold: 5},
+}
+
+// Activity is one thing that happened to a user that may move their stats.
+// ID is unique per source event and makes applying it idempotent.
+type Activity struct {
+	ID      string
+	Type    string
+	UserID  primitive.ObjectID
+	Minutes int     // Task and session length
+	Rating  float64 // Review rating, 1 to 5
+}
+
+// Achievement is an awarded achievement as stored on the user document. It
+// extends the shared model's fields with the rule key it was awarded for.
+type Achievement struct {
+	Key         string `bson:"key" json:"key"`
+	Title       string `bson:"title" json:"title"`
+	Description string `bson:"description" json:"description"`
+	DateEarned  string `bson:"dateEarned" json:"dateEarned"`
+}
+
+// ApplyActivity updates a user's counters, stats and achievements for an
+// activity reported by another service. It returns the achievements the
+// activity newly earned. Applying the same activity again changes nothing
+// and returns ErrActivityApplied.
+func ApplyActivity(ctx context.Context, activity Activity) ([]Achievement, error) {
+	inc := bson.M{}
+	switch activity.Type {
+	case ActivityTaskCompleted, ActivitySessionCompleted:
+		if activity.Minutes < 0 || activity.Minutes > 24*60 {
+			return nil, fmt.Errorf("%w: minutes must be between 0 and 1440", ErrInvalidActivity)
+		}
+		counter := CounterTasksCompleted
+		if activity.Type == ActivitySessionCompleted {
+			counter = CounterSessionsConducted
+		}
+		inc["counters."+counter] = 1
+		inc["counters."+CounterMinutesHelping] = activity.Minutes
+	case ActivityReviewReceived:
+		if activity.Rating < 1 || activity.Rating > 5 {
+			return nil, fmt.Errorf("%w: rating must be between 1 and 5", ErrInvalidActivity)
+		}
+		inc["counters."+CounterReviews] = 1
+		inc["counters."+CounterRatingTotal] = activity.Rating
+	default:
+		return nil, ErrUnknownActivity
+	}
+	if activity.ID == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrInvalidActivity)
+	}
+	return applyActivity(ctx, activity.ID, activity.Type, activity.UserID, bson.M{"$inc": inc})
+}
+
+// AchievementsSink is the events.Broker that feeds the user service's own
+// domain events into the achievements engine. Like every sink it may see an
+// event more than once; activities are keyed on the event ID.
+type AchievementsSink struct{}
+
+func (AchievementsSink) Publish(ctx context.Context, msg events.Message) error {
+	userID, err := primitive.ObjectIDFromHex(msg.UserID)
+	if err != nil {
+		return nil
+	}
+
+	var update bson.M
+	switch msg.Type {
+	case events.CreditsChanged:
+		var data events.CreditsChangedData
+		if err := json.Unmarshal(msg.Data, &data); err != nil {
+			return nil
+		}
+		// Only credits paid by other users count as earned, not bonuses or refunds
+		if data.EntryType != EntryTypeTransferIn || data.Amount <= 0 {
+			return nil
+		}
+		update = bson.M{"$inc": bson.M{"counters." + CounterCreditsEarned: data.Amount}}
+	case events.ProfileUpdated:
+		user, err := GetUser(ctx, userID)
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !ProfileComplete(*user) {
+			return nil
+		}
+		update = bson.M{"$max": bson.M{"counters." + CounterProfileComplete: 1}}
+	default:
+		return nil
+	}
+
+	_, err = applyActivity(ctx, "event:"+msg.ID, msg.Type, userID, update)
+	if errors.Is(err, ErrActivityApplied) || errors.Is(err, ErrUserNotFound) {
+		// A redelivery, or the user was deleted since
+		return nil
+	}
+	return err
+}
+
+func (AchievementsSink) Close() error {
+	return nil
+}
+
+// AchievementProgress is how far a user is towards one achievement
+type AchievementProgress struct {
+	AchievementRule
+	Progress   int    `json:"progress"`
+	Earned     bool   `json:"earned"`
+	DateEarned string `json:"dateEarned,omitempty"`
+}
+
+// GetAchievementProgress returns a user's progress towards every achievement
+func GetAchievementProgress(ctx context.Context, userID primitive.ObjectID) ([]AchievementProgress, error) {
+	var user struct {
+		Counters     map[string]float64 `bson:"counters"`
+		Achievements []Achievement      `bson:"achievements"`
+	}
+	err := config.GetCollection(UsersCollection).FindOne(ctx, NotDeleted(bson.M{"_id": userID}),
+		options.FindOne().SetProjection(bson.M{"counters": 1, "achievements": 1})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch achievements: %v", err)
+	}
+
+	earned := map[string]string{}
+	for _, achievement := range user.Achievements {
+		earned[achievement.Key] = achievement.DateEarned
+	}
+	progress := make([]AchievementProgress, 0, len(AchievementRules))
+	for _, rule := range AchievementRules {
+		date, ok := earned[rule.Key]
+		value := int(user.Counters[rule.Counter])
+		if value > rule.Threshold {
+			value = rule.Threshold
+		}
+		progress = append(progress, AchievementProgress{AchievementRule: rule, Progress: value, Earned: ok, DateEarned: date})
+	}
+	return progress, nil
+}
+
+// applyActivity records an activity, applies update to the user's counters
+// and then brings their stats and achievements in line, all in one transaction
+func applyActivity(ctx context.Context, activityID, activityType string, userID primitive.ObjectID, update bson.M) ([]Achievement, error) {
+	var earned []Achievement
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		earned = nil
+		_, err := config.GetCollection(ActivityCollection).InsertOne(sessCtx, bson.M{
+			"_id":         activityID,
+			"type":        activityType,
+			"userId":      userID,
+			"processedAt": time.Now().Unix(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record activity: %w", err)
+		}
+
+		var user struct {
+			Counters     map[string]float64 `bson:"counters"`
+			Achievements []Achievement      `bson:"achievements"`
+		}
+		err = config.GetCollection(UsersCollection).FindOneAndUpdate(sessCtx,
+			NotDeleted(bson.M{"_id": userID}), update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update counters: %v", err)
+		}
+
+		awarded := map[string]bool{}
+		for _, achievement := range user.Achievements {
+			awarded[achievement.Key] = true
+		}
+		today := time.Now().UTC().Format("2006-01-02")
+		for _, rule := range AchievementRules {
+			if awarded[rule.Key] || user.Counters[rule.Counter] < float64(rule.Threshold) {
+				continue
+			}
+			earned = append(earned, Achievement{Key: rule.Key, Title: rule.Title, Description: rule.Description, DateEarned: today})
+		}
+
+		set := bson.M{"stats": statsFromCounters(user.Counters)}
+		change := bson.M{"$set": set}
+		if len(earned) > 0 {
+			change["$push"] = bson.M{"achievements": bson.M{"$each": earned}}
+		}
+		if _, err := config.GetCollection(UsersCollection).UpdateOne(sessCtx, bson.M{"_id": userID}, change); err != nil {
+			return fmt.Errorf("failed to update stats: %v", err)
+		}
+
+		for _, achievement := range earned {
+			err := events.Record(sessCtx, events.AchievementEarned, userID, events.AchievementEarnedData{
+				Key:   achievement.Key,
+				Title: achievement.Title,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrActivityApplied
+	}
+	if err != nil {
+		return nil, err
+	}
+	return earned, nil
+}
+
+// statsFromCounters derives the profile stats shown to users from their counters
+func statsFromCounters(counters map[string]float64) models.ProfileStats {
+	stats := models.ProfileStats{
+		ReviewsCount:      int(counters[CounterReviews]),
+		SessionsConducted: int(counters[CounterSessionsConducted]),
+		TimeSpentHelping:  formatMinutes(int(counters[CounterMinutesHelping])),
+	}
+	if stats.ReviewsCount > 0 {
+		// Two decimal places, e.g. 4.85
+		stats.Rating = float64(int(counters[CounterRatingTotal]/float64(stats.ReviewsCount)*100+0.5)) / 100
+	}
+	return stats
+}
+
+// formatMinutes formats a duration the way stats show it, e.g. "10h 15m"
+func formatMinutes(minutes int) string {
+	return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const AdjustmentsCollection = "creditAdjustments"
+
+// Adjustment statuses
+const (
+	AdjustmentPending  = "pending_approval"
+	AdjustmentApplied  = "applied"
+	AdjustmentRejected = "rejected"
+)
+
+// AdjustmentReasonCodes lists the reason codes an admin may use for a manual adjustment
+var AdjustmentReasonCodes = []string{
+	"goodwill",
+	"correction",
+	"refund",
+	"promotion",
+	"fraud_reversal",
+	"migration",
+}
+
+var (
+	ErrInvalidReasonCode     = errors.New("invalid reason code")
+	ErrAdjustmentNotFound    = errors.New("adjustment not found")
+	ErrAdjustmentNotPending  = errors.New("adjustment is not pending approval")
+	ErrSelfApproval          = errors.New("an adjustment cannot be approved by the admin who requested it")
+	ErrInvalidAdjustmentData = errors.New("delta must be non-zero and note is required")
+)
+
+// CreditAdjustment is a manual change to a user's balance made by an admin
+type CreditAdjustment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId" json:"userId"`
+	Delta         int                `bson:"delta" json:"delta"`
+	ReasonCode    string             `bson:"reasonCode" json:"reasonCode"`
+	Note          string             `bson:"note" json:"note"`
+	Status        string             `bson:"status" json:"status"`
+	RequestedBy   string             `bson:"requestedBy" json:"requestedBy"`
+	DecidedBy     string             `bson:"decidedBy,omitempty" json:"decidedBy,omitempty"`
+	DecisionNote  string             `bson:"decisionNote,omitempty" json:"decisionNote,omitempty"`
+	LedgerEntryID primitive.ObjectID `bson:"ledgerEntryId,omitempty" json:"ledgerEntryId,omitempty"`
+	CreatedAt     int64              `bson:"createdAt" json:"createdAt"`
+	DecidedAt     int64              `bson:"decidedAt,omitempty" json:"decidedAt,omitempty"`
+}
+
+// AdjustmentApprovalThreshold returns the absolute delta above which an
+// adjustment needs a second admin's approval (CREDIT_ADJUSTMENT_APPROVAL_THRESHOLD, default 100).
+// An admin's recent adjustments to the same user count towards it.
+func AdjustmentApprovalThreshold() int {
+	if v, err := strconv.Atoi(os.Getenv("CREDIT_ADJUSTMENT_APPROVAL_THRESHOLD")); err == nil && v >= 0 {
+		return v
+	}
+	return 100
+}
+
+// AdjustmentApprovalWindow returns how far back an admin's adjustments to a
+// user count towards the approval threshold
+// (CREDIT_ADJUSTMENT_APPROVAL_WINDOW as a Go duration, default 24h).
+func AdjustmentApprovalWindow() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CREDIT_ADJUSTMENT_APPROVAL_WINDOW")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+func isValidReasonCode(code string) bool {
+	for _, c := range AdjustmentReasonCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// RequestAdjustment records a manual adjustment. Small adjustments are applied
+// immediately; ones that take the admin's adjustments to the user within the
+// approval window above the approval threshold wait for a second admin, so
+// splitting a large adjustment into small ones does not avoid approval.
+func RequestAdjustment(ctx context.Context, userID primitive.ObjectID, delta int, reasonCode, note, requestedBy string) (*CreditAdjustment, error) {
+	note = strings.TrimSpace(note)
+	if delta == 0 || note == "" {
+		return nil, ErrInvalidAdjustmentData
+	}
+	if !isValidReasonCode(reasonCode) {
+		return nil, ErrInvalidReasonCode
+	}
+
+	adjustment := CreditAdjustment{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		Delta:       delta,
+		ReasonCode:  reasonCode,
+		Note:        note,
+		Status:      AdjustmentPending,
+		RequestedBy: requestedBy,
+		CreatedAt:   time.Now().Unix(),
+	}
+
+	collection := config.GetCollection(AdjustmentsCollection)
+	threshold := AdjustmentApprovalThreshold()
+
+	if abs(delta) <= threshold {
+		// Applying changes the user's balance, so concurrent adjustments to
+		// the same user conflict and are retried with the new total
+		var applied *CreditAdjustment
+		err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			applied = nil
+			recent, err := recentAdjustmentTotal(sessCtx, userID, requestedBy, adjustment.CreatedAt)
+			if err != nil {
+				return err
+			}
+			if recent+abs(delta) > threshold {
+				return nil
+			}
+
+			entry, err := applyEntry(sessCtx, adjustmentLedgerEntry(adjustment, ""), LotSourceAdjustment)
+			if err != nil {
+				return err
+			}
+			record := adjustment
+			record.Status = AdjustmentApplied
+			record.LedgerEntryID = entry.ID
+			record.DecidedAt = entry.CreatedAt
+			if _, err := collection.InsertOne(sessCtx, record); err != nil {
+				return err
+			}
+			applied = &record
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if applied != nil {
+			return applied, nil
+		}
+	}
+
+	count, err := config.GetCollection(UsersCollection).CountDocuments(ctx, NotDeleted(bson.M{"_id": userID}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	if count == 0 {
+		return nil, ErrUserNotFound
+	}
+	if _, err := collection.InsertOne(ctx, adjustment); err != nil {
+		return nil, fmt.Errorf("failed to save adjustment: %v", err)
+	}
+	return &adjustment, nil
+}
+
+// recentAdjustmentTotal adds up the absolute deltas of an admin's pending and
+// applied adjustments to a user within the approval window before now
+func recentAdjustmentTotal(ctx context.Context, userID primitive.ObjectID, requestedBy string, now int64) (int, error) {
+	since := now - int64(AdjustmentApprovalWindow().Seconds())
+	cursor, err := config.GetCollection(AdjustmentsCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"userId":      userID,
+			"requestedBy": requestedBy,
+			"status":      bson.M{"$in": bson.A{AdjustmentPending, AdjustmentApplied}},
+			"createdAt":   bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": bson.M{"$abs": "$delta"}}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to total recent adjustments: %v", err)
+	}
+	var rows []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return 0, fmt.Errorf("failed to decode recent adjustments: %v", err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Total, nil
+}
+
+// ApproveAdjustment applies a pending adjustment on behalf of a second admin
+func ApproveAdjustment(ctx context.Context, id primitive.ObjectID, approvedBy string) (*CreditAdjustment, error) {
+	collection := config.GetCollection(AdjustmentsCollection)
+
+	var adjustment CreditAdjustment
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if err := loadPendingAdjustment(sessCtx, id, &adjustment); err != nil {
+			return err
+		}
+		if strings.EqualFold(adjustment.RequestedBy, approvedBy) {
+			return ErrSelfApproval
+		}
+
+		entry, err := applyEntry(sessCtx, adjustmentLedgerEntry(adjustment, approvedBy), LotSourceAdjustment)
+		if err != nil {
+			return err
+		}
+
+		adjustment.Status = AdjustmentApplied
+		adjustment.DecidedBy = approvedBy
+		adjustment.DecidedAt = entry.CreatedAt
+		adjustment.LedgerEntryID = entry.ID
+
+		_, err = collection.UpdateOne(sessCtx, bson.M{"_id": id, "status": AdjustmentPending}, bson.M{"$set": bson.M{
+			"status":        adjustment.Status,
+			"decidedBy":     adjustment.DecidedBy,
+			"decidedAt":     adjustment.DecidedAt,
+			"ledgerEntryId": adjustment.LedgerEntryID,
+		}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &adjustment, nil
+}
+
+// RejectAdjustment closes a pending adjustment without touching the balance
+func RejectAdjustment(ctx context.Context, id primitive.ObjectID, rejectedBy, note string) (*CreditAdjustment, error) {
+	var adjustment CreditAdjustment
+	err := config.GetCollection(AdjustmentsCollection).FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "status": AdjustmentPending},
+		bson.M{"$set": bson.M{
+			"status":       AdjustmentRejected,
+			"decidedBy":    rejectedBy,
+			"decisionNote": strings.TrimSpace(note),
+			"decidedAt":    time.Now().Unix(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&adjustment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, adjustmentLookupError(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reject adjustment: %v", err)
+	}
+	return &adjustment, nil
+}
+
+// ListAdjustments returns adjustments, newest first, optionally filtered by status and user
+func ListAdjustments(ctx context.Context, status string, userID *primitive.ObjectID) ([]CreditAdjustment, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if userID != nil {
+		filter["userId"] = *userID
+	}
+
+	cursor, err := config.GetCollection(AdjustmentsCollection).Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch adjustments: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	adjustments := []CreditAdjustment{}
+	if err := cursor.All(ctx, &adjustments); err != nil {
+		return nil, fmt.Errorf("failed to decode adjustments: %v", err)
+	}
+	return adjustments, nil
+}
+
+func loadPendingAdjustment(ctx context.Context, id primitive.ObjectID, adjustment *CreditAdjustment) error {
+	err := config.GetCollection(AdjustmentsCollection).FindOne(ctx, bson.M{"_id": id, "status": AdjustmentPending}).Decode(adjustment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return adjustmentLookupError(ctx, id)
+	}
+	return err
+}
+
+func adjustmentLookupError(ctx context.Context, id primitive.ObjectID) error {
+	count, err := config.GetCollection(AdjustmentsCollection).CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to look up adjustment: %v", err)
+	}
+	if count == 0 {
+		return ErrAdjustmentNotFound
+	}
+	return ErrAdjustmentNotPending
+}
+
+func adjustmentLedgerEntry(adjustment CreditAdjustment, approvedBy string) LedgerEntry {
+	return LedgerEntry{
+		UserID:     adjustment.UserID,
+		Type:       EntryTypeAdjustment,
+		Amount:     adjustment.Delta,
+		ReasonCode: adjustment.ReasonCode,
+		Note:       adjustment.Note,
+		ActorEmail: adjustment.RequestedBy,
+		ApprovedBy: approvedBy,
+		Reference:  adjustment.ID.Hex(),
+	}
+}
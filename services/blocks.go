@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const BlocksCollection = "userBlocks"
+
+var (
+	ErrCannotBlockSelf = errors.New("you cannot block yourself")
+	ErrUserBlocked     = errors.New("user is blocked")
+)
+
+// Block hides two users from each other's matches and stops them endorsing
+// each other, whichever of them made it
+type Block struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BlockerID primitive.ObjectID `bson:"blockerId" json:"blockerId"`
+	BlockedID primitive.ObjectID `bson:"blockedId" json:"blockedId"`
+	CreatedAt int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// BlockUser blocks a user. Blocking someone already blocked does nothing.
+func BlockUser(ctx context.Context, blockerID, blockedID primitive.ObjectID) (*Block, error) {
+	if blockerID == blockedID {
+		return nil, ErrCannotBlockSelf
+	}
+	if _, err := GetUser(ctx, blockedID); err != nil {
+		return nil, err
+	}
+
+	block := Block{ID: primitive.NewObjectID(), BlockerID: blockerID, BlockedID: blockedID, CreatedAt: time.Now().Unix()}
+	_, err := config.GetCollection(BlocksCollection).InsertOne(ctx, block)
+	if mongo.IsDuplicateKeyError(err) {
+		err = config.GetCollection(BlocksCollection).FindOne(ctx, bson.M{"blockerId": blockerID, "blockedId": blockedID}).Decode(&block)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to block user: %v", err)
+	}
+	return &block, nil
+}
+
+// UnblockUser lifts a block. Unblocking someone who is not blocked does nothing.
+func UnblockUser(ctx context.Context, blockerID, blockedID primitive.ObjectID) error {
+	_, err := config.GetCollection(BlocksCollection).DeleteOne(ctx, bson.M{"blockerId": blockerID, "blockedId": blockedID})
+	if err != nil {
+		return fmt.Errorf("failed to unblock user: %v", err)
+	}
+	return nil
+}
+
+// ListBlocks returns the users a user has blocked, newest first
+func ListBlocks(ctx context.Context, blockerID primitive.ObjectID) ([]Block, error) {
+	cursor, err := config.GetCollection(BlocksCollection).Find(ctx, bson.M{"blockerId": blockerID},
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocks: %v", err)
+	}
+	blocks := []Block{}
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to decode blocks: %v", err)
+	}
+	return blocks, nil
+}
+
+// blockedUserIDs returns the users a user has blocked or been blocked by
+func blockedUserIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := config.GetCollection(BlocksCollection).Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"blockerId": userID},
+		bson.M{"blockedId": userID},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blocks: %v", err)
+	}
+	var blocks []Block
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to decode blocks: %v", err)
+	}
+	ids := make([]primitive.ObjectID, 0, len(blocks))
+	for _, block := range blocks {
+		if block.BlockerID == userID {
+			ids = append(ids, block.BlockedID)
+		} else {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids, nil
+}
+
+// isBlocked reports whether either user has blocked the other
+func isBlocked(ctx context.Context, a, b primitive.ObjectID) (bool, error) {
+	count, err := config.GetCollection(BlocksCollection).CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"blockerId": a, "blockedId": b},
+		bson.M{"blockerId": b, "blockedId": a},
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to check blocks: %v", err)
+	}
+	return count > 0, nil
+}
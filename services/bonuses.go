@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const BonusRulesCollection = "bonusRules"
+
+// Bonus rule keys
+const (
+	BonusSignup            = "signup"
+	BonusProfileCompletion = "profile_completion"
+	BonusEmailVerified     = "email_verified"
+	BonusFirstReview       = "first_review"
+	BonusReferralReferrer  = "referral_referrer"
+	BonusReferralReferee   = "referral_referee"
+)
+
+var (
+	ErrBonusRuleNotFound   = errors.New("bonus rule not found")
+	ErrBonusAlreadyGranted = errors.New("bonus already granted")
+	ErrInvalidBonusRule    = errors.New("amount and expiresInDays must not be negative")
+)
+
+// BonusRule defines a one-time credit bonus. Rules start from the config
+// defaults (CREDIT_GRANT_RULES) and can be overridden through the admin API.
+type BonusRule struct {
+	Key           string `bson:"_id" json:"key"`
+	Description   string `bson:"description,omitempty" json:"description,omitempty"`
+	Amount        int    `bson:"amount" json:"amount"`
+	ExpiresInDays int    `bson:"expiresInDays" json:"expiresInDays"` // 0 = never expires
+	Enabled       bool   `bson:"enabled" json:"enabled"`
+	UpdatedBy     string `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+	UpdatedAt     int64  `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// ListBonusRules returns every bonus rule, with admin overrides applied on top of config defaults
+func ListBonusRules(ctx context.Context) ([]BonusRule, error) {
+	rules := map[string]BonusRule{}
+	for _, grant := range config.CreditGrantRules() {
+		rules[grant.Source] = bonusRuleFromConfig(grant)
+	}
+
+	cursor, err := config.GetCollection(BonusRulesCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bonus rules: %v", err)
+	}
+	var overrides []BonusRule
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode bonus rules: %v", err)
+	}
+	for _, rule := range overrides {
+		rules[rule.Key] = rule
+	}
+
+	list := make([]BonusRule, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+// GetBonusRule returns a single rule, preferring an admin override over the config default
+func GetBonusRule(ctx context.Context, key string) (*BonusRule, error) {
+	var rule BonusRule
+	err := config.GetCollection(BonusRulesCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&rule)
+	if err == nil {
+		return &rule, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to fetch bonus rule: %v", err)
+	}
+
+	grant, ok := config.GetCreditGrantRule(key)
+	if !ok {
+		return nil, ErrBonusRuleNotFound
+	}
+	rule = bonusRuleFromConfig(grant)
+	return &rule, nil
+}
+
+// SaveBonusRule creates or replaces the admin override for a rule
+func SaveBonusRule(ctx context.Context, rule BonusRule, updatedBy string) (*BonusRule, error) {
+	rule.Key = strings.TrimSpace(rule.Key)
+	if rule.Key == "" {
+		return nil, ErrBonusRuleNotFound
+	}
+	if rule.Amount < 0 || rule.ExpiresInDays < 0 {
+		return nil, ErrInvalidBonusRule
+	}
+	rule.UpdatedBy = updatedBy
+	rule.UpdatedAt = time.Now().Unix()
+
+	_, err := config.GetCollection(BonusRulesCollection).ReplaceOne(ctx, bson.M{"_id": rule.Key}, rule, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save bonus rule: %v", err)
+	}
+	return &rule, nil
+}
+
+// AwardBonus grants a one-time bonus to a user. It returns ErrBonusAlreadyGranted
+// if the user already received it, and nil if the rule is disabled or awards nothing.
+func AwardBonus(ctx context.Context, userID primitive.ObjectID, key string) (*LedgerEntry, error) {
+	var entry *LedgerEntry
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if key == BonusEmailVerified {
+			// Other services award this bonus once an address is confirmed,
+			// even while it pays nothing
+			if err := MarkEmailVerified(sessCtx, userID); err != nil {
+				return err
+			}
+		}
+		var err error
+		entry, err = AwardBonusTx(sessCtx, userID, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// AwardBonusTx is AwardBonus for callers that already run in a transaction,
+// e.g. to grant the signup bonus in the same transaction that creates the user.
+func AwardBonusTx(sessCtx mongo.SessionContext, userID primitive.ObjectID, key string) (*LedgerEntry, error) {
+	return awardRuleTx(sessCtx, userID, key, BonusIdempotencyKey(key, userID))
+}
+
+// awardRuleTx grants the credits of a bonus rule under the given idempotency
+// key, so rules that can pay out more than once (e.g. per referral) stay exactly-once per key
+func awardRuleTx(sessCtx mongo.SessionContext, userID primitive.ObjectID, key, idempotencyKey string) (*LedgerEntry, error) {
+	rule, err := GetBonusRule(sessCtx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !rule.Enabled || rule.Amount <= 0 {
+		return nil, nil
+	}
+
+	count, err := config.GetCollection(LedgerCollection).CountDocuments(sessCtx, bson.M{"idempotencyKey": idempotencyKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bonus history: %v", err)
+	}
+	if count > 0 {
+		return nil, ErrBonusAlreadyGranted
+	}
+
+	var expiresAt int64
+	if rule.ExpiresInDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, rule.ExpiresInDays).Unix()
+	}
+
+	entry, _, err := applyCredit(sessCtx, LedgerEntry{
+		UserID:         userID,
+		Type:           EntryTypeBonus,
+		Amount:         rule.Amount,
+		ReasonCode:     rule.Key,
+		IdempotencyKey: idempotencyKey,
+	}, rule.Key, expiresAt)
+	// The unique index on idempotencyKey catches concurrent awards
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrBonusAlreadyGranted
+	}
+	return entry, err
+}
+
+// BonusIdempotencyKey is the ledger key that makes a bonus one-time per user
+func BonusIdempotencyKey(ruleKey string, userID primitive.ObjectID) string {
+	return "bonus:" + ruleKey + ":" + userID.Hex()
+}
+
+func bonusRuleFromConfig(grant config.CreditGrantRule) BonusRule {
+	return BonusRule{
+		Key:           grant.Source,
+		Amount:        grant.Amount,
+		ExpiresInDays: grant.ExpiresInDays,
+		Enabled:       grant.Amount > 0,
+	}
+}
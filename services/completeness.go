@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"trademinutes-user/config"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CompletenessWeightsCollection = "completenessWeights"
+
+// MaxCompletenessWeight caps the weight of a single completeness item
+const MaxCompletenessWeight = 100
+
+var (
+	ErrCompletenessItemNotFound  = errors.New("completeness item not found")
+	ErrInvalidCompletenessWeight = fmt.Errorf("weight must be between 0 and %d", MaxCompletenessWeight)
+)
+
+// CompletenessItem is one part of a complete profile. Its Weight is its share
+// of the score; admins can change it, and a weight of 0 leaves it out.
+type CompletenessItem struct {
+	Key       string `bson:"_id" json:"key"`
+	Hint      string `bson:"-" json:"hint"`
+	Weight    int    `bson:"weight" json:"weight"`
+	UpdatedBy string `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+	UpdatedAt int64  `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// completenessCheck describes a completeness item and how to tell it is done
+type completenessCheck struct {
+	Key    string
+	Hint   string
+	Weight int // Default weight
+	Done   func(profile completenessProfile) bool
+}
+
+// completenessProfile is what the completeness checks look at
+type completenessProfile struct {
+	models.User
+	EmailVerified bool
+}
+
+// completenessChecks are the default completeness items, adding up to 100
+var completenessChecks = []completenessCheck{
+	{Key: "profile_picture", Hint: "Add a profile picture", Weight: 20, Done: func(p completenessProfile) bool { return p.ProfilePictureURL != "" }},
+	{Key: "bio", Hint: "Write a short bio", Weight: 15, Done: func(p completenessProfile) bool { return p.Bio != "" }},
+	{Key: "skills", Hint: "List the skills you can help with", Weight: 15, Done: func(p completenessProfile) bool { return len(p.Skills) > 0 }},
+	{Key: "college", Hint: "Add your college", Weight: 10, Done: func(p completenessProfile) bool { return p.College != "" }},
+	{Key: "program", Hint: "Add your program", Weight: 10, Done: func(p completenessProfile) bool { return p.Program != "" }},
+	{Key: "location", Hint: "Set your location", Weight: 10, Done: func(p completenessProfile) bool { return p.Location != "" }},
+	{Key: "email_verified", Hint: "Verify your email address", Weight: 10, Done: func(p completenessProfile) bool { return p.EmailVerified }},
+	{Key: "year_of_study", Hint: "Add your year of study", Weight: 5, Done: func(p completenessProfile) bool { return p.YearOfStudy != "" }},
+	{Key: "cover_image", Hint: "Add a cover image", Weight: 5, Done: func(p completenessProfile) bool { return p.CoverImageURL != "" }},
+}
+
+// ProfileCompleteness is a profile's completeness score from 0 to 100, with
+// what is still missing, most valuable first
+type ProfileCompleteness struct {
+	Score   int                `json:"score"`
+	Missing []CompletenessItem `json:"missing"`
+}
+
+// ProfileComplete reports whether a profile has everything the profile
+// completion bonus asks for. This is fixed, unlike the weighted score.
+func ProfileComplete(user models.User) bool {
+	return user.College != "" && user.Program != "" && user.YearOfStudy != ""
+}
+
+// ListCompletenessItems returns every completeness item, with admin weight overrides applied
+func ListCompletenessItems(ctx context.Context) ([]CompletenessItem, error) {
+	cursor, err := config.GetCollection(CompletenessWeightsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch completeness weights: %v", err)
+	}
+	var overrides []CompletenessItem
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode completeness weights: %v", err)
+	}
+	byKey := map[string]CompletenessItem{}
+	for _, override := range overrides {
+		byKey[override.Key] = override
+	}
+
+	items := make([]CompletenessItem, 0, len(completenessChecks))
+	for _, check := range completenessChecks {
+		item, ok := byKey[check.Key]
+		if !ok {
+			item = CompletenessItem{Key: check.Key, Weight: check.Weight}
+		}
+		item.Hint = check.Hint
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// SaveCompletenessWeight sets the weight of a completeness item
+func SaveCompletenessWeight(ctx context.Context, key string, weight int, updatedBy string) (*CompletenessItem, error) {
+	check, ok := findCompletenessCheck(key)
+	if !ok {
+		return nil, ErrCompletenessItemNotFound
+	}
+	if weight < 0 || weight > MaxCompletenessWeight {
+		return nil, ErrInvalidCompletenessWeight
+	}
+
+	item := CompletenessItem{
+		Key:       key,
+		Hint:      check.Hint,
+		Weight:    weight,
+		UpdatedBy: updatedBy,
+		UpdatedAt: time.Now().Unix(),
+	}
+	_, err := config.GetCollection(CompletenessWeightsCollection).ReplaceOne(ctx, bson.M{"_id": key}, item, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save completeness weight: %v", err)
+	}
+	return &item, nil
+}
+
+// GetProfileCompleteness scores a user's profile against the current weights
+func GetProfileCompleteness(ctx context.Context, user models.User) (*ProfileCompleteness, error) {
+	items, err := ListCompletenessItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := emailVerified(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := completenessProfile{User: user, EmailVerified: verified}
+	completeness := &ProfileCompleteness{Missing: []CompletenessItem{}}
+	total, done := 0, 0
+	for _, item := range items {
+		if item.Weight == 0 {
+			continue
+		}
+		check, _ := findCompletenessCheck(item.Key)
+		total += item.Weight
+		if check.Done(profile) {
+			done += item.Weight
+			continue
+		}
+		completeness.Missing = append(completeness.Missing, CompletenessItem{Key: item.Key, Hint: item.Hint, Weight: item.Weight})
+	}
+	if total > 0 {
+		completeness.Score = int(math.Round(float64(done) * 100 / float64(total)))
+	}
+	sort.SliceStable(completeness.Missing, func(i, j int) bool {
+		return completeness.Missing[i].Weight > completeness.Missing[j].Weight
+	})
+	return completeness, nil
+}
+
+// MarkEmailVerified records that a user confirmed their email address
+func MarkEmailVerified(sessCtx mongo.SessionContext, userID primitive.ObjectID) error {
+	_, err := config.GetCollection(UsersCollection).UpdateOne(sessCtx,
+		bson.M{"_id": userID, "emailVerifiedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"emailVerifiedAt": time.Now().Unix()}})
+	if err != nil {
+		return fmt.Errorf("failed to mark email verified: %v", err)
+	}
+	return nil
+}
+
+// emailVerified reports whether a user's email address is known to be theirs:
+// confirmed through the email_verified bonus, or vouched for by an OAuth provider
+func emailVerified(ctx context.Context, user models.User) (bool, error) {
+	var stored struct {
+		Password        string `bson:"password"`
+		EmailVerifiedAt int64  `bson:"emailVerifiedAt"`
+	}
+	err := config.GetCollection(UsersCollection).FindOne(ctx, bson.M{"_id": user.ID},
+		options.FindOne().SetProjection(bson.M{"password": 1, "emailVerifiedAt": 1})).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, ErrUserNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check email verification: %v", err)
+	}
+	return stored.EmailVerifiedAt > 0 || stored.Password == "", nil
+}
+
+func findCompletenessCheck(key string) (completenessCheck, bool) {
+	for _, check := range completenessChecks {
+		if check.Key == key {
+			return check, true
+		}
+	}
+	return completenessCheck{}, false
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+	"trademinutes-user/utils"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const DeletionRequestsCollection = "deletionRequests"
+
+// Deletion statuses. A deletion waits in pending until every participant has
+// acknowledged, then finalizing while images and the user record are removed.
+const (
+	DeletionPending    = "pending"
+	DeletionFinalizing = "finalizing"
+	DeletionCompleted  = "completed"
+	DeletionTimedOut   = "timed_out" // Gave up waiting; an admin can retry
+)
+
+// Participant statuses
+const (
+	ParticipantPending = "pending"
+	ParticipantDone    = "done"
+	ParticipantFailed  = "failed"
+)
+
+// A finalizing deletion untouched for this long is assumed to have crashed and is retried
+const deletionFinalizeLease = 5 * time.Minute
+
+var (
+	ErrDeletionNotFound    = errors.New("deletion request not found")
+	ErrUnknownParticipant  = errors.New("service is not a deletion participant")
+	ErrDeletionNotRetrying = errors.New("only pending or timed out deletions can be retried")
+)
+
+// DeletionRequest tracks one account deletion saga
+type DeletionRequest struct {
+	ID           primitive.ObjectID             `bson:"_id" json:"id"`
+	UserID       primitive.ObjectID             `bson:"userId" json:"userId"`
+	Email        string                         `bson:"email" json:"email"`
+	RequestedBy  string                         `bson:"requestedBy" json:"requestedBy"`
+	Status       string                         `bson:"status" json:"status"`
+	Participants map[string]DeletionParticipant `bson:"participants" json:"participants"`
+	Attempts     int                            `bson:"attempts" json:"attempts"` // Times the request was sent downstream
+	NextRetryAt  int64                          `bson:"nextRetryAt" json:"nextRetryAt"`
+	Deadline     int64                          `bson:"deadline" json:"deadline"`
+	// Images to remove from Cloudinary once every participant is done
+	ImageURLs     []string `bson:"imageUrls,omitempty" json:"-"`
+	ImagesDeleted bool     `bson:"imagesDeleted" json:"imagesDeleted"`
+	LastError     string   `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt     int64    `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     int64    `bson:"updatedAt" json:"updatedAt"`
+	CompletedAt   int64    `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// DeletionParticipant is one downstream service's progress on a deletion
+type DeletionParticipant struct {
+	Status  string `bson:"status" json:"status"`
+	AckedAt int64  `bson:"ackedAt,omitempty" json:"ackedAt,omitempty"`
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// StartDeletion marks a user as pending deletion and asks every participant
+// service to delete their data. Starting a deletion that is already under way
+// returns the existing request.
+func StartDeletion(ctx context.Context, userID primitive.ObjectID, requestedBy string) (*DeletionRequest, error) {
+	var request *DeletionRequest
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var err error
+		request, err = StartDeletionTx(sessCtx, userID, requestedBy)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Nobody to wait for
+	if request.Status == DeletionPending && allParticipantsDone(request) {
+		return finalizeDeletion(ctx, request.ID)
+	}
+	return request, nil
+}
+
+// StartDeletionTx is StartDeletion for callers that already run in a transaction
+func StartDeletionTx(sessCtx mongo.SessionContext, userID primitive.ObjectID, requestedBy string) (*DeletionRequest, error) {
+	var existing DeletionRequest
+	err := config.GetCollection(DeletionRequestsCollection).FindOne(sessCtx, bson.M{
+		"userId": userID,
+		"status": bson.M{"$in": bson.A{DeletionPending, DeletionFinalizing, DeletionTimedOut}},
+	}).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to check deletion requests: %v", err)
+	}
+
+	var user models.User
+	if err := config.GetCollection(UsersCollection).FindOne(sessCtx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch user: %v", err)
+	}
+
+	now := time.Now()
+	request := DeletionRequest{
+		ID:           primitive.NewObjectID(),
+		UserID:       userID,
+		Email:        user.Email,
+		RequestedBy:  requestedBy,
+		Status:       DeletionPending,
+		Participants: map[string]DeletionParticipant{},
+		Attempts:     1,
+		NextRetryAt:  now.Add(config.DeletionRetryInterval()).Unix(),
+		Deadline:     now.Add(config.DeletionTimeout()).Unix(),
+		CreatedAt:    now.Unix(),
+		UpdatedAt:    now.Unix(),
+	}
+	for _, service := range config.DeletionParticipants() {
+		request.Participants[service] = DeletionParticipant{Status: ParticipantPending}
+	}
+	for _, url := range []string{user.ProfilePictureURL, user.CoverImageURL} {
+		// Only images uploaded for the user are theirs to delete
+		if utils.IsUploadedBy(url, user.Email) {
+			request.ImageURLs = append(request.ImageURLs, url)
+		}
+	}
+
+	// Users deleted straight away rather than after the retention period are
+	// soft-deleted too, so they disappear from lookups while the saga runs
+	_, err = config.GetCollection(UsersCollection).UpdateOne(sessCtx, bson.M{"_id": userID}, mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"deletionStatus":    DeletionPending,
+		"deletionRequestId": request.ID,
+		"deletedAt":         bson.M{"$ifNull": bson.A{"$deletedAt", now.Unix()}},
+		"deletedBy":         bson.M{"$ifNull": bson.A{"$deletedBy", requestedBy}},
+	}}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark user for deletion: %v", err)
+	}
+	if _, err := config.GetCollection(DeletionRequestsCollection).InsertOne(sessCtx, request); err != nil {
+		return nil, fmt.Errorf("failed to create deletion request: %v", err)
+	}
+	if err := recordDeletionRequested(sessCtx, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// GetDeletion returns a deletion request by ID
+func GetDeletion(ctx context.Context, id primitive.ObjectID) (*DeletionRequest, error) {
+	var request DeletionRequest
+	err := config.GetCollection(DeletionRequestsCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&request)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDeletionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deletion request: %v", err)
+	}
+	return &request, nil
+}
+
+// AckDeletion records a participant's answer. Once every participant is
+// done the deletion is finalized straight away. Acks for finished deletions
+// are accepted and ignored, so participants can safely retry them.
+func AckDeletion(ctx context.Context, id primitive.ObjectID, service string, success bool, errMsg string) (*DeletionRequest, error) {
+	request, err := GetDeletion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := request.Participants[service]; !ok {
+		return nil, ErrUnknownParticipant
+	}
+	if request.Status != DeletionPending && request.Status != DeletionTimedOut {
+		return request, nil
+	}
+
+	participant := DeletionParticipant{Status: ParticipantDone, AckedAt: time.Now().Unix()}
+	if !success {
+		participant = DeletionParticipant{Status: ParticipantFailed, AckedAt: time.Now().Unix(), Error: errMsg}
+		log.Printf("⚠️  %s failed to delete data for deletion %s: %s", service, id.Hex(), errMsg)
+	}
+
+	err = config.GetCollection(DeletionRequestsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"participants." + service: participant, "updatedAt": time.Now().Unix()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record acknowledgement: %v", err)
+	}
+
+	if allParticipantsDone(request) {
+		return finalizeDeletion(ctx, id)
+	}
+	return request, nil
+}
+
+// RetryDeletion asks every participant that has not finished to try again and
+// restarts the timeout
+func RetryDeletion(ctx context.Context, id primitive.ObjectID) (*DeletionRequest, error) {
+	request, err := GetDeletion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if request.Status != DeletionPending && request.Status != DeletionTimedOut {
+		return nil, ErrDeletionNotRetrying
+	}
+	if allParticipantsDone(request) {
+		return finalizeDeletion(ctx, id)
+	}
+	if err := resendDeletion(ctx, request, time.Now().Add(config.DeletionTimeout()).Unix()); err != nil {
+		return nil, err
+	}
+	return GetDeletion(ctx, id)
+}
+
+// ProcessDeletions moves every open deletion forward: re-sends requests that
+// are due a retry, times out those past their deadline, and finalizes those
+// that are ready (including finalizations that were interrupted)
+func ProcessDeletions(ctx context.Context) error {
+	now := time.Now()
+	cursor, err := config.GetCollection(DeletionRequestsCollection).Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"status": DeletionPending},
+		bson.M{"status": DeletionFinalizing, "updatedAt": bson.M{"$lte": now.Add(-deletionFinalizeLease).Unix()}},
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch deletion requests: %v", err)
+	}
+	var requests []DeletionRequest
+	if err := cursor.All(ctx, &requests); err != nil {
+		return fmt.Errorf("failed to decode deletion requests: %v", err)
+	}
+
+	for i := range requests {
+		request := &requests[i]
+		var err error
+		switch {
+		case request.Status == DeletionFinalizing || allParticipantsDone(request):
+			_, err = finalizeDeletion(ctx, request.ID)
+		case now.Unix() >= request.Deadline:
+			log.Printf("⚠️  Deletion %s of user %s timed out waiting for %v", request.ID.Hex(), request.UserID.Hex(), unfinishedParticipants(request))
+			_, err = config.GetCollection(DeletionRequestsCollection).UpdateOne(ctx,
+				bson.M{"_id": request.ID, "status": DeletionPending},
+				bson.M{"$set": bson.M{"status": DeletionTimedOut, "updatedAt": now.Unix()}})
+		case now.Unix() >= request.NextRetryAt:
+			err = resendDeletion(ctx, request, request.Deadline)
+		}
+		if err != nil {
+			log.Printf("❌ Failed to process deletion %s: %v", request.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// StartDeletionJob runs ProcessDeletions every minute until the process exits
+func StartDeletionJob() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			if err := ProcessDeletions(ctx); err != nil {
+				log.Printf("❌ Deletion job failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// resendDeletion re-emits the deletion request to unfinished participants,
+// resetting failed ones to pending
+func resendDeletion(ctx context.Context, request *DeletionRequest, deadline int64) error {
+	return config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		set := bson.M{
+			"status":      DeletionPending,
+			"nextRetryAt": time.Now().Add(config.DeletionRetryInterval()).Unix(),
+			"deadline":    deadline,
+			"updatedAt":   time.Now().Unix(),
+		}
+		for service, participant := range request.Participants {
+			if participant.Status == ParticipantFailed {
+				set["participants."+service] = DeletionParticipant{Status: ParticipantPending}
+				request.Participants[service] = DeletionParticipant{Status: ParticipantPending}
+			}
+		}
+
+		err := config.GetCollection(DeletionRequestsCollection).FindOneAndUpdate(sessCtx,
+			bson.M{"_id": request.ID, "status": bson.M{"$in": bson.A{DeletionPending, DeletionTimedOut}}},
+			bson.M{"$set": set, "$inc": bson.M{"attempts": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(request)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Finalized or retried concurrently
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update deletion request: %v", err)
+		}
+		return recordDeletionRequested(sessCtx, request)
+	})
+}
+
+// finalizeDeletion removes the user's images and record and closes the saga.
+// It claims the request first so only one caller finalizes it.
+func finalizeDeletion(ctx context.Context, id primitive.ObjectID) (*DeletionRequest, error) {
+	collection := config.GetCollection(DeletionRequestsCollection)
+	now := time.Now()
+
+	var request DeletionRequest
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"status": bson.M{"$in": bson.A{DeletionPending, DeletionTimedOut}}},
+			bson.M{"status": DeletionFinalizing, "updatedAt": bson.M{"$lte": now.Add(-deletionFinalizeLease).Unix()}},
+		}},
+		bson.M{"$set": bson.M{"status": DeletionFinalizing, "updatedAt": now.Unix()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&request)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Someone else is finalizing it, or it is already done
+		return GetDeletion(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deletion request: %v", err)
+	}
+
+	if !request.ImagesDeleted {
+		for _, url := range request.ImageURLs {
+			publicID := utils.ExtractPublicIDFromURL(url)
+			if publicID == "" {
+				continue
+			}
+			if err := utils.DeleteImageFromCloudinary(publicID); err != nil {
+				// Leave it finalizing; the job retries once the lease runs out
+				collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastError": err.Error()}})
+				return nil, fmt.Errorf("failed to delete image %s: %v", publicID, err)
+			}
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"imagesDeleted": true}}); err != nil {
+			return nil, fmt.Errorf("failed to update deletion request: %v", err)
+		}
+	}
+
+	err = config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if _, err := config.GetCollection(UsersCollection).DeleteOne(sessCtx, bson.M{"_id": request.UserID}); err != nil {
+			return fmt.Errorf("failed to delete user: %v", err)
+		}
+		done := time.Now().Unix()
+		_, err := collection.UpdateOne(sessCtx, bson.M{"_id": id}, bson.M{
+			"$set":   bson.M{"status": DeletionCompleted, "imagesDeleted": true, "completedAt": done, "updatedAt": done},
+			"$unset": bson.M{"lastError": ""},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to complete deletion request: %v", err)
+		}
+		return events.Record(sessCtx, events.UserDeleted, request.UserID, events.UserDeletedData{Email: request.Email})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("✅ User %s deleted (deletion %s)", request.UserID.Hex(), id.Hex())
+	return GetDeletion(ctx, id)
+}
+
+func recordDeletionRequested(sessCtx mongo.SessionContext, request *DeletionRequest) error {
+	return events.Record(sessCtx, events.UserDeletionRequested, request.UserID, events.UserDeletionRequestedData{
+		RequestID: request.ID.Hex(),
+		Email:     request.Email,
+		Services:  unfinishedParticipants(request),
+		Attempt:   request.Attempts,
+	})
+}
+
+func allParticipantsDone(request *DeletionRequest) bool {
+	return len(unfinishedParticipants(request)) == 0
+}
+
+func unfinishedParticipants(request *DeletionRequest) []string {
+	services := []string{}
+	for service, participant := range request.Participants {
+		if participant.Status != ParticipantDone {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
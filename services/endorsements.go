@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const EndorsementsCollection = "endorsements"
+
+var (
+	ErrCannotEndorseSelf   = errors.New("you cannot endorse your own skills")
+	ErrSkillNotListed      = errors.New("user does not list that skill")
+	ErrAlreadyEndorsed     = errors.New("you already endorsed that skill")
+	ErrEndorsementNotFound = errors.New("endorsement not found")
+)
+
+// Endorsement is one user vouching for another's skill
+type Endorsement struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	EndorserID primitive.ObjectID `bson:"endorserId" json:"endorserId"`
+	Skill      string             `bson:"skill" json:"skill"`
+	Key        string             `bson:"key" json:"-"` // SkillKey of Skill
+	CreatedAt  int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// SkillEndorsements is how many users endorsed one of a user's skills
+type SkillEndorsements struct {
+	Skill string `json:"skill"`
+	Count int    `json:"count"`
+}
+
+// EndorseSkill records endorserID vouching for one of userID's skills. Users
+// who have blocked each other cannot endorse each other.
+func EndorseSkill(ctx context.Context, endorserID, userID primitive.ObjectID, skill string) (*Endorsement, error) {
+	if endorserID == userID {
+		return nil, ErrCannotEndorseSelf
+	}
+	blocked, err := isBlocked(ctx, endorserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrUserBlocked
+	}
+	profile, err := GetVisibleProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key := SkillKey(skill)
+	endorsement := Endorsement{ID: primitive.NewObjectID(), UserID: userID, EndorserID: endorserID, Key: key, CreatedAt: time.Now().Unix()}
+	for _, listed := range profile.Skills {
+		if SkillKey(listed) == key {
+			endorsement.Skill = listed
+		}
+	}
+	if key == "" || endorsement.Skill == "" {
+		return nil, ErrSkillNotListed
+	}
+
+	_, err = config.GetCollection(EndorsementsCollection).InsertOne(ctx, endorsement)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyEndorsed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to endorse skill: %v", err)
+	}
+	return &endorsement, nil
+}
+
+// RemoveEndorsement withdraws endorserID's endorsement of one of userID's skills
+func RemoveEndorsement(ctx context.Context, endorserID, userID primitive.ObjectID, skill string) error {
+	result, err := config.GetCollection(EndorsementsCollection).DeleteOne(ctx,
+		bson.M{"userId": userID, "endorserId": endorserID, "key": SkillKey(skill)})
+	if err != nil {
+		return fmt.Errorf("failed to remove endorsement: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrEndorsementNotFound
+	}
+	return nil
+}
+
+// ListSkillEndorsements counts the endorsements of each skill a user lists, in the order they list them
+func ListSkillEndorsements(ctx context.Context, userID primitive.ObjectID) ([]SkillEndorsements, error) {
+	profile, err := GetVisibleProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(profile.Skills))
+	for _, skill := range profile.Skills {
+		keys = append(keys, SkillKey(skill))
+	}
+	counts, err := endorsementCounts(ctx, []primitive.ObjectID{userID}, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	endorsements := make([]SkillEndorsements, 0, len(profile.Skills))
+	for i, skill := range profile.Skills {
+		endorsements = append(endorsements, SkillEndorsements{Skill: skill, Count: counts[userID][keys[i]]})
+	}
+	return endorsements, nil
+}
+
+// endorsementCounts counts the endorsements of users' skills, by user and
+// skill key. Endorsements by suspended users, and by users either side has
+// blocked since, do not count.
+func endorsementCounts(ctx context.Context, userIDs []primitive.ObjectID, keys []string) (map[primitive.ObjectID]map[string]int, error) {
+	counts := map[primitive.ObjectID]map[string]int{}
+	if len(userIDs) == 0 || len(keys) == 0 {
+		return counts, nil
+	}
+	suspended, err := suspendedUserIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blockedBetween := func(a, b string) bson.M {
+		return bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$blockerId", a}},
+			bson.M{"$eq": bson.A{"$blockedId", b}},
+		}}
+	}
+	cursor, err := config.GetCollection(EndorsementsCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"userId":     bson.M{"$in": userIDs},
+			"key":        bson.M{"$in": keys},
+			"endorserId": bson.M{"$nin": suspended},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": BlocksCollection,
+			"let":  bson.M{"user": "$userId", "endorser": "$endorserId"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$or": bson.A{
+					blockedBetween("$$user", "$$endorser"),
+					blockedBetween("$$endorser", "$$user"),
+				}}}},
+				bson.M{"$limit": 1},
+			},
+			"as": "blocks",
+		}}},
+		{{Key: "$match", Value: bson.M{"blocks": bson.M{"$size": 0}}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"userId": "$userId", "key": "$key"}, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count endorsements: %v", err)
+	}
+	var groups []struct {
+		ID struct {
+			UserID primitive.ObjectID `bson:"userId"`
+			Key    string             `bson:"key"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode endorsement counts: %v", err)
+	}
+	for _, group := range groups {
+		if counts[group.ID.UserID] == nil {
+			counts[group.ID.UserID] = map[string]int{}
+		}
+		counts[group.ID.UserID][group.ID.Key] = group.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/utils"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DataExportsCollection   = "dataExports"
+	NotificationsCollection = "notifications"
+)
+
+// Export statuses
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+	ExportExpired    = "expired" // The archive was deleted once its link expired
+)
+
+const (
+	exportMaxAttempts = 3
+	exportLease       = 10 * time.Minute
+)
+
+var (
+	ErrExportNotFound    = errors.New("data export not found")
+	ErrExportNotReady    = errors.New("data export is not ready")
+	ErrInvalidExportLink = errors.New("download link is invalid or has expired")
+)
+
+// DataExport is a user's request for a copy of their data and, once built, the archive holding it
+type DataExport struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	Status      string             `bson:"status" json:"status"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	LockedUntil int64              `bson:"lockedUntil" json:"-"`
+	FilePath    string             `bson:"filePath,omitempty" json:"-"`
+	Size        int64              `bson:"size,omitempty" json:"size,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   int64              `bson:"createdAt" json:"createdAt"`
+	CompletedAt int64              `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	ExpiresAt   int64              `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"` // When the download link stops working
+}
+
+// exportManifest is written to manifest.json at the root of every archive
+type exportManifest struct {
+	UserID      string   `json:"userId"`
+	GeneratedAt int64    `json:"generatedAt"`
+	Files       []string `json:"files"`
+	Errors      []string `json:"errors,omitempty"` // Parts that could not be included, e.g. an unreachable image
+}
+
+// RequestExport queues an export of the user's data. If one is already queued
+// or being built, that one is returned instead.
+func RequestExport(ctx context.Context, userID primitive.ObjectID) (*DataExport, error) {
+	collection := config.GetCollection(DataExportsCollection)
+
+	var existing DataExport
+	err := collection.FindOne(ctx, bson.M{
+		"userId": userID,
+		"status": bson.M{"$in": bson.A{ExportPending, ExportProcessing}},
+	}).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to check data exports: %v", err)
+	}
+
+	export := DataExport{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Status:    ExportPending,
+		CreatedAt: time.Now().Unix(),
+	}
+	if _, err := collection.InsertOne(ctx, export); err != nil {
+		return nil, fmt.Errorf("failed to queue data export: %v", err)
+	}
+	return &export, nil
+}
+
+// GetExport returns one of the user's exports
+func GetExport(ctx context.Context, userID, exportID primitive.ObjectID) (*DataExport, error) {
+	var export DataExport
+	err := config.GetCollection(DataExportsCollection).FindOne(ctx, bson.M{"_id": exportID, "userId": userID}).Decode(&export)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data export: %v", err)
+	}
+	return &export, nil
+}
+
+// ExportDownloadURL returns the signed link a ready export can be downloaded
+// from without logging in. It stops working when the export expires.
+func ExportDownloadURL(export *DataExport) string {
+	expires := strconv.FormatInt(export.ExpiresAt, 10)
+	return fmt.Sprintf("%s/api/account/export/%s/download?expires=%s&signature=%s",
+		config.PublicAPIURL(), export.ID.Hex(), expires, signExportLink(export.ID.Hex(), expires))
+}
+
+// OpenExportDownload checks a download link's signature and expiry and
+// returns the export it points to, ready to serve from FilePath
+func OpenExportDownload(ctx context.Context, exportID, expires, signature string) (*DataExport, error) {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return nil, ErrInvalidExportLink
+	}
+	if !hmac.Equal([]byte(signature), []byte(signExportLink(exportID, expires))) {
+		return nil, ErrInvalidExportLink
+	}
+
+	id, err := primitive.ObjectIDFromHex(exportID)
+	if err != nil {
+		return nil, ErrInvalidExportLink
+	}
+	var export DataExport
+	err = config.GetCollection(DataExportsCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&export)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data export: %v", err)
+	}
+	if export.Status != ExportReady {
+		return nil, ErrExportNotReady
+	}
+	return &export, nil
+}
+
+// ProcessExports builds every queued export and deletes archives whose link
+// has expired. It returns how many exports were completed.
+func ProcessExports(ctx context.Context) (int, error) {
+	if err := expireExports(ctx); err != nil {
+		log.Printf("❌ Failed to expire data exports: %v", err)
+	}
+
+	completed := 0
+	for {
+		export, err := claimExport(ctx)
+		if err != nil {
+			return completed, err
+		}
+		if export == nil {
+			return completed, nil
+		}
+
+		if err := completeExport(ctx, export); err != nil {
+			log.Printf("❌ Failed to build data export %s: %v", export.ID.Hex(), err)
+			status := ExportPending
+			if export.Attempts >= exportMaxAttempts {
+				status = ExportFailed
+			}
+			_, err = config.GetCollection(DataExportsCollection).UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{
+				"$set": bson.M{"status": status, "error": err.Error(), "lockedUntil": 0},
+			})
+			if err != nil {
+				return completed, fmt.Errorf("failed to update data export: %v", err)
+			}
+			continue
+		}
+		completed++
+	}
+}
+
+// StartExportJob runs ProcessExports on the given interval until the process exits
+func StartExportJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			if _, err := ProcessExports(ctx); err != nil {
+				log.Printf("❌ Data export job failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// claimExport leases the oldest queued export, or one whose builder crashed
+func claimExport(ctx context.Context) (*DataExport, error) {
+	now := time.Now()
+	var export DataExport
+	err := config.GetCollection(DataExportsCollection).FindOneAndUpdate(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"status": ExportPending},
+			bson.M{"status": ExportProcessing, "lockedUntil": bson.M{"$lte": now.Unix()}},
+		}},
+		bson.M{
+			"$set": bson.M{"status": ExportProcessing, "lockedUntil": now.Add(exportLease).Unix()},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.M{"createdAt": 1}).SetReturnDocument(options.After),
+	).Decode(&export)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim data export: %v", err)
+	}
+	return &export, nil
+}
+
+// completeExport builds the archive, marks the export ready and tells the user
+func completeExport(ctx context.Context, export *DataExport) error {
+	var profile bson.M
+	err := config.GetCollection(UsersCollection).FindOne(ctx, NotDeleted(bson.M{"_id": export.UserID})).Decode(&profile)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %v", err)
+	}
+	delete(profile, "password")
+
+	path, size, err := writeExportArchive(ctx, export, profile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	export.Status = ExportReady
+	export.FilePath = path
+	export.Size = size
+	export.CompletedAt = now.Unix()
+	export.ExpiresAt = now.Add(config.ExportLinkTTL()).Unix()
+	_, err = config.GetCollection(DataExportsCollection).UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{
+		"$set": bson.M{
+			"status":      ExportReady,
+			"filePath":    path,
+			"size":        size,
+			"completedAt": export.CompletedAt,
+			"expiresAt":   export.ExpiresAt,
+			"lockedUntil": 0,
+		},
+		"$unset": bson.M{"error": ""},
+	})
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to update data export: %v", err)
+	}
+
+	email, _ := profile["email"].(string)
+	notifyExportReady(ctx, export, email)
+	return nil
+}
+
+// writeExportArchive writes the user's data to a ZIP in the export directory
+// and returns its path and size
+func writeExportArchive(ctx context.Context, export *DataExport, profile bson.M) (string, int64, error) {
+	if err := os.MkdirAll(config.ExportDir(), 0o700); err != nil {
+		return "", 0, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	path := filepath.Join(config.ExportDir(), export.ID.Hex()+".zip")
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create export archive: %v", err)
+	}
+	defer os.Remove(tmp)
+
+	archive := zip.NewWriter(file)
+	manifest := exportManifest{UserID: export.UserID.Hex(), GeneratedAt: time.Now().Unix()}
+
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+		return addExportFile(archive, &manifest, name, data)
+	}
+
+	if err := addJSON("profile.json", profile); err != nil {
+		file.Close()
+		return "", 0, err
+	}
+	for _, section := range exportSections(export.UserID) {
+		v, err := section.load(ctx)
+		if err != nil {
+			file.Close()
+			return "", 0, err
+		}
+		if err := addJSON(section.name, v); err != nil {
+			file.Close()
+			return "", 0, err
+		}
+	}
+
+	images := []struct{ name, src string }{
+		{"profile-picture", stringField(profile, "profilePictureURL")},
+		{"cover-image", stringField(profile, "coverImageURL")},
+	}
+	for _, image := range images {
+		name, src := image.name, image.src
+		if src == "" {
+			continue
+		}
+		data, _, ext, err := utils.FetchImage(ctx, src)
+		if err != nil {
+			manifest.Errors = append(manifest.Errors, fmt.Sprintf("images/%s: %v", name, err))
+			continue
+		}
+		if err := addExportFile(archive, &manifest, "images/"+name+ext, data); err != nil {
+			file.Close()
+			return "", 0, err
+		}
+	}
+
+	if err := addJSON("manifest.json", manifest); err != nil {
+		file.Close()
+		return "", 0, err
+	}
+	if err := archive.Close(); err != nil {
+		file.Close()
+		return "", 0, fmt.Errorf("failed to write export archive: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return "", 0, fmt.Errorf("failed to write export archive: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to write export archive: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", 0, fmt.Errorf("failed to write export archive: %v", err)
+	}
+	return path, info.Size(), nil
+}
+
+// exportSection is one JSON file in an export besides the profile
+type exportSection struct {
+	name string
+	load func(context.Context) (interface{}, error)
+}
+
+func exportSections(userID primitive.ObjectID) []exportSection {
+	return []exportSection{
+		{"credits/ledger.json", func(ctx context.Context) (interface{}, error) {
+			return findAll[LedgerEntry](ctx, LedgerCollection, bson.M{"userId": userID})
+		}},
+		{"credits/lots.json", func(ctx context.Context) (interface{}, error) {
+			return findAll[CreditLot](ctx, LotsCollection, bson.M{"userId": userID})
+		}},
+		{"credits/holds.json", func(ctx context.Context) (interface{}, error) {
+			return findAll[CreditHold](ctx, HoldsCollection, bson.M{"userId": userID})
+		}},
+		{"login-history.json", func(ctx context.Context) (interface{}, error) {
+			return ListLogins(ctx, userID)
+		}},
+		// Every login issues a token, so logins whose token is still valid are the open sessions
+		{"sessions.json", func(ctx context.Context) (interface{}, error) {
+			return findAll[LoginRecord](ctx, LoginHistoryCollection, bson.M{
+				"userId":         userID,
+				"tokenExpiresAt": bson.M{"$gt": time.Now().Unix()},
+			})
+		}},
+	}
+}
+
+// findAll returns every document in a collection matching filter, oldest first
+func findAll[T any](ctx context.Context, collection string, filter bson.M) ([]T, error) {
+	cursor, err := config.GetCollection(collection).Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", collection, err)
+	}
+	results := []T{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", collection, err)
+	}
+	return results, nil
+}
+
+func addExportFile(archive *zip.Writer, manifest *exportManifest, name string, data []byte) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export: %v", name, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to add %s to export: %v", name, err)
+	}
+	manifest.Files = append(manifest.Files, name)
+	return nil
+}
+
+// notifyExportReady sends the download link as an in-app notification and,
+// when SMTP is configured, by email. Failures are logged; the export stays
+// available from its status endpoint either way.
+func notifyExportReady(ctx context.Context, export *DataExport, email string) {
+	link := ExportDownloadURL(export)
+	expires := time.Unix(export.ExpiresAt, 0).UTC().Format("2 Jan 2006 15:04 MST")
+	message := fmt.Sprintf("Your TradeMinutes data export is ready. Download it before %s: %s", expires, link)
+
+	_, err := config.GetCollection(NotificationsCollection).InsertOne(ctx, models.Notification{
+		ID:        primitive.NewObjectID(),
+		UserID:    export.UserID,
+		Type:      "data_export_ready",
+		Title:     "Your data export is ready",
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Failed to notify %s of data export %s: %v", export.UserID.Hex(), export.ID.Hex(), err)
+	}
+
+	if email == "" || !utils.EmailConfigured() {
+		return
+	}
+	body := message + "\n\nIf you did not ask for a copy of your data, please contact support.\n"
+	if err := utils.SendEmail(email, "Your TradeMinutes data export is ready", body); err != nil {
+		log.Printf("Failed to email %s about data export %s: %v", email, export.ID.Hex(), err)
+	}
+}
+
+// expireExports deletes archives whose download link has expired
+func expireExports(ctx context.Context) error {
+	collection := config.GetCollection(DataExportsCollection)
+	cursor, err := collection.Find(ctx, bson.M{"status": ExportReady, "expiresAt": bson.M{"$lte": time.Now().Unix()}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch expired data exports: %v", err)
+	}
+	var exports []DataExport
+	if err := cursor.All(ctx, &exports); err != nil {
+		return fmt.Errorf("failed to decode expired data exports: %v", err)
+	}
+
+	for _, export := range exports {
+		if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete data export %s: %v", export.ID.Hex(), err)
+			continue
+		}
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{
+			"$set":   bson.M{"status": ExportExpired},
+			"$unset": bson.M{"filePath": ""},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update data export: %v", err)
+		}
+	}
+	return nil
+}
+
+func signExportLink(exportID, expires string) string {
+	mac := hmac.New(sha256.New, config.ExportSigningKey())
+	mac.Write([]byte(exportID + "." + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func stringField(doc bson.M, key string) string {
+	s, _ := doc[key].(string)
+	return s
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+)
+
+// ReverseGeocodeRadiusKm is how far from a gazetteer city coordinates may be
+// and still be placed in it
+const ReverseGeocodeRadiusKm = 50
+
+const earthRadiusKm = 6371.0
+
+// GazetteerGeocoder geocodes offline from a built-in list of cities. It knows
+// only the cities in gazetteerCities; anything else is not found.
+type GazetteerGeocoder struct{}
+
+// gazetteerCity is a city the gazetteer knows, with its other spellings
+type gazetteerCity struct {
+	City      string
+	Region    string
+	Country   string
+	Latitude  float64
+	Longitude float64
+	Aliases   []string
+}
+
+// gazetteerCities is in order of preference: a bare city name that several
+// entries share, like "Cambridge", resolves to the first of them
+var gazetteerCities = []gazetteerCity{
+	// United States
+	{City: "New York", Region: "New York", Country: "United States", Latitude: 40.7128, Longitude: -74.0060, Aliases: []string{"NYC", "New York City", "Manhattan"}},
+	{City: "Los Angeles", Region: "California", Country: "United States", Latitude: 34.0522, Longitude: -118.2437, Aliases: []string{"LA"}},
+	{City: "Chicago", Region: "Illinois", Country: "United States", Latitude: 41.8781, Longitude: -87.6298},
+	{City: "Houston", Region: "Texas", Country: "United States", Latitude: 29.7604, Longitude: -95.3698},
+	{City: "Boston", Region: "Massachusetts", Country: "United States", Latitude: 42.3601, Longitude: -71.0589},
+	{City: "Cambridge", Region: "Massachusetts", Country: "United States", Latitude: 42.3736, Longitude: -71.1097},
+	{City: "San Francisco", Region: "California", Country: "United States", Latitude: 37.7749, Longitude: -122.4194, Aliases: []string{"SF"}},
+	{City: "San Jose", Region: "California", Country: "United States", Latitude: 37.3382, Longitude: -121.8863},
+	{City: "Palo Alto", Region: "California", Country: "United States", Latitude: 37.4419, Longitude: -122.1430},
+	{City: "Berkeley", Region: "California", Country: "United States", Latitude: 37.8715, Longitude: -122.2730},
+	{City: "San Diego", Region: "California", Country: "United States", Latitude: 32.7157, Longitude: -117.1611},
+	{City: "Seattle", Region: "Washington", Country: "United States", Latitude: 47.6062, Longitude: -122.3321},
+	{City: "Portland", Region: "Oregon", Country: "United States", Latitude: 45.5152, Longitude: -122.6784},
+	{City: "Austin", Region: "Texas", Country: "United States", Latitude: 30.2672, Longitude: -97.7431},
+	{City: "Dallas", Region: "Texas", Country: "United States", Latitude: 32.7767, Longitude: -96.7970},
+	{City: "Denver", Region: "Colorado", Country: "United States", Latitude: 39.7392, Longitude: -104.9903},
+	{City: "Atlanta", Region: "Georgia", Country: "United States", Latitude: 33.7490, Longitude: -84.3880},
+	{City: "Miami", Region: "Florida", Country: "United States", Latitude: 25.7617, Longitude: -80.1918},
+	{City: "Washington", Region: "District of Columbia", Country: "United States", Latitude: 38.9072, Longitude: -77.0369, Aliases: []string{"Washington DC", "DC"}},
+	{City: "Philadelphia", Region: "Pennsylvania", Country: "United States", Latitude: 39.9526, Longitude: -75.1652},
+	{City: "Pittsburgh", Region: "Pennsylvania", Country: "United States", Latitude: 40.4406, Longitude: -79.9959},
+	{City: "Ann Arbor", Region: "Michigan", Country: "United States", Latitude: 42.2808, Longitude: -83.7430},
+	{City: "Minneapolis", Region: "Minnesota", Country: "United States", Latitude: 44.9778, Longitude: -93.2650},
+	{City: "Phoenix", Region: "Arizona", Country: "United States", Latitude: 33.4484, Longitude: -112.0740},
+	// Canada
+	{City: "Toronto", Region: "Ontario", Country: "Canada", Latitude: 43.6532, Longitude: -79.3832},
+	{City: "Waterloo", Region: "Ontario", Country: "Canada", Latitude: 43.4643, Longitude: -80.5204},
+	{City: "Ottawa", Region: "Ontario", Country: "Canada", Latitude: 45.4215, Longitude: -75.6972},
+	{City: "Montreal", Region: "Quebec", Country: "Canada", Latitude: 45.5019, Longitude: -73.5674, Aliases: []string{"Montréal"}},
+	{City: "Vancouver", Region: "British Columbia", Country: "Canada", Latitude: 49.2827, Longitude: -123.1207},
+	{City: "Calgary", Region: "Alberta", Country: "Canada", Latitude: 51.0447, Longitude: -114.0719},
+	// United Kingdom and Ireland
+	{City: "London", Region: "England", Country: "United Kingdom", Latitude: 51.5072, Longitude: -0.1276},
+	{City: "Cambridge", Region: "England", Country: "United Kingdom", Latitude: 52.2053, Longitude: 0.1218},
+	{City: "Oxford", Region: "England", Country: "United Kingdom", Latitude: 51.7520, Longitude: -1.2577},
+	{City: "Manchester", Region: "England", Country: "United Kingdom", Latitude: 53.4808, Longitude: -2.2426},
+	{City: "Edinburgh", Region: "Scotland", Country: "United Kingdom", Latitude: 55.9533, Longitude: -3.1883},
+	{City: "Dublin", Region: "Leinster", Country: "Ireland", Latitude: 53.3498, Longitude: -6.2603},
+	// Europe
+	{City: "Paris", Region: "Île-de-France", Country: "France", Latitude: 48.8566, Longitude: 2.3522},
+	{City: "Berlin", Region: "Berlin", Country: "Germany", Latitude: 52.5200, Longitude: 13.4050},
+	{City: "Munich", Region: "Bavaria", Country: "Germany", Latitude: 48.1351, Longitude: 11.5820, Aliases: []string{"München"}},
+	{City: "Amsterdam", Region: "North Holland", Country: "Netherlands", Latitude: 52.3676, Longitude: 4.9041},
+	{City: "Madrid", Region: "Community of Madrid", Country: "Spain", Latitude: 40.4168, Longitude: -3.7038},
+	{City: "Barcelona", Region: "Catalonia", Country: "Spain", Latitude: 41.3874, Longitude: 2.1686},
+	{City: "Zurich", Region: "Zurich", Country: "Switzerland", Latitude: 47.3769, Longitude: 8.5417, Aliases: []string{"Zürich"}},
+	{City: "Stockholm", Region: "Stockholm", Country: "Sweden", Latitude: 59.3293, Longitude: 18.0686},
+	// Asia and Oceania
+	{City: "Bengaluru", Region: "Karnataka", Country: "India", Latitude: 12.9716, Longitude: 77.5946, Aliases: []string{"Bangalore"}},
+	{City: "Mumbai", Region: "Maharashtra", Country: "India", Latitude: 19.0760, Longitude: 72.8777, Aliases: []string{"Bombay"}},
+	{City: "Pune", Region: "Maharashtra", Country: "India", Latitude: 18.5204, Longitude: 73.8567},
+	{City: "Delhi", Region: "Delhi", Country: "India", Latitude: 28.7041, Longitude: 77.1025, Aliases: []string{"New Delhi"}},
+	{City: "Hyderabad", Region: "Telangana", Country: "India", Latitude: 17.3850, Longitude: 78.4867},
+	{City: "Chennai", Region: "Tamil Nadu", Country: "India", Latitude: 13.0827, Longitude: 80.2707, Aliases: []string{"Madras"}},
+	{City: "Singapore", Region: "Singapore", Country: "Singapore", Latitude: 1.3521, Longitude: 103.8198},
+	{City: "Tokyo", Region: "Tokyo", Country: "Japan", Latitude: 35.6762, Longitude: 139.6503},
+	{City: "Seoul", Region: "Seoul", Country: "South Korea", Latitude: 37.5665, Longitude: 126.9780},
+	{City: "Hong Kong", Region: "Hong Kong", Country: "China", Latitude: 22.3193, Longitude: 114.1694},
+	{City: "Sydney", Region: "New South Wales", Country: "Australia", Latitude: -33.8688, Longitude: 151.2093},
+	{City: "Melbourne", Region: "Victoria", Country: "Australia", Latitude: -37.8136, Longitude: 144.9631},
+	{City: "Auckland", Region: "Auckland", Country: "New Zealand", Latitude: -36.8485, Longitude: 174.7633},
+	// Africa and the Americas
+	{City: "Lagos", Region: "Lagos", Country: "Nigeria", Latitude: 6.5244, Longitude: 3.3792},
+	{City: "Nairobi", Region: "Nairobi", Country: "Kenya", Latitude: -1.2921, Longitude: 36.8219},
+	{City: "Cape Town", Region: "Western Cape", Country: "South Africa", Latitude: -33.9249, Longitude: 18.4241},
+	{City: "Mexico City", Region: "Mexico City", Country: "Mexico", Latitude: 19.4326, Longitude: -99.1332, Aliases: []string{"CDMX", "Ciudad de México"}},
+	{City: "São Paulo", Region: "São Paulo", Country: "Brazil", Latitude: -23.5505, Longitude: -46.6333, Aliases: []string{"Sao Paulo"}},
+	{City: "Buenos Aires", Region: "Buenos Aires", Country: "Argentina", Latitude: -34.6037, Longitude: -58.3816},
+}
+
+// regionAliases are abbreviations people write for regions
+var regionAliases = map[string]string{
+	"ny": "New York", "ca": "California", "il": "Illinois", "tx": "Texas", "ma": "Massachusetts",
+	"wa": "Washington", "or": "Oregon", "co": "Colorado", "ga": "Georgia", "fl": "Florida",
+	"dc": "District of Columbia", "d.c.": "District of Columbia", "pa": "Pennsylvania",
+	"mi": "Michigan", "mn": "Minnesota", "az": "Arizona",
+	"on": "Ontario", "qc": "Quebec", "bc": "British Columbia", "ab": "Alberta",
+	"nsw": "New South Wales", "vic": "Victoria",
+}
+
+// countryAliases are other names and codes people write for countries
+var countryAliases = map[string]string{
+	"us": "United States", "usa": "United States", "u.s.": "United States", "u.s.a.": "United States", "united states of america": "United States",
+	"uk": "United Kingdom", "u.k.": "United Kingdom", "gb": "United Kingdom", "great britain": "United Kingdom", "britain": "United Kingdom",
+	"england": "United Kingdom", "scotland": "United Kingdom",
+	"ca": "Canada", "in": "India", "de": "Germany", "fr": "France", "nl": "Netherlands", "es": "Spain",
+	"ch": "Switzerland", "se": "Sweden", "ie": "Ireland", "sg": "Singapore", "jp": "Japan", "kr": "South Korea",
+	"au": "Australia", "nz": "New Zealand", "ng": "Nigeria", "ke": "Kenya", "za": "South Africa",
+	"mx": "Mexico", "br": "Brazil", "ar": "Argentina", "deutschland": "Germany", "españa": "Spain",
+}
+
+// Geocode places text like "Boston, MA" or "Cambridge, UK": the first part
+// names the city, and any others must name its region or country
+func (GazetteerGeocoder) Geocode(ctx context.Context, query string) (*Place, error) {
+	var parts []string
+	for _, part := range strings.Split(query, ",") {
+		if part = gazetteerKey(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, ErrPlaceNotFound
+	}
+
+	for _, city := range gazetteerCities {
+		if !city.hasName(parts[0]) {
+			continue
+		}
+		qualified := true
+		for _, qualifier := range parts[1:] {
+			qualified = qualified && city.inArea(qualifier)
+		}
+		if qualified {
+			return city.place(), nil
+		}
+	}
+	return nil, ErrPlaceNotFound
+}
+
+// ReverseGeocode places coordinates in the nearest gazetteer city within ReverseGeocodeRadiusKm
+func (GazetteerGeocoder) ReverseGeocode(ctx context.Context, latitude, longitude float64) (*Place, error) {
+	var nearest *gazetteerCity
+	best := float64(ReverseGeocodeRadiusKm)
+	for i, city := range gazetteerCities {
+		if d := distanceKm(latitude, longitude, city.Latitude, city.Longitude); d <= best {
+			nearest, best = &gazetteerCities[i], d
+		}
+	}
+	if nearest == nil {
+		return nil, ErrPlaceNotFound
+	}
+	place := nearest.place()
+	// Keep the user's own coordinates; the city only names where they are
+	place.Point = newGeoPoint(latitude, longitude)
+	return place, nil
+}
+
+func (c gazetteerCity) hasName(key string) bool {
+	if gazetteerKey(c.City) == key {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if gazetteerKey(alias) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// inArea reports whether key names the city's region or country
+func (c gazetteerCity) inArea(key string) bool {
+	region, country := gazetteerKey(c.Region), gazetteerKey(c.Country)
+	switch key {
+	case region, country:
+		return true
+	}
+	if name, ok := regionAliases[key]; ok && gazetteerKey(name) == region {
+		return true
+	}
+	if name, ok := countryAliases[key]; ok && gazetteerKey(name) == country {
+		return true
+	}
+	return false
+}
+
+func (c gazetteerCity) place() *Place {
+	return &Place{City: c.City, Region: c.Region, Country: c.Country, Point: newGeoPoint(c.Latitude, c.Longitude)}
+}
+
+// gazetteerKey is the form gazetteer names are compared by
+func gazetteerKey(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat, dLng := toRadians(lat2-lat1), toRadians(lng2-lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(math.Min(1, a)))
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const HoldsCollection = "creditHolds"
+
+// Hold statuses
+const (
+	HoldActive   = "held"
+	HoldCaptured = "captured"
+	HoldReleased = "released"
+	HoldExpired  = "expired"
+)
+
+var (
+	ErrInvalidAmount      = errors.New("amount must be positive")
+	ErrHoldNotFound       = errors.New("credit hold not found")
+	ErrHoldNotActive      = errors.New("credit hold is no longer active")
+	ErrCaptureExceedsHold = errors.New("capture exceeds the held amount")
+)
+
+// CreditHold reserves credits for a later capture, e.g. while a booking awaits
+// confirmation. Held credits stay in the balance but cannot be spent elsewhere;
+// the user document's heldCredits tracks the total reserved.
+type CreditHold struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Amount    int                `bson:"amount" json:"amount"`
+	Captured  int                `bson:"captured" json:"captured"`
+	Status    string             `bson:"status" json:"status"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	Reference string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	// LedgerEntryID is the deduction posted when the hold was captured
+	LedgerEntryID  primitive.ObjectID `bson:"ledgerEntryId,omitempty" json:"ledgerEntryId,omitempty"`
+	IdempotencyKey string             `bson:"idempotencyKey,omitempty" json:"-"`
+	ExpiresAt      int64              `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt      int64              `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      int64              `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Balance is a user's balance split into what is reserved and what can be spent
+type Balance struct {
+	UserID    primitive.ObjectID `json:"userId"`
+	Credits   int                `json:"credits"`
+	Held      int                `json:"held"`
+	Available int                `json:"available"`
+}
+
+// GetBalance returns a user's balance along with the credits reserved by holds
+func GetBalance(ctx context.Context, userID primitive.ObjectID) (*Balance, error) {
+	var user struct {
+		Credits     int `bson:"credits"`
+		HeldCredits int `bson:"heldCredits"`
+	}
+	err := config.GetCollection(UsersCollection).FindOne(ctx, bson.M{"_id": userID}, options.FindOne().SetProjection(bson.M{"credits": 1, "heldCredits": 1})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch balance: %v", err)
+	}
+	return &Balance{
+		UserID:    userID,
+		Credits:   user.Credits,
+		Held:      user.HeldCredits,
+		Available: user.Credits - user.HeldCredits,
+	}, nil
+}
+
+// PlaceHold reserves credits until they are captured, released or the hold
+// expires (ttl, or CREDIT_HOLD_TTL if zero). Retrying with the same
+// idempotencyKey returns the hold already placed.
+func PlaceHold(ctx context.Context, userID primitive.ObjectID, amount int, reason, reference string, ttl time.Duration, idempotencyKey string) (*CreditHold, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if ttl <= 0 {
+		ttl = config.CreditHoldTTL()
+	}
+	if idempotencyKey != "" {
+		idempotencyKey = userID.Hex() + ":" + idempotencyKey
+		if existing, err := holdByIdempotencyKey(ctx, idempotencyKey); err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	now := time.Now()
+	hold := CreditHold{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		Amount:         amount,
+		Status:         HoldActive,
+		Reason:         reason,
+		Reference:      reference,
+		IdempotencyKey: idempotencyKey,
+		ExpiresAt:      now.Add(ttl).Unix(),
+		CreatedAt:      now.Unix(),
+		UpdatedAt:      now.Unix(),
+	}
+
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if err := ensureNotSuspended(sessCtx, userID); err != nil {
+			return err
+		}
+		if err := reserveCredits(sessCtx, userID, amount); err != nil {
+			return err
+		}
+		if _, err := config.GetCollection(HoldsCollection).InsertOne(sessCtx, hold); err != nil {
+			return fmt.Errorf("failed to create credit hold: %w", err)
+		}
+		return nil
+	})
+	if mongo.IsDuplicateKeyError(err) && idempotencyKey != "" {
+		return holdByIdempotencyKey(ctx, idempotencyKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// CaptureHold turns a hold into a deduction of amount (0 captures it all),
+// releasing whatever is left over. The deduction can be refunded like any other.
+func CaptureHold(ctx context.Context, holdID primitive.ObjectID, amount int, reason string) (*CreditHold, *LedgerEntry, error) {
+	if amount < 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+
+	var hold *CreditHold
+	var entry *LedgerEntry
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var err error
+		hold, err = closeHold(sessCtx, holdID, HoldCaptured)
+		if err != nil {
+			return err
+		}
+		// The hold stays active so it can be captured once the suspension ends, or released
+		if err := ensureNotSuspended(sessCtx, hold.UserID); err != nil {
+			return err
+		}
+		if amount == 0 {
+			amount = hold.Amount
+		}
+		if amount > hold.Amount {
+			return ErrCaptureExceedsHold
+		}
+		if reason == "" {
+			reason = hold.Reason
+		}
+
+		entry, err = applyDebit(sessCtx, LedgerEntry{
+			UserID:    hold.UserID,
+			Type:      EntryTypeDeduction,
+			Amount:    -amount,
+			Note:      reason,
+			Reference: hold.Reference,
+		})
+		if err != nil {
+			return err
+		}
+
+		hold.Captured = amount
+		hold.LedgerEntryID = entry.ID
+		_, err = config.GetCollection(HoldsCollection).UpdateOne(sessCtx, bson.M{"_id": hold.ID}, bson.M{"$set": bson.M{
+			"captured":      hold.Captured,
+			"ledgerEntryId": hold.LedgerEntryID,
+		}})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return hold, entry, nil
+}
+
+// ReleaseHold cancels a hold, making its credits spendable again
+func ReleaseHold(ctx context.Context, holdID primitive.ObjectID) (*CreditHold, error) {
+	var hold *CreditHold
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var err error
+		hold, err = closeHold(sessCtx, holdID, HoldReleased)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ReleaseExpiredHolds releases every active hold past its expiry and returns how many it released
+func ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	cursor, err := config.GetCollection(HoldsCollection).Find(ctx, bson.M{
+		"status":    HoldActive,
+		"expiresAt": bson.M{"$lte": time.Now().Unix()},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch expired holds: %v", err)
+	}
+	var holds []CreditHold
+	if err := cursor.All(ctx, &holds); err != nil {
+		return 0, fmt.Errorf("failed to decode expired holds: %v", err)
+	}
+
+	released := 0
+	for _, hold := range holds {
+		err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			_, err := closeHold(sessCtx, hold.ID, HoldExpired)
+			return err
+		})
+		// Captured or released since we looked
+		if errors.Is(err, ErrHoldNotActive) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to release expired credit hold %s: %v", hold.ID.Hex(), err)
+			continue
+		}
+		released++
+	}
+	return released, nil
+}
+
+// closeHold moves an active hold to status and returns its reservation to the
+// user's available balance. Captures then post the deduction themselves.
+func closeHold(sessCtx mongo.SessionContext, holdID primitive.ObjectID, status string) (*CreditHold, error) {
+	var hold CreditHold
+	err := config.GetCollection(HoldsCollection).FindOneAndUpdate(
+		sessCtx,
+		bson.M{"_id": holdID, "status": HoldActive},
+		bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now().Unix()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&hold)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, countErr := config.GetCollection(HoldsCollection).CountDocuments(sessCtx, bson.M{"_id": holdID})
+		if countErr != nil {
+			return nil, fmt.Errorf("failed to look up credit hold: %v", countErr)
+		}
+		if count == 0 {
+			return nil, ErrHoldNotFound
+		}
+		return nil, ErrHoldNotActive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update credit hold: %v", err)
+	}
+
+	_, err = config.GetCollection(UsersCollection).UpdateOne(sessCtx, bson.M{"_id": hold.UserID}, bson.M{"$inc": bson.M{"heldCredits": -hold.Amount}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to release held credits: %v", err)
+	}
+	return &hold, nil
+}
+
+// reserveCredits adds amount to the user's heldCredits if enough of their
+// balance is still unreserved
+func reserveCredits(sessCtx mongo.SessionContext, userID primitive.ObjectID, amount int) error {
+	users := config.GetCollection(UsersCollection)
+	result, err := users.UpdateOne(sessCtx,
+		bson.M{"_id": userID, "$expr": bson.M{"$gte": bson.A{availableCreditsExpr, amount}}},
+		bson.M{"$inc": bson.M{"heldCredits": amount}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reserve credits: %v", err)
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := users.CountDocuments(sessCtx, bson.M{"_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %v", err)
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+	return ErrInsufficientCredits
+}
+
+func holdByIdempotencyKey(ctx context.Context, idempotencyKey string) (*CreditHold, error) {
+	var hold CreditHold
+	err := config.GetCollection(HoldsCollection).FindOne(ctx, bson.M{"idempotencyKey": idempotencyKey}).Decode(&hold)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch credit hold: %v", err)
+	}
+	return &hold, nil
+}
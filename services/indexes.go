@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the services rely on. Creating an index
+// that already exists is a no-op, so this is safe to run on every start.
+func EnsureIndexes(ctx context.Context) error {
+	indexes := map[string][]mongo.IndexModel{
+		LedgerCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{
+				Keys: bson.D{{Key: "idempotencyKey", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+					"idempotencyKey": bson.M{"$exists": true},
+				}),
+			},
+		},
+		LotsCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresAt", Value: 1}}},
+		},
+		ReferralCodesCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		ReferralsCollection: {
+			{Keys: bson.D{{Key: "refereeId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "referrerId", Value: 1}, {Key: "status", Value: 1}}},
+		},
+		AdjustmentsCollection: {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "requestedBy", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		HoldsCollection: {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresAt", Value: 1}}},
+			{
+				Keys: bson.D{{Key: "idempotencyKey", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+					"idempotencyKey": bson.M{"$exists": true},
+				}),
+			},
+		},
+		events.OutboxCollection: {
+			{Keys: bson.D{{Key: "publishedAt", Value: 1}, {Key: "nextAttemptAt", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		WebhookDeliveriesCollection: {
+			{Keys: bson.D{{Key: "subscriptionId", Value: 1}, {Key: "eventId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		},
+		UsersCollection: {
+			{
+				Keys:    bson.D{{Key: "deletedAt", Value: 1}},
+				Options: options.Index().SetPartialFilterExpression(bson.M{"deletedAt": bson.M{"$exists": true}}),
+			},
+			{
+				Keys:    bson.D{{Key: "deletionScheduledFor", Value: 1}},
+				Options: options.Index().SetPartialFilterExpression(bson.M{"deletionScheduledFor": bson.M{"$exists": true}}),
+			},
+			// Backs GET /api/users/nearby
+			{Keys: bson.D{{Key: "place.point", Value: "2dsphere"}}},
+			// Backs GET /api/users/search; a collection can only have one text index
+			{
+				Keys: bson.D{
+					{Key: "name", Value: "text"},
+					{Key: "skills", Value: "text"},
+					{Key: "bio", Value: "text"},
+					{Key: "college", Value: "text"},
+					{Key: "program", Value: "text"},
+				},
+				Options: options.Index().SetName("user_search").SetWeights(bson.D{
+					{Key: "name", Value: 10},
+					{Key: "skills", Value: 5},
+					{Key: "college", Value: 2},
+					{Key: "program", Value: 2},
+					{Key: "bio", Value: 1},
+				}),
+			},
+		},
+		UsernamesCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "current", Value: 1}}},
+		},
+		SkillsCollection: {
+			// Merged skills give their keys to the skill they were merged into
+			{
+				Keys:    bson.D{{Key: "keys", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"mergedInto": bson.M{"$exists": false}}),
+			},
+		},
+		BlocksCollection: {
+			{Keys: bson.D{{Key: "blockerId", Value: 1}, {Key: "blockedId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "blockedId", Value: 1}}},
+		},
+		EndorsementsCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "key", Value: 1}, {Key: "endorserId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		SuspensionsCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		LoginHistoryCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		DataExportsCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		},
+		DeletionRequestsCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextRetryAt", Value: 1}}},
+		},
+		ReconciliationReportsCollection: {
+			{Keys: bson.D{{Key: "startedAt", Value: -1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := config.GetCollection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %v", collection, err)
+		}
+	}
+	return nil
+}
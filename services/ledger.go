@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	UsersCollection  = "MyClusterCol"
+	LedgerCollection = "creditLedger"
+)
+
+// Ledger entry types
+const (
+	EntryTypeAdjustment  = "adjustment"
+	EntryTypeBonus       = "bonus"
+	EntryTypeDeduction   = "deduction"
+	EntryTypeExpiry      = "expiry"
+	EntryTypeForfeiture  = "forfeiture" // Balance given up when a user deletes their account
+	EntryTypeReversal    = "reversal"
+	EntryTypeTransferOut = "transfer_out"
+	EntryTypeTransferIn  = "transfer_in"
+)
+
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInsufficientCredits = errors.New("insufficient credits")
+)
+
+// LedgerEntry is a single, immutable movement of credits on a user's balance
+type LedgerEntry struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"userId" json:"userId"`
+	Type         string             `bson:"type" json:"type"`
+	Amount       int                `bson:"amount" json:"amount"`             // Signed: positive credits, negative debits
+	BalanceAfter int                `bson:"balanceAfter" json:"balanceAfter"` // User balance once this entry was applied
+	ReasonCode   string             `bson:"reasonCode,omitempty" json:"reasonCode,omitempty"`
+	Note         string             `bson:"note,omitempty" json:"note,omitempty"`
+	ActorEmail   string             `bson:"actorEmail,omitempty" json:"actorEmail,omitempty"` // Who initiated the movement
+	ApprovedBy   string             `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"` // Second approver, if any
+	Reference    string             `bson:"reference,omitempty" json:"reference,omitempty"`   // e.g. adjustment or booking ID
+	Lots         []LotAllocation    `bson:"lots,omitempty" json:"lots,omitempty"`             // Lots credited or consumed by this entry
+	// ReversalOf links a reversal to the debit it refunds; RefundedAmount is the
+	// running total refunded against a debit and the only field ever updated on an entry
+	ReversalOf     primitive.ObjectID `bson:"reversalOf,omitempty" json:"reversalOf,omitempty"`
+	RefundedAmount int                `bson:"refundedAmount,omitempty" json:"refundedAmount,omitempty"`
+	// IdempotencyKey is unique across the ledger so one-time awards can never be posted twice
+	IdempotencyKey string `bson:"idempotencyKey,omitempty" json:"idempotencyKey,omitempty"`
+	CreatedAt      int64  `bson:"createdAt" json:"createdAt"`
+}
+
+// availableCreditsExpr is a user's balance less credits reserved by holds
+var availableCreditsExpr = bson.M{"$subtract": bson.A{"$credits", bson.M{"$ifNull": bson.A{"$heldCredits", 0}}}}
+
+// LotAllocation records how much of a credit lot a ledger entry touched
+type LotAllocation struct {
+	LotID  primitive.ObjectID `bson:"lotId" json:"lotId"`
+	Amount int                `bson:"amount" json:"amount"`
+}
+
+// postLedgerEntry applies entry.Amount to the user's balance and records the
+// entry. Debits never take a balance below zero, and deleted users' balances
+// never change. It must be called inside a transaction so the balance, the
+// entry and any lot changes commit together.
+func postLedgerEntry(sessCtx mongo.SessionContext, entry LedgerEntry) (*LedgerEntry, error) {
+	users := config.GetCollection(UsersCollection)
+
+	filter := NotDeleted(bson.M{"_id": entry.UserID})
+	if entry.Amount < 0 {
+		// Held credits are reserved for their capture and cannot be spent elsewhere
+		filter["$expr"] = bson.M{"$gte": bson.A{availableCreditsExpr, -entry.Amount}}
+	}
+
+	var user models.User
+	err := users.FindOneAndUpdate(
+		sessCtx,
+		filter,
+		bson.M{"$inc": bson.M{"credits": entry.Amount}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, countErr := users.CountDocuments(sessCtx, NotDeleted(bson.M{"_id": entry.UserID}))
+		if countErr != nil {
+			return nil, fmt.Errorf("failed to look up user: %v", countErr)
+		}
+		if count == 0 {
+			return nil, ErrUserNotFound
+		}
+		return nil, ErrInsufficientCredits
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update balance: %v", err)
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.BalanceAfter = user.Credits
+	if entry.CreatedAt == 0 {
+		entry.CreatedAt = time.Now().Unix()
+	}
+
+	if _, err := config.GetCollection(LedgerCollection).InsertOne(sessCtx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write ledger entry: %w", err)
+	}
+
+	err = events.Record(sessCtx, events.CreditsChanged, entry.UserID, events.CreditsChangedData{
+		EntryID:      entry.ID.Hex(),
+		EntryType:    entry.Type,
+		Amount:       entry.Amount,
+		BalanceAfter: entry.BalanceAfter,
+		Reference:    entry.Reference,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// entryByIdempotencyKey returns the ledger entry posted under an idempotency
+// key, or nil if there is none yet
+func entryByIdempotencyKey(ctx context.Context, idempotencyKey string) (*LedgerEntry, error) {
+	var entry LedgerEntry
+	err := config.GetCollection(LedgerCollection).FindOne(ctx, bson.M{"idempotencyKey": idempotencyKey}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entry: %v", err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LocationGridDegrees is the grid stored and shown coordinates are snapped
+// to, about 2 km, so nobody else ever learns a user's exact position
+const LocationGridDegrees = 0.02
+
+// Nearby search limits
+const (
+	DefaultNearbyRadiusKm = 10
+	MaxNearbyRadiusKm     = 100
+	DefaultNearbyLimit    = 20
+	MaxNearbyLimit        = 50
+)
+
+var (
+	ErrPlaceNotFound      = errors.New("place not found")
+	ErrInvalidCoordinates = errors.New("latitude must be within -90..90 and longitude within -180..180")
+	ErrInvalidRadius      = fmt.Errorf("radius must be more than 0 and at most %d km", MaxNearbyRadiusKm)
+)
+
+// Geocoder turns location text into a place, and coordinates into the place they are in
+type Geocoder interface {
+	Geocode(ctx context.Context, query string) (*Place, error)
+	ReverseGeocode(ctx context.Context, latitude, longitude float64) (*Place, error)
+}
+
+// DefaultGeocoder places users' locations. Both methods return
+// ErrPlaceNotFound for places they do not know. It may be replaced at
+// startup with a geocoder backed by an online service.
+var DefaultGeocoder Geocoder = GazetteerGeocoder{}
+
+// GeoPoint is a GeoJSON point
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"` // Longitude, then latitude
+}
+
+// Place is the structured form of a user's location, stored as "place". Its
+// point is snapped to LocationGridDegrees and backs nearby searches.
+type Place struct {
+	City    string    `bson:"city,omitempty" json:"city,omitempty"`
+	Region  string    `bson:"region,omitempty" json:"region,omitempty"`
+	Country string    `bson:"country,omitempty" json:"country,omitempty"`
+	Point   *GeoPoint `bson:"point,omitempty" json:"-"`
+}
+
+// NearbySearch asks for users within RadiusKm of a point
+type NearbySearch struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+	Limit     int
+}
+
+// NearbyUser is a user near the searched point. The distance is measured to
+// their snapped position and rounded up to a whole kilometre.
+type NearbyUser struct {
+	User       PublicProfile `json:"user"`
+	DistanceKm float64       `json:"distanceKm"`
+}
+
+// NearbyResult is the users nearest a point, nearest first
+type NearbyResult struct {
+	Users []NearbyUser `json:"data"`
+	Count int          `json:"count"`
+}
+
+// ResolveLocation works out the place for a profile's location text and
+// coordinates. The text names the place when the geocoder knows it;
+// coordinates, when given, set the point. It returns nil if neither is known.
+func ResolveLocation(ctx context.Context, location string, latitude, longitude float64) (*Place, error) {
+	var place *Place
+	if strings.TrimSpace(location) != "" {
+		found, err := DefaultGeocoder.Geocode(ctx, location)
+		if err != nil && !errors.Is(err, ErrPlaceNotFound) {
+			return nil, fmt.Errorf("failed to geocode location: %v", err)
+		}
+		place = found
+	}
+
+	if latitude != 0 || longitude != 0 {
+		if !validCoordinates(latitude, longitude) {
+			return nil, ErrInvalidCoordinates
+		}
+		if place == nil {
+			found, err := DefaultGeocoder.ReverseGeocode(ctx, latitude, longitude)
+			if err != nil && !errors.Is(err, ErrPlaceNotFound) {
+				return nil, fmt.Errorf("failed to geocode coordinates: %v", err)
+			}
+			place = found
+		}
+		if place == nil {
+			place = &Place{}
+		}
+		place.Point = newGeoPoint(latitude, longitude)
+	}
+
+	if place == nil {
+		return nil, nil
+	}
+	if place.Point != nil {
+		place.Point = newGeoPoint(coarsenCoordinate(place.Point.Coordinates[1]), coarsenCoordinate(place.Point.Coordinates[0]))
+	}
+	return place, nil
+}
+
+// NearbyUsers finds visible users whose location the viewer may see within a
+// radius of a point. Users the viewer blocked or was blocked by, and suspended
+// users, are left out.
+func NearbyUsers(ctx context.Context, search NearbySearch, viewer Viewer) (*NearbyResult, error) {
+	if !validCoordinates(search.Latitude, search.Longitude) {
+		return nil, ErrInvalidCoordinates
+	}
+	if search.RadiusKm == 0 {
+		search.RadiusKm = DefaultNearbyRadiusKm
+	}
+	if !(search.RadiusKm > 0 && search.RadiusKm <= MaxNearbyRadiusKm) {
+		return nil, ErrInvalidRadius
+	}
+	if search.Limit <= 0 {
+		search.Limit = DefaultNearbyLimit
+	}
+	if search.Limit > MaxNearbyLimit {
+		search.Limit = MaxNearbyLimit
+	}
+
+	excluded, err := excludedUserIDs(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	filter := Visible(bson.M{"_id": bson.M{"$nin": excluded}})
+	filter["$and"] = []bson.M{visibleFieldFilter("location", viewer)}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          newGeoPoint(search.Latitude, search.Longitude),
+			"key":           "place.point",
+			"distanceField": "distance",
+			"maxDistance":   search.RadiusKm * 1000,
+			"spherical":     true,
+			"query":         filter,
+		}}},
+		{{Key: "$limit", Value: search.Limit}},
+		{{Key: "$project", Value: bson.M{"password": 0}}},
+	}
+	cursor, err := config.GetCollection(UsersCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find nearby users: %v", err)
+	}
+	var matches []struct {
+		UserProfile `bson:",inline"`
+		Distance    float64 `bson:"distance"` // Metres
+	}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %v", err)
+	}
+
+	result := &NearbyResult{Users: make([]NearbyUser, 0, len(matches))}
+	for _, match := range matches {
+		result.Users = append(result.Users, NearbyUser{
+			User:       NewPublicProfile(match.UserProfile, viewer),
+			DistanceKm: math.Max(1, math.Ceil(match.Distance/1000)),
+		})
+	}
+	result.Count = len(result.Users)
+	return result, nil
+}
+
+// StartLocationBackfill gives users who set a location before places were
+// stored their place, once, in the background. Users whose location cannot
+// be placed get a null place so they are not tried again. Coordinates stored
+// before they were snapped are snapped first.
+func StartLocationBackfill() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		if err := snapStoredCoordinates(ctx); err != nil {
+			log.Printf("❌ Snapping stored coordinates failed: %v", err)
+		}
+
+		users := config.GetCollection(UsersCollection)
+		cursor, err := users.Find(ctx, NotDeleted(bson.M{
+			"place": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"location": bson.M{"$nin": bson.A{nil, ""}}},
+				bson.M{"latitude": bson.M{"$nin": bson.A{nil, 0}}},
+				bson.M{"longitude": bson.M{"$nin": bson.A{nil, 0}}},
+			},
+		}), options.Find().SetProjection(bson.M{"location": 1, "latitude": 1, "longitude": 1}))
+		if err != nil {
+			log.Printf("❌ Location backfill failed: %v", err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		placed := 0
+		for cursor.Next(ctx) {
+			var user UserProfile
+			if err := cursor.Decode(&user); err != nil {
+				log.Printf("❌ Location backfill failed: %v", err)
+				return
+			}
+			place, err := ResolveLocation(ctx, user.Location, user.Latitude, user.Longitude)
+			if err != nil && !errors.Is(err, ErrInvalidCoordinates) {
+				log.Printf("❌ Location backfill failed for %s: %v", user.ID.Hex(), err)
+				continue
+			}
+			_, err = users.UpdateOne(ctx, bson.M{"_id": user.ID, "place": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"place": place}})
+			if err != nil {
+				log.Printf("❌ Location backfill failed for %s: %v", user.ID.Hex(), err)
+				continue
+			}
+			if place != nil {
+				placed++
+			}
+		}
+		if placed > 0 {
+			log.Printf("📍 Placed the locations of %d users", placed)
+		}
+	}()
+}
+
+// snapStoredCoordinates snaps users' stored coordinates that are not yet on
+// the LocationGridDegrees grid
+func snapStoredCoordinates(ctx context.Context) error {
+	users := config.GetCollection(UsersCollection)
+	cursor, err := users.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"latitude": bson.M{"$nin": bson.A{nil, 0}}},
+		bson.M{"longitude": bson.M{"$nin": bson.A{nil, 0}}},
+	}}, options.Find().SetProjection(bson.M{"latitude": 1, "longitude": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find users with coordinates: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	snapped := 0
+	for cursor.Next(ctx) {
+		var user UserProfile
+		if err := cursor.Decode(&user); err != nil {
+			return fmt.Errorf("failed to decode user: %v", err)
+		}
+		// Only if the user has not changed them meanwhile
+		filter, set := bson.M{"_id": user.ID}, bson.M{}
+		for name, value := range map[string]float64{"latitude": user.Latitude, "longitude": user.Longitude} {
+			filter[name] = value
+			if value == 0 {
+				filter[name] = bson.M{"$in": bson.A{nil, 0}}
+			} else if coarsenCoordinate(value) != value {
+				set[name] = coarsenCoordinate(value)
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		_, err := users.UpdateOne(ctx, filter, bson.M{"$set": set})
+		if err != nil {
+			return fmt.Errorf("failed to snap coordinates for %s: %v", user.ID.Hex(), err)
+		}
+		snapped++
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read users: %v", err)
+	}
+	if snapped > 0 {
+		log.Printf("📍 Snapped the stored coordinates of %d users", snapped)
+	}
+	return nil
+}
+
+// setPlace adds setting or clearing "place" to a user update
+func setPlace(update bson.M, place *Place) {
+	operator, value := "$set", interface{}(place)
+	if place == nil {
+		operator, value = "$unset", ""
+	}
+	fields, ok := update[operator].(bson.M)
+	if !ok {
+		fields = bson.M{}
+		update[operator] = fields
+	}
+	fields["place"] = value
+}
+
+func newGeoPoint(latitude, longitude float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{longitude, latitude}}
+}
+
+// coarsenCoordinate snaps a latitude or longitude to LocationGridDegrees
+func coarsenCoordinate(degrees float64) float64 {
+	snapped := math.Round(degrees/LocationGridDegrees) * LocationGridDegrees
+	// Drop the float noise snapping leaves, e.g. 42.36000000000001
+	return math.Round(snapped*1e6) / 1e6
+}
+
+func validCoordinates(latitude, longitude float64) bool {
+	return latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCoarsenCoordinate(t *testing.T) {
+	tests := []struct {
+		degrees float64
+		want    float64
+	}{
+		{0, 0},
+		{42.3601, 42.36},
+		{-71.0589, -71.06},
+		{42.369, 42.36},
+		{42.371, 42.38},
+		{89.999, 90},
+		{-179.995, -180},
+	}
+	for _, tt := range tests {
+		got := coarsenCoordinate(tt.degrees)
+		if got != tt.want {
+			t.Errorf("coarsenCoordinate(%v) = %v, want %v", tt.degrees, got, tt.want)
+		}
+		if coarsenCoordinate(got) != got {
+			t.Errorf("coarsenCoordinate(%v) is not stable", got)
+		}
+		if math.Abs(got-tt.degrees) > LocationGridDegrees/2+1e-9 {
+			t.Errorf("coarsenCoordinate(%v) moved more than half a grid cell", tt.degrees)
+		}
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	boston := &Place{City: "Boston", Region: "Massachusetts", Country: "United States"}
+
+	tests := []struct {
+		name      string
+		location  string
+		latitude  float64
+		longitude float64
+		want      *Place
+		wantPoint []float64 // Longitude, then latitude
+		wantErr   error
+	}{
+		{name: "nothing given"},
+		{name: "unknown place", location: "Atlantis"},
+		{name: "known place", location: "boston, ma", want: boston, wantPoint: []float64{-71.06, 42.36}},
+		{name: "place qualified wrongly", location: "Boston, UK"},
+		{name: "coordinates near a city", latitude: 42.351, longitude: -71.071, want: boston, wantPoint: []float64{-71.08, 42.36}},
+		{name: "coordinates far from any city", latitude: -60, longitude: -30, want: &Place{}, wantPoint: []float64{-30, -60}},
+		{name: "text names the place, coordinates set the point", location: "Boston", latitude: 42.3333, longitude: -71.1111, want: boston, wantPoint: []float64{-71.12, 42.34}},
+		{name: "invalid coordinates", latitude: 95, longitude: 10, wantErr: ErrInvalidCoordinates},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveLocation(context.Background(), tt.location, tt.latitude, tt.longitude)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("got %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Point == nil {
+				t.Fatalf("got %+v, want a place with a point", got)
+			}
+			if !reflect.DeepEqual(got.Point.Coordinates, tt.wantPoint) {
+				t.Errorf("got point %v, want %v", got.Point.Coordinates, tt.wantPoint)
+			}
+			got.Point = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
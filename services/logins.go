@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const LoginHistoryCollection = "loginHistory"
+
+// Login methods
+const (
+	LoginPassword   = "password"
+	LoginOAuth      = "oauth"
+	LoginSignup     = "signup"
+	LoginReactivate = "reactivate"
+)
+
+// LoginContext describes the client a login came from
+type LoginContext struct {
+	IP        string
+	DeviceID  string
+	UserAgent string
+}
+
+// LoginRecord is one successful login. Every login issues a token, so the
+// records whose token has not expired yet are the user's open sessions.
+type LoginRecord struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	UserID         primitive.ObjectID `bson:"userId" json:"userId"`
+	Method         string             `bson:"method" json:"method"`
+	Provider       string             `bson:"provider,omitempty" json:"provider,omitempty"` // OAuth provider
+	IP             string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	DeviceID       string             `bson:"deviceId,omitempty" json:"deviceId,omitempty"`
+	UserAgent      string             `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	CreatedAt      int64              `bson:"createdAt" json:"createdAt"`
+	TokenExpiresAt int64              `bson:"tokenExpiresAt" json:"tokenExpiresAt"`
+}
+
+// RecordLogin adds a login to the user's history and updates their lastLoginAt
+func RecordLogin(ctx context.Context, userID primitive.ObjectID, method, provider string, client LoginContext, tokenExpiresAt time.Time) error {
+	now := time.Now().Unix()
+	return config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		_, err := config.GetCollection(LoginHistoryCollection).InsertOne(sessCtx, LoginRecord{
+			ID:             primitive.NewObjectID(),
+			UserID:         userID,
+			Method:         method,
+			Provider:       provider,
+			IP:             client.IP,
+			DeviceID:       client.DeviceID,
+			UserAgent:      client.UserAgent,
+			CreatedAt:      now,
+			TokenExpiresAt: tokenExpiresAt.Unix(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record login: %v", err)
+		}
+		_, err = config.GetCollection(UsersCollection).UpdateOne(sessCtx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"lastLoginAt": now}})
+		if err != nil {
+			return fmt.Errorf("failed to update last login: %v", err)
+		}
+		return nil
+	})
+}
+
+// ListLogins returns a user's logins, newest first
+func ListLogins(ctx context.Context, userID primitive.ObjectID) ([]LoginRecord, error) {
+	cursor, err := config.GetCollection(LoginHistoryCollection).Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch login history: %v", err)
+	}
+	logins := []LoginRecord{}
+	if err := cursor.All(ctx, &logins); err != nil {
+		return nil, fmt.Errorf("failed to decode login history: %v", err)
+	}
+	return logins, nil
+}
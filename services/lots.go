@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const LotsCollection = "creditLots"
+
+// Lot sources. Bonus lots use the key of the bonus rule that granted them.
+const (
+	LotSourceEarned     = "earned"
+	LotSourceAdjustment = "adjustment"
+	LotSourceRefund     = "refund"
+	LotSourceTransfer   = "transfer"
+)
+
+// Lot statuses
+const (
+	LotActive   = "active"
+	LotConsumed = "consumed"
+	LotExpired  = "expired"
+)
+
+// CreditLot is a batch of credits granted together, tracked so they can be
+// consumed earliest-expiring first and expired when their time is up
+type CreditLot struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId" json:"userId"`
+	Source        string             `bson:"source" json:"source"`
+	Amount        int                `bson:"amount" json:"amount"`
+	Remaining     int                `bson:"remaining" json:"remaining"`
+	Status        string             `bson:"status" json:"status"`
+	ExpiresAt     int64              `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"` // Unix timestamp, 0 = never
+	LedgerEntryID primitive.ObjectID `bson:"ledgerEntryId" json:"ledgerEntryId"`
+	CreatedAt     int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// DeductCredits removes credits from a user's balance, consuming the
+// earliest-expiring lots first. A non-empty idempotencyKey makes retries safe:
+// repeating a deduction with the same key returns the entry already posted.
+func DeductCredits(ctx context.Context, userID primitive.ObjectID, amount int, reason, reference, idempotencyKey string) (*LedgerEntry, error) {
+	if idempotencyKey != "" {
+		idempotencyKey = "deduction:" + userID.Hex() + ":" + idempotencyKey
+		if existing, err := entryByIdempotencyKey(ctx, idempotencyKey); err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	var entry *LedgerEntry
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if err := ensureNotSuspended(sessCtx, userID); err != nil {
+			return err
+		}
+		var err error
+		entry, err = applyDebit(sessCtx, LedgerEntry{
+			UserID:         userID,
+			Type:           EntryTypeDeduction,
+			Amount:         -amount,
+			Note:           reason,
+			Reference:      reference,
+			IdempotencyKey: idempotencyKey,
+		})
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) && idempotencyKey != "" {
+		return entryByIdempotencyKey(ctx, idempotencyKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ListLots returns a user's credit lots, newest first
+func ListLots(ctx context.Context, userID primitive.ObjectID) ([]CreditLot, error) {
+	cursor, err := config.GetCollection(LotsCollection).Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch credit lots: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	lots := []CreditLot{}
+	if err := cursor.All(ctx, &lots); err != nil {
+		return nil, fmt.Errorf("failed to decode credit lots: %v", err)
+	}
+	return lots, nil
+}
+
+// applyEntry routes a ledger entry to applyCredit or applyDebit based on its sign.
+// Credits open a lot with the given source that never expires.
+func applyEntry(sessCtx mongo.SessionContext, entry LedgerEntry, source string) (*LedgerEntry, error) {
+	if entry.Amount < 0 {
+		return applyDebit(sessCtx, entry)
+	}
+	posted, _, err := applyCredit(sessCtx, entry, source, 0)
+	return posted, err
+}
+
+// applyCredit posts a positive ledger entry and opens a lot for it
+func applyCredit(sessCtx mongo.SessionContext, entry LedgerEntry, source string, expiresAt int64) (*LedgerEntry, *CreditLot, error) {
+	lot := CreditLot{
+		ID:        primitive.NewObjectID(),
+		UserID:    entry.UserID,
+		Source:    source,
+		Amount:    entry.Amount,
+		Remaining: entry.Amount,
+		Status:    LotActive,
+		ExpiresAt: expiresAt,
+	}
+	entry.Lots = []LotAllocation{{LotID: lot.ID, Amount: entry.Amount}}
+
+	posted, err := postLedgerEntry(sessCtx, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lot.LedgerEntryID = posted.ID
+	lot.CreatedAt = posted.CreatedAt
+	if _, err := config.GetCollection(LotsCollection).InsertOne(sessCtx, lot); err != nil {
+		return nil, nil, fmt.Errorf("failed to create credit lot: %v", err)
+	}
+	return posted, &lot, nil
+}
+
+// applyDebit posts a negative ledger entry and consumes the user's active lots,
+// earliest-expiring first. Balances that predate lots are spent last.
+func applyDebit(sessCtx mongo.SessionContext, entry LedgerEntry) (*LedgerEntry, error) {
+	collection := config.GetCollection(LotsCollection)
+
+	cursor, err := collection.Find(sessCtx, bson.M{"userId": entry.UserID, "status": LotActive, "remaining": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch credit lots: %v", err)
+	}
+	var lots []CreditLot
+	if err := cursor.All(sessCtx, &lots); err != nil {
+		return nil, fmt.Errorf("failed to decode credit lots: %v", err)
+	}
+	sortLotsForConsumption(lots)
+
+	needed := -entry.Amount
+	entry.Lots = nil
+	for _, lot := range lots {
+		if needed == 0 {
+			break
+		}
+		take := lot.Remaining
+		if take > needed {
+			take = needed
+		}
+		needed -= take
+
+		update := bson.M{"$inc": bson.M{"remaining": -take}}
+		if take == lot.Remaining {
+			update["$set"] = bson.M{"status": LotConsumed}
+		}
+		if _, err := collection.UpdateOne(sessCtx, bson.M{"_id": lot.ID}, update); err != nil {
+			return nil, fmt.Errorf("failed to consume credit lot: %v", err)
+		}
+		entry.Lots = append(entry.Lots, LotAllocation{LotID: lot.ID, Amount: -take})
+	}
+
+	return postLedgerEntry(sessCtx, entry)
+}
+
+// sortLotsForConsumption orders lots earliest-expiring first, with lots that
+// never expire last, oldest first
+func sortLotsForConsumption(lots []CreditLot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if (a.ExpiresAt == 0) != (b.ExpiresAt == 0) {
+			return a.ExpiresAt != 0
+		}
+		if a.ExpiresAt != b.ExpiresAt {
+			return a.ExpiresAt < b.ExpiresAt
+		}
+		return a.CreatedAt < b.CreatedAt
+	})
+}
+
+// ExpireCreditLots expires every active lot past its expiry date, removing the
+// unspent credits from the owner's balance with an expiry ledger entry.
+// It returns the number of lots expired.
+func ExpireCreditLots(ctx context.Context) (int, error) {
+	collection := config.GetCollection(LotsCollection)
+	now := time.Now().Unix()
+
+	cursor, err := collection.Find(ctx, bson.M{
+		"status":    LotActive,
+		"expiresAt": bson.M{"$gt": 0, "$lte": now},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch expired lots: %v", err)
+	}
+	var lots []CreditLot
+	if err := cursor.All(ctx, &lots); err != nil {
+		return 0, fmt.Errorf("failed to decode expired lots: %v", err)
+	}
+
+	expired := 0
+	for _, lot := range lots {
+		err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// Re-read inside the transaction in case the lot was spent meanwhile
+			var current CreditLot
+			err := collection.FindOneAndUpdate(
+				sessCtx,
+				bson.M{"_id": lot.ID, "status": LotActive},
+				bson.M{"$set": bson.M{"status": LotExpired, "remaining": 0}},
+			).Decode(&current)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if current.Remaining <= 0 {
+				return nil
+			}
+
+			// Never take a balance below zero or below what holds have reserved,
+			// even if it has drifted from its lots
+			var user struct {
+				Credits     int `bson:"credits"`
+				HeldCredits int `bson:"heldCredits"`
+			}
+			err = config.GetCollection(UsersCollection).FindOne(sessCtx, NotDeleted(bson.M{"_id": lot.UserID})).Decode(&user)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ErrUserNotFound
+			}
+			if err != nil {
+				return err
+			}
+			amount := current.Remaining
+			if amount > user.Credits-user.HeldCredits {
+				amount = user.Credits - user.HeldCredits
+			}
+			if amount <= 0 {
+				return nil
+			}
+
+			_, err = postLedgerEntry(sessCtx, LedgerEntry{
+				UserID:     lot.UserID,
+				Type:       EntryTypeExpiry,
+				Amount:     -amount,
+				ReasonCode: lot.Source,
+				Reference:  lot.ID.Hex(),
+				Lots:       []LotAllocation{{LotID: lot.ID, Amount: -amount}},
+			})
+			return err
+		})
+		if errors.Is(err, ErrUserNotFound) {
+			// Deleted users' lots wait until they are restored or purged
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to expire credit lot %s: %v", lot.ID.Hex(), err)
+			continue
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// StartCreditExpiryJob sweeps expired credit lots and holds at once, so
+// nothing stays spendable past its expiry after a restart, and then on the
+// given interval until the process exits
+func StartCreditExpiryJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			sweepExpiredCredits()
+			<-ticker.C
+		}
+	}()
+}
+
+func sweepExpiredCredits() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	count, err := ExpireCreditLots(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("❌ Credit expiry job failed: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("✅ Expired %d credit lots", count)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	released, err := ReleaseExpiredHolds(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("❌ Credit hold expiry failed: %v", err)
+		return
+	}
+	if released > 0 {
+		log.Printf("✅ Released %d expired credit holds", released)
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Match limits
+const (
+	MaxMatchSkills     = 10
+	DefaultMatchLimit  = 20
+	MaxMatchLimit      = 50
+	matchCandidateSize = 500 // Users with the most wanted skills, most recently active first, that are scored
+)
+
+// Points each part of a match score is worth; a perfect match scores 100
+const (
+	MatchSkillPoints       = 60.0
+	MatchEndorsementPoints = 15.0
+	MatchCollegePoints     = 15.0
+	MatchActivityPoints    = 10.0
+)
+
+const (
+	// MatchEndorsementCap is how many endorsements of the wanted skills earn full endorsement points
+	MatchEndorsementCap = 10
+	// MatchActivityWindow is how long after their last login a user still earns activity points
+	MatchActivityWindow = 90 * 24 * time.Hour
+)
+
+// proficiencyWeights is the share of a skill's points a user earns at each
+// proficiency level. Users who have not given a level count as in between.
+var proficiencyWeights = map[string]float64{
+	ProficiencyBeginner:     0.5,
+	ProficiencyIntermediate: 0.7,
+	ProficiencyAdvanced:     0.85,
+	ProficiencyExpert:       1,
+}
+
+const unratedProficiencyWeight = 0.6
+
+var ErrNoMatchSkills = fmt.Errorf("give 1 to %d skills to match", MaxMatchSkills)
+
+// UserMatchRequest asks for users who can help with a set of skills
+type UserMatchRequest struct {
+	Skills  []string
+	College string // Defaults to the viewer's own college
+	Limit   int
+}
+
+// MatchedSkill is a wanted skill a matched user has
+type MatchedSkill struct {
+	Skill        string `json:"skill"`
+	Level        string `json:"level,omitempty"`
+	Endorsements int    `json:"endorsements"`
+}
+
+// MatchScore breaks a match score down into the points each part earned
+type MatchScore struct {
+	Skills       float64 `json:"skills"`
+	Endorsements float64 `json:"endorsements"`
+	College      float64 `json:"college"`
+	Activity     float64 `json:"activity"`
+}
+
+// UserMatch is a user who can help, with why they rank where they do
+type UserMatch struct {
+	User          PublicProfile  `json:"user"`
+	Score         float64        `json:"score"`
+	Breakdown     MatchScore     `json:"breakdown"`
+	MatchedSkills []MatchedSkill `json:"matchedSkills"`
+	Reasons       []string       `json:"reasons"`
+}
+
+// UserMatchResult is the best matches for a request, best first
+type UserMatchResult struct {
+	Skills  []string    `json:"skills"` // The wanted skills, by their catalog names
+	Matches []UserMatch `json:"data"`
+	Count   int         `json:"count"`
+}
+
+// wantedSkill is a requested skill with every key it may be listed under
+type wantedSkill struct {
+	name string
+	keys []string
+}
+
+// MatchUsers ranks the users who have any of the wanted skills by how well
+// they can help the viewer. Users the viewer blocked or was blocked by, and
+// suspended users, are left out.
+func MatchUsers(ctx context.Context, request UserMatchRequest, viewer Viewer) (*UserMatchResult, error) {
+	wanted, err := resolveWantedSkills(ctx, request.Skills)
+	if err != nil {
+		return nil, err
+	}
+	if request.Limit <= 0 {
+		request.Limit = DefaultMatchLimit
+	}
+	if request.Limit > MaxMatchLimit {
+		request.Limit = MaxMatchLimit
+	}
+
+	college := strings.TrimSpace(request.College)
+	if college == "" && viewer.LoggedIn {
+		if user, err := GetUser(ctx, viewer.UserID); err == nil {
+			college = user.College
+		} else if !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+	}
+
+	excluded, err := excludedUserIDs(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Candidates are capped after ranking by how many wanted skills they
+	// have, so users with more of them are never cut for being less active
+	var keys []string
+	patterns := bson.A{}
+	coverage := bson.A{}
+	for _, skill := range wanted {
+		var matches bson.A
+		for _, key := range skill.keys {
+			keys = append(keys, key)
+			patterns = append(patterns, primitive.Regex{Pattern: skillKeyPattern(key), Options: "i"})
+			matches = append(matches, bson.M{"$regexMatch": bson.M{"input": "$$skill", "regex": skillKeyPattern(key), "options": "i"}})
+		}
+		coverage = append(coverage, bson.M{"$cond": bson.A{
+			bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$skills", bson.A{}}},
+				"as":    "skill",
+				"in":    bson.M{"$or": matches},
+			}}}},
+			1, 0,
+		}})
+	}
+	cursor, err := config.GetCollection(UsersCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: Visible(bson.M{"skills": bson.M{"$in": patterns}, "_id": bson.M{"$nin": excluded}})}},
+		{{Key: "$addFields", Value: bson.M{"coverage": bson.M{"$add": coverage}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "coverage", Value: -1}, {Key: "lastLoginAt", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: matchCandidateSize}},
+		{{Key: "$project", Value: bson.M{"password": 0, "coverage": 0}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find matching users: %v", err)
+	}
+	var candidates []UserProfile
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %v", err)
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(candidates))
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.ID)
+	}
+	endorsements, err := endorsementCounts(ctx, ids, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	matches := make([]UserMatch, 0, len(candidates))
+	for _, candidate := range candidates {
+		match := scoreMatch(candidate, viewer, wanted, endorsements[candidate.ID], college, now)
+		if len(match.MatchedSkills) > 0 {
+			matches = append(matches, match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > request.Limit {
+		matches = matches[:request.Limit]
+	}
+
+	result := &UserMatchResult{Skills: make([]string, 0, len(wanted)), Matches: matches, Count: len(matches)}
+	for _, skill := range wanted {
+		result.Skills = append(result.Skills, skill.name)
+	}
+	return result, nil
+}
+
+// excludedUserIDs returns the users never shown to the viewer in matches and
+// nearby searches: suspended users, users they blocked or were blocked by,
+// and the viewer themselves
+func excludedUserIDs(ctx context.Context, viewer Viewer) ([]primitive.ObjectID, error) {
+	excluded, err := suspendedUserIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if viewer.LoggedIn {
+		blocked, err := blockedUserIDs(ctx, viewer.UserID)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(append(excluded, blocked...), viewer.UserID)
+	}
+	return excluded, nil
+}
+
+// scoreMatch scores how well a candidate can help with the wanted skills
+func scoreMatch(candidate UserProfile, viewer Viewer, wanted []wantedSkill, endorsements map[string]int, college string, now time.Time) UserMatch {
+	listed := map[string]bool{}
+	for _, skill := range candidate.Skills {
+		listed[SkillKey(skill)] = true
+	}
+	levels := skillLevelsByKey(candidate.SkillLevels)
+
+	match := UserMatch{User: NewPublicProfile(candidate, viewer), MatchedSkills: []MatchedSkill{}, Reasons: []string{}}
+	var proficiency float64
+	var endorsed int
+	for _, skill := range wanted {
+		matched := MatchedSkill{Skill: skill.name}
+		var has bool
+		for _, key := range skill.keys {
+			has = has || listed[key]
+			if matched.Level == "" {
+				matched.Level = levels[key]
+			}
+			matched.Endorsements += endorsements[key]
+		}
+		if !has {
+			continue
+		}
+		weight, ok := proficiencyWeights[matched.Level]
+		if !ok {
+			weight = unratedProficiencyWeight
+		}
+		proficiency += weight
+		endorsed += matched.Endorsements
+		match.MatchedSkills = append(match.MatchedSkills, matched)
+	}
+	if len(match.MatchedSkills) == 0 {
+		return match
+	}
+
+	match.Breakdown.Skills = roundScore(MatchSkillPoints * proficiency / float64(len(wanted)))
+	match.Reasons = append(match.Reasons, fmt.Sprintf("Has %d of %d requested skills: %s",
+		len(match.MatchedSkills), len(wanted), describeMatchedSkills(match.MatchedSkills)))
+
+	if endorsed > 0 {
+		match.Breakdown.Endorsements = roundScore(MatchEndorsementPoints * math.Min(float64(endorsed), MatchEndorsementCap) / MatchEndorsementCap)
+		match.Reasons = append(match.Reasons, fmt.Sprintf("%d endorsements for these skills", endorsed))
+	}
+
+	// Only a college the viewer may see counts, so scores do not reveal hidden ones
+	if college != "" && match.User.College != "" && strings.EqualFold(strings.TrimSpace(match.User.College), college) {
+		match.Breakdown.College = MatchCollegePoints
+		match.Reasons = append(match.Reasons, "Also at "+match.User.College)
+	}
+
+	if candidate.LastLoginAt > 0 {
+		idle := now.Sub(time.Unix(candidate.LastLoginAt, 0))
+		if idle < MatchActivityWindow {
+			match.Breakdown.Activity = roundScore(MatchActivityPoints * (1 - math.Max(idle.Hours(), 0)/MatchActivityWindow.Hours()))
+			match.Reasons = append(match.Reasons, "Active "+describeIdle(idle))
+		}
+	}
+
+	match.Score = roundScore(match.Breakdown.Skills + match.Breakdown.Endorsements + match.Breakdown.College + match.Breakdown.Activity)
+	return match
+}
+
+// resolveWantedSkills maps requested skills to their catalog names and keys, dropping duplicates
+func resolveWantedSkills(ctx context.Context, skills []string) ([]wantedSkill, error) {
+	var keys []string
+	for _, skill := range skills {
+		if key := SkillKey(truncate(skill, MaxSkillLength)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	keys = uniqueStrings(keys)
+	if len(keys) == 0 || len(keys) > MaxMatchSkills {
+		return nil, ErrNoMatchSkills
+	}
+	known, err := lookupSkills(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var wanted []wantedSkill
+	seen := map[string]bool{}
+	for _, skill := range skills {
+		key := SkillKey(truncate(skill, MaxSkillLength))
+		if key == "" || seen[key] {
+			continue
+		}
+		entry := wantedSkill{name: strings.TrimSpace(skill), keys: []string{key}}
+		if catalogSkill, ok := known[key]; ok {
+			entry = wantedSkill{name: catalogSkill.Name, keys: catalogSkill.Keys}
+		}
+		for _, k := range entry.keys {
+			seen[k] = true
+		}
+		wanted = append(wanted, entry)
+	}
+	return wanted, nil
+}
+
+func describeMatchedSkills(skills []MatchedSkill) string {
+	parts := make([]string, 0, len(skills))
+	for _, skill := range skills {
+		if skill.Level != "" {
+			parts = append(parts, fmt.Sprintf("%s (%s)", skill.Skill, skill.Level))
+		} else {
+			parts = append(parts, skill.Skill)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeIdle(idle time.Duration) string {
+	switch days := int(idle.Hours() / 24); {
+	case days < 1:
+		return "today"
+	case days == 1:
+		return "yesterday"
+	default:
+		return fmt.Sprintf("%d days ago", days)
+	}
+}
+
+// roundScore rounds to one decimal place
+func roundScore(score float64) float64 {
+	return math.Round(score*10) / 10
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestScoreMatch(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	wanted := []wantedSkill{
+		{name: "Python", keys: []string{"python"}},
+		{name: "Machine Learning", keys: []string{"machinelearning", "ml"}},
+		{name: "Go", keys: []string{"go", "golang"}},
+	}
+	candidate := func(change func(*UserProfile)) UserProfile {
+		profile := UserProfile{
+			User: models.User{
+				ID:      primitive.NewObjectID(),
+				Name:    "Ada",
+				College: "MIT",
+				Skills:  []string{"Python", "ML", "Cooking"},
+			},
+			SkillLevels: []SkillLevel{{Skill: "Python", Level: ProficiencyExpert}},
+			LastLoginAt: now.Add(-48 * time.Hour).Unix(),
+		}
+		if change != nil {
+			change(&profile)
+		}
+		return profile
+	}
+	loggedIn := Viewer{UserID: primitive.NewObjectID(), LoggedIn: true}
+
+	tests := []struct {
+		name         string
+		candidate    UserProfile
+		viewer       Viewer
+		endorsements map[string]int
+		college      string
+		want         MatchScore
+		wantSkills   []string
+	}{
+		{
+			name:         "expert and unrated skills, endorsed, same college, recently active",
+			candidate:    candidate(nil),
+			viewer:       loggedIn,
+			endorsements: map[string]int{"python": 2, "ml": 1},
+			college:      "mit",
+			want:         MatchScore{Skills: 32, Endorsements: 4.5, College: 15, Activity: 9.8},
+			wantSkills:   []string{"Python", "Machine Learning"},
+		},
+		{
+			name: "skill listed under another spelling",
+			candidate: candidate(func(p *UserProfile) {
+				p.Skills = []string{"go-lang"}
+				p.SkillLevels = []SkillLevel{{Skill: "Golang", Level: ProficiencyBeginner}}
+			}),
+			viewer:     loggedIn,
+			want:       MatchScore{Skills: 10, Activity: 9.8},
+			wantSkills: []string{"Go"},
+		},
+		{
+			name:         "endorsements capped",
+			candidate:    candidate(nil),
+			viewer:       loggedIn,
+			endorsements: map[string]int{"python": 40},
+			want:         MatchScore{Skills: 32, Endorsements: MatchEndorsementPoints, Activity: 9.8},
+			wantSkills:   []string{"Python", "Machine Learning"},
+		},
+		{
+			name:       "hidden college earns nothing",
+			candidate:  candidate(func(p *UserProfile) { p.Privacy = PrivacySettings{"college": VisibleToNobody} }),
+			viewer:     loggedIn,
+			college:    "MIT",
+			want:       MatchScore{Skills: 32, Activity: 9.8},
+			wantSkills: []string{"Python", "Machine Learning"},
+		},
+		{
+			name:       "inactive for longer than the window",
+			candidate:  candidate(func(p *UserProfile) { p.LastLoginAt = now.Add(-MatchActivityWindow).Unix() }),
+			viewer:     Viewer{},
+			want:       MatchScore{Skills: 32},
+			wantSkills: []string{"Python", "Machine Learning"},
+		},
+		{
+			name:       "no wanted skills",
+			candidate:  candidate(func(p *UserProfile) { p.Skills = []string{"Cooking"} }),
+			viewer:     loggedIn,
+			college:    "MIT",
+			wantSkills: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scoreMatch(tt.candidate, tt.viewer, wanted, tt.endorsements, tt.college, now)
+			if got.Breakdown != tt.want {
+				t.Errorf("got breakdown %+v, want %+v", got.Breakdown, tt.want)
+			}
+			if want := roundScore(tt.want.Skills + tt.want.Endorsements + tt.want.College + tt.want.Activity); got.Score != want {
+				t.Errorf("got score %v, want %v", got.Score, want)
+			}
+			var skills []string
+			for _, skill := range got.MatchedSkills {
+				skills = append(skills, skill.Skill)
+			}
+			if len(skills) != len(tt.wantSkills) {
+				t.Fatalf("got matched skills %v, want %v", skills, tt.wantSkills)
+			}
+			for i := range skills {
+				if skills[i] != tt.wantSkills[i] {
+					t.Errorf("got matched skills %v, want %v", skills, tt.wantSkills)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Who may see a private profile field
+const (
+	VisibleToEveryone = "everyone"
+	VisibleToLoggedIn = "logged_in"
+	VisibleToNobody   = "nobody" // Other than the user themselves
+)
+
+// Visibilities lists every visibility a private field may have
+var Visibilities = []string{VisibleToEveryone, VisibleToLoggedIn, VisibleToNobody}
+
+// DefaultPrivacy is the visibility of each private profile field until the
+// user changes it. "location" also covers latitude and longitude.
+var DefaultPrivacy = PrivacySettings{
+	"email":    VisibleToNobody,
+	"location": VisibleToLoggedIn,
+	"college":  VisibleToEveryone,
+	"credits":  VisibleToNobody,
+}
+
+// PrivacySettings maps private profile fields to who may see them
+type PrivacySettings map[string]string
+
+// Viewer is whoever is asking for a profile; the zero Viewer is anonymous
+type Viewer struct {
+	UserID   primitive.ObjectID
+	LoggedIn bool
+}
+
+// PublicStats is the public form of a user's stats
+type PublicStats struct {
+	Rating            float64 `json:"rating"`
+	ReviewsCount      int     `json:"reviewsCount"`
+	TimeSpentHelping  string  `json:"timeSpentHelping"`
+	SessionsConducted int     `json:"sessionsConducted"`
+}
+
+// PublicAchievement is the public form of an awarded achievement
+type PublicAchievement struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	DateEarned  string `json:"dateEarned,omitempty"`
+}
+
+// PublicProfile is what one user may see of another. Private fields are left
+// out unless the owner's privacy settings let the viewer see them.
+type PublicProfile struct {
+	ID                string              `json:"id"`
+	Username          string              `json:"username,omitempty"`
+	Name              string              `json:"name"`
+	Email             string              `json:"email,omitempty"`
+	College           string              `json:"college,omitempty"`
+	Program           string              `json:"program,omitempty"`
+	YearOfStudy       string              `json:"yearOfStudy,omitempty"`
+	Bio               string              `json:"bio,omitempty"`
+	Gender            string              `json:"gender,omitempty"`
+	Skills            []string            `json:"skills"`
+	SkillLevels       []SkillLevel        `json:"skillLevels,omitempty"`
+	ProfilePictureURL string              `json:"profilePictureURL,omitempty"`
+	CoverImageURL     string              `json:"coverImageURL,omitempty"`
+	Location          string              `json:"location,omitempty"`
+	Place             *Place              `json:"place,omitempty"`
+	Latitude          *float64            `json:"latitude,omitempty"`  // Snapped to LocationGridDegrees
+	Longitude         *float64            `json:"longitude,omitempty"` // Snapped to LocationGridDegrees
+	Credits           *int                `json:"credits,omitempty"`
+	Stats             PublicStats         `json:"stats"`
+	Achievements      []PublicAchievement `json:"achievements"`
+	MemberSince       int64               `json:"memberSince,omitempty"`
+}
+
+// GetPrivacySettings returns a user's privacy settings, with defaults for fields they have not set
+func GetPrivacySettings(ctx context.Context, userID primitive.ObjectID) (PrivacySettings, error) {
+	var user struct {
+		Privacy PrivacySettings `bson:"privacy"`
+	}
+	err := config.GetCollection(UsersCollection).FindOne(ctx, NotDeleted(bson.M{"_id": userID}),
+		options.FindOne().SetProjection(bson.M{"privacy": 1})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch privacy settings: %v", err)
+	}
+	return user.Privacy.withDefaults(), nil
+}
+
+// UpdatePrivacySettings changes the visibility of some of a user's private
+// fields and returns all their settings. Unknown fields and visibilities are
+// reported in a *ValidationError.
+func UpdatePrivacySettings(ctx context.Context, userID primitive.ObjectID, updates PrivacySettings) (PrivacySettings, error) {
+	invalid := &ValidationError{}
+	set := bson.M{}
+	for field, visibility := range updates {
+		if _, ok := DefaultPrivacy[field]; !ok {
+			invalid.Add(field, "has no privacy setting")
+			continue
+		}
+		if !containsString(Visibilities, visibility) {
+			invalid.Add(field, "must be one of: %s", strings.Join(Visibilities, ", "))
+			continue
+		}
+		set["privacy."+field] = visibility
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+	if len(set) == 0 {
+		return GetPrivacySettings(ctx, userID)
+	}
+
+	var settings PrivacySettings
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var user struct {
+			Privacy PrivacySettings `bson:"privacy"`
+		}
+		err := config.GetCollection(UsersCollection).FindOneAndUpdate(sessCtx, NotDeleted(bson.M{"_id": userID}),
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"privacy": 1}),
+		).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update privacy settings: %v", err)
+		}
+		settings = user.Privacy.withDefaults()
+		return events.Record(sessCtx, events.ProfileUpdated, userID, events.ProfileUpdatedData{Fields: []string{"privacy"}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetVisibleProfile returns a user, with their username and privacy settings,
+// for showing to someone else. Deactivated users are not found.
+func GetVisibleProfile(ctx context.Context, userID primitive.ObjectID) (*UserProfile, error) {
+	var profile UserProfile
+	err := config.GetCollection(UsersCollection).FindOne(ctx, Visible(bson.M{"_id": userID}),
+		options.FindOne().SetProjection(userProjection)).Decode(&profile)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %v", err)
+	}
+	return &profile, nil
+}
+
+// NewPublicProfile shapes a profile for a viewer, applying the owner's
+// privacy settings. Users viewing their own profile see everything.
+// Coordinates are only ever shown snapped to LocationGridDegrees.
+func NewPublicProfile(profile UserProfile, viewer Viewer) PublicProfile {
+	privacy := profile.Privacy.withDefaults()
+	owner := viewer.LoggedIn && viewer.UserID == profile.ID
+	canSee := func(field string) bool {
+		switch {
+		case owner:
+			return true
+		case privacy[field] == VisibleToEveryone:
+			return true
+		case privacy[field] == VisibleToLoggedIn:
+			return viewer.LoggedIn
+		}
+		return false
+	}
+
+	public := PublicProfile{
+		ID:                profile.ID.Hex(),
+		Username:          profile.Username,
+		Name:              profile.Name,
+		Program:           profile.Program,
+		YearOfStudy:       profile.YearOfStudy,
+		Bio:               profile.Bio,
+		Gender:            profile.Gender,
+		Skills:            profile.Skills,
+		SkillLevels:       profile.SkillLevels,
+		ProfilePictureURL: profile.ProfilePictureURL,
+		CoverImageURL:     profile.CoverImageURL,
+		Stats: PublicStats{
+			Rating:            profile.Stats.Rating,
+			ReviewsCount:      profile.Stats.ReviewsCount,
+			TimeSpentHelping:  profile.Stats.TimeSpentHelping,
+			SessionsConducted: profile.Stats.SessionsConducted,
+		},
+		Achievements: make([]PublicAchievement, 0, len(profile.Achievements)),
+		MemberSince:  profile.CreatedAt,
+	}
+	if public.Skills == nil {
+		public.Skills = []string{}
+	}
+	for _, achievement := range profile.Achievements {
+		public.Achievements = append(public.Achievements, PublicAchievement{
+			Title:       achievement.Title,
+			Description: achievement.Description,
+			DateEarned:  achievement.DateEarned,
+		})
+	}
+
+	if canSee("email") {
+		public.Email = profile.Email
+	}
+	if canSee("college") {
+		public.College = profile.College
+	}
+	if canSee("location") {
+		public.Location = profile.Location
+		public.Place = profile.Place
+		if profile.Latitude != 0 || profile.Longitude != 0 {
+			// Stored coordinates are snapped; this covers any not yet backfilled
+			latitude, longitude := coarsenCoordinate(profile.Latitude), coarsenCoordinate(profile.Longitude)
+			public.Latitude = &latitude
+			public.Longitude = &longitude
+		}
+	}
+	if canSee("credits") {
+		public.Credits = &profile.Credits
+	}
+	return public
+}
+
+// NewPublicProfiles shapes a list of profiles for a viewer
+func NewPublicProfiles(profiles []UserProfile, viewer Viewer) []PublicProfile {
+	public := make([]PublicProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		public = append(public, NewPublicProfile(profile, viewer))
+	}
+	return public
+}
+
+// withDefaults fills in the default visibility of fields the user has not set
+func (p PrivacySettings) withDefaults() PrivacySettings {
+	settings := make(PrivacySettings, len(DefaultPrivacy))
+	for field, visibility := range DefaultPrivacy {
+		settings[field] = visibility
+		if v, ok := p[field]; ok && containsString(Visibilities, v) {
+			settings[field] = v
+		}
+	}
+	return settings
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How well a user knows one of their skills
+const (
+	ProficiencyBeginner     = "beginner"
+	ProficiencyIntermediate = "intermediate"
+	ProficiencyAdvanced     = "advanced"
+	ProficiencyExpert       = "expert"
+)
+
+// ProficiencyLevels lists every proficiency level, lowest first
+var ProficiencyLevels = []string{ProficiencyBeginner, ProficiencyIntermediate, ProficiencyAdvanced, ProficiencyExpert}
+
+// SkillLevel is how well a user says they know one of their skills
+type SkillLevel struct {
+	Skill string `bson:"skill" json:"skill"`
+	Level string `bson:"level" json:"level"`
+}
+
+// SetSkillLevels replaces a user's proficiency levels, given by skill. Skills
+// the user does not list and unknown levels are reported in a *ValidationError.
+func SetSkillLevels(ctx context.Context, userID primitive.ObjectID, levels map[string]string) ([]SkillLevel, error) {
+	var result []SkillLevel
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		users := config.GetCollection(UsersCollection)
+		var user struct {
+			Skills []string `bson:"skills"`
+		}
+		err := users.FindOne(sessCtx, NotDeleted(bson.M{"_id": userID}),
+			options.FindOne().SetProjection(bson.M{"skills": 1})).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %v", err)
+		}
+		listed := map[string]string{}
+		for _, skill := range user.Skills {
+			listed[SkillKey(skill)] = skill
+		}
+
+		invalid := &ValidationError{}
+		result = []SkillLevel{}
+		for skill, level := range levels {
+			name, ok := listed[SkillKey(skill)]
+			if !ok {
+				invalid.Add(skill, "is not one of your skills")
+				continue
+			}
+			if !containsString(ProficiencyLevels, level) {
+				invalid.Add(skill, "must be one of: %s", strings.Join(ProficiencyLevels, ", "))
+				continue
+			}
+			result = append(result, SkillLevel{Skill: name, Level: level})
+		}
+		if err := invalid.Err(); err != nil {
+			return err
+		}
+
+		// Guard against the skills changing since they were read
+		res, err := users.UpdateOne(sessCtx, bson.M{"_id": userID, "skills": user.Skills}, bson.M{"$set": bson.M{"skillLevels": result}})
+		if err != nil {
+			return fmt.Errorf("failed to update skill levels: %v", err)
+		}
+		if res.MatchedCount == 0 {
+			return ErrProfileConflict
+		}
+		return events.Record(sessCtx, events.ProfileUpdated, userID, events.ProfileUpdatedData{Fields: []string{"skillLevels"}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// skillLevelsByKey indexes skill levels by skill key
+func skillLevelsByKey(levels []SkillLevel) map[string]string {
+	byKey := make(map[string]string, len(levels))
+	for _, level := range levels {
+		byKey[SkillKey(level.Skill)] = level.Level
+	}
+	return byKey
+}
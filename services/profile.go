@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"github.com/ElioCloud/shared-models/models"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Profile patch formats, by Content-Type
+const (
+	MergePatch = "application/merge-patch+json" // RFC 7386
+	JSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// EditableField is a profile field users may change themselves
+type EditableField struct {
+	Kind      reflect.Kind // String, Float64, or Slice for a list of strings
+	Clearable bool         // Whether the field may be removed
+}
+
+// EditableProfileFields is the allowlist of profile fields a PATCH may touch,
+// keyed by their name in the user document. Everything else is owned by the
+// system or has its own endpoint.
+var EditableProfileFields = map[string]EditableField{
+	"name":        {Kind: reflect.String},
+	"college":     {Kind: reflect.String, Clearable: true},
+	"program":     {Kind: reflect.String, Clearable: true},
+	"yearOfStudy": {Kind: reflect.String, Clearable: true},
+	"bio":         {Kind: reflect.String, Clearable: true},
+	"gender":      {Kind: reflect.String, Clearable: true},
+	"skills":      {Kind: reflect.Slice, Clearable: true},
+	"location":    {Kind: reflect.String, Clearable: true},
+	"latitude":    {Kind: reflect.Float64, Clearable: true},
+	"longitude":   {Kind: reflect.Float64, Clearable: true},
+}
+
+var (
+	ErrUnsupportedPatch = errors.New("unsupported patch format")
+	ErrInvalidPatch     = errors.New("invalid patch")
+	ErrPatchTestFailed  = errors.New("patch test operation failed")
+	ErrProfileConflict  = errors.New("profile was changed by another request; fetch it and retry")
+)
+
+// ProfilePatchResult is a user's profile after a patch, with the fields it set and removed
+type ProfilePatchResult struct {
+	Before  models.User
+	After   models.User
+	Set     []string
+	Removed []string
+}
+
+// PatchProfile applies a JSON Merge Patch or JSON Patch to the editable part
+// of a user's profile. Fields a patch removes, or sets to null in a merge
+// patch, are unset. A patch touching any field outside EditableProfileFields
+// is rejected as a whole, with a *ValidationError listing every invalid
+// field. The update only applies if the changed fields still hold the values
+// the patch was applied to, so concurrent edits are not lost.
+func PatchProfile(ctx context.Context, userID primitive.ObjectID, format string, patch []byte) (*ProfilePatchResult, error) {
+	user, err := GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	original := editableDocument(user)
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode profile: %v", err)
+	}
+
+	var patchedJSON []byte
+	switch format {
+	case MergePatch:
+		patchedJSON, err = jsonpatch.MergePatch(originalJSON, patch)
+	case JSONPatch:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patchedJSON, err = operations.Apply(originalJSON)
+		}
+	default:
+		return nil, ErrUnsupportedPatch
+	}
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return nil, fmt.Errorf("%w: %v", ErrPatchTestFailed, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var patched map[string]interface{}
+	if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+		return nil, fmt.Errorf("%w: the result is not an object", ErrInvalidPatch)
+	}
+
+	set, unset, err := profileChanges(original, patched)
+	if err != nil {
+		return nil, err
+	}
+	if skills, ok := set["skills"].([]string); ok {
+		if set["skills"], err = NormalizeSkills(ctx, skills); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ProfilePatchResult{Before: *user, Set: sortedKeys(set), Removed: sortedKeys(unset)}
+	if len(set) == 0 && len(unset) == 0 {
+		result.After = *user
+		return result, nil
+	}
+
+	// Only apply if the fields being changed are as the patch saw them
+	filter := NotDeleted(bson.M{"_id": userID})
+	for name := range EditableProfileFields {
+		_, changed := set[name]
+		_, removed := unset[name]
+		if !changed && !removed {
+			continue
+		}
+		if value, ok := original[name]; ok {
+			filter[name] = value
+		} else {
+			// Older documents may store empty values instead of leaving them out
+			filter[name] = bson.M{"$in": bson.A{nil, "", 0, bson.A{}}}
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if changesLocation(set, unset) {
+		// The place follows the location fields as they will be after the update
+		after := func(name string, current interface{}) interface{} {
+			if value, ok := set[name]; ok {
+				return value
+			}
+			if _, ok := unset[name]; ok {
+				return nil
+			}
+			return current
+		}
+		location, _ := after("location", user.Location).(string)
+		latitude, _ := after("latitude", user.Latitude).(float64)
+		longitude, _ := after("longitude", user.Longitude).(float64)
+		place, err := ResolveLocation(ctx, location, latitude, longitude)
+		if err != nil {
+			return nil, err
+		}
+		setPlace(update, place)
+	}
+
+	err = config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		updated, err := config.GetCollection(UsersCollection).UpdateOne(sessCtx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to update profile: %v", err)
+		}
+		if updated.MatchedCount == 0 {
+			return ErrProfileConflict
+		}
+		fields := append(append([]string{}, result.Set...), result.Removed...)
+		sort.Strings(fields)
+		return events.Record(sessCtx, events.ProfileUpdated, userID, events.ProfileUpdatedData{Fields: fields})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result.After = *after
+	return result, nil
+}
+
+// changesLocation reports whether an update touches a field "place" is worked out from
+func changesLocation(set, unset bson.M) bool {
+	for _, name := range []string{"location", "latitude", "longitude"} {
+		_, changed := set[name]
+		_, removed := unset[name]
+		if changed || removed {
+			return true
+		}
+	}
+	return false
+}
+
+// editableDocument is the editable part of a user's profile as the JSON
+// document patches apply to. Empty fields are left out, as they are in storage.
+func editableDocument(user *models.User) map[string]interface{} {
+	doc := map[string]interface{}{}
+	values := map[string]interface{}{
+		"name":        user.Name,
+		"college":     user.College,
+		"program":     user.Program,
+		"yearOfStudy": user.YearOfStudy,
+		"bio":         user.Bio,
+		"gender":      user.Gender,
+		"location":    user.Location,
+	}
+	for name, value := range values {
+		if value != "" {
+			doc[name] = value
+		}
+	}
+	if len(user.Skills) > 0 {
+		doc["skills"] = user.Skills
+	}
+	if user.Latitude != 0 {
+		doc["latitude"] = user.Latitude
+	}
+	if user.Longitude != 0 {
+		doc["longitude"] = user.Longitude
+	}
+	return doc
+}
+
+// profileChanges compares a patched document with the original and returns
+// the fields to set and to unset. Changed values are validated and cleaned up
+// with ValidateProfileFields, so values stored before validation existed do
+// not block unrelated edits. Empty values count as removed, since they are
+// left out of the stored profile anyway.
+func profileChanges(original, patched map[string]interface{}) (bson.M, bson.M, error) {
+	invalid := &ValidationError{}
+	converted := make(map[string]interface{}, len(patched))
+	emptied := map[string]bool{}
+	for name, value := range patched {
+		field, ok := EditableProfileFields[name]
+		switch {
+		case !ok && IsSystemOwnedField(name):
+			invalid.Add(name, "is managed by the server and cannot be set")
+		case !ok:
+			invalid.Add(name, "cannot be edited")
+		default:
+			v, ok := convertProfileValue(invalid, name, field, value)
+			previous, found := original[name]
+			switch {
+			case !ok || (found && reflect.DeepEqual(previous, v)):
+			case isEmptyProfileValue(v):
+				emptied[name] = true
+			default:
+				converted[name] = v
+			}
+		}
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	clean, err := ValidateProfileFields(converted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	for name, value := range clean {
+		if !isEmptyProfileValue(value) {
+			set[name] = value
+		}
+	}
+	for name := range original {
+		_, kept := patched[name]
+		if value, changed := clean[name]; kept && !emptied[name] && (!changed || !isEmptyProfileValue(value)) {
+			continue
+		}
+		if !EditableProfileFields[name].Clearable {
+			invalid.Add(name, "cannot be removed")
+			continue
+		}
+		unset[name] = ""
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, nil, err
+	}
+	return set, unset, nil
+}
+
+// convertProfileValue checks a decoded JSON value against a field's type and
+// converts it to what is stored
+func convertProfileValue(invalid *ValidationError, name string, field EditableField, value interface{}) (interface{}, bool) {
+	switch field.Kind {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+		invalid.Add(name, "must be a string")
+	case reflect.Float64:
+		if f, ok := value.(float64); ok {
+			return f, true
+		}
+		invalid.Add(name, "must be a number")
+	case reflect.Slice:
+		items, _ := value.([]interface{})
+		strs := make([]string, 0, len(items))
+		for _, item := range items {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		if items != nil && len(strs) == len(items) {
+			return strs, true
+		}
+		invalid.Add(name, "must be a list of strings")
+	}
+	return nil, false
+}
+
+func isEmptyProfileValue(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
+
+func sortedKeys(m bson.M) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
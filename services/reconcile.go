@@ -0,0 +1,351 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"trademinutes-user/config"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const ReconciliationReportsCollection = "reconciliationReports"
+
+// EntryTypeReconciliation marks a corrective entry that brings the ledger in
+// line with a balance that was changed outside of it. It does not move credits.
+const EntryTypeReconciliation = "reconciliation"
+
+// ErrDriftNeedsReview means a drift is larger than a single admin may adjust
+// credits by, so reconciliation leaves it for admins to investigate
+var ErrDriftNeedsReview = errors.New("drift is above the adjustment approval threshold")
+
+// BalanceMismatch is a user whose stored balance differs from their ledger
+type BalanceMismatch struct {
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Email     string             `bson:"email" json:"email"`
+	Balance   int                `bson:"balance" json:"balance"`
+	LedgerSum int                `bson:"ledgerSum" json:"ledgerSum"`
+	Drift     int                `bson:"drift" json:"drift"` // Balance minus ledger sum
+	Corrected bool               `bson:"corrected" json:"corrected"`
+	// NeedsReview is set when fixing was asked for but the drift is above
+	// the adjustment approval threshold, so it was not corrected
+	NeedsReview bool `bson:"needsReview,omitempty" json:"needsReview,omitempty"`
+}
+
+// OrphanLedger is the ledger of a user that no longer exists, such as one
+// whose account was deleted with credits left
+type OrphanLedger struct {
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	LedgerSum int                `bson:"ledgerSum" json:"ledgerSum"`
+}
+
+// ReconciliationReport is the outcome of one reconciliation run
+type ReconciliationReport struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StartedAt    int64              `bson:"startedAt" json:"startedAt"`
+	FinishedAt   int64              `bson:"finishedAt" json:"finishedAt"`
+	Fix          bool               `bson:"fix" json:"fix"`
+	UsersChecked int                `bson:"usersChecked" json:"usersChecked"`
+	Mismatches   []BalanceMismatch  `bson:"mismatches" json:"mismatches"`
+
+	// Global invariants over existing users: issued - spent must equal the
+	// sum of balances, and unspent lots can never add up to more than the
+	// balances holding them
+	TotalIssued       int      `bson:"totalIssued" json:"totalIssued"`
+	TotalSpent        int      `bson:"totalSpent" json:"totalSpent"`
+	TotalBalances     int      `bson:"totalBalances" json:"totalBalances"`
+	TotalLotRemaining int      `bson:"totalLotRemaining" json:"totalLotRemaining"`
+	Violations        []string `bson:"violations" json:"violations"`
+
+	// Ledgers of users that no longer exist, kept apart from the invariants
+	Orphans         []OrphanLedger `bson:"orphans" json:"orphans"`
+	OrphanLedgerSum int            `bson:"orphanLedgerSum" json:"orphanLedgerSum"`
+}
+
+// Healthy reports whether the run found no drift and no invariant violations
+// (after corrections, if the run was allowed to fix drift)
+func (r *ReconciliationReport) Healthy() bool {
+	if len(r.Violations) > 0 {
+		return false
+	}
+	for _, mismatch := range r.Mismatches {
+		if !mismatch.Corrected {
+			return false
+		}
+	}
+	return true
+}
+
+// Reconcile recomputes every user's balance from the ledger and reports any
+// drift. Users, ledger and lots are all read as of one moment, so activity
+// during the run is never mistaken for drift. With fix set, each user whose
+// drift is within the adjustment approval threshold gets a corrective
+// reconciliation entry so their ledger sums to their balance again; larger
+// drifts are left for admins. The report is saved for later review.
+func Reconcile(ctx context.Context, fix bool) (*ReconciliationReport, error) {
+	report := ReconciliationReport{
+		ID:         primitive.NewObjectID(),
+		StartedAt:  time.Now().Unix(),
+		Fix:        fix,
+		Mismatches: []BalanceMismatch{},
+		Violations: []string{},
+		Orphans:    []OrphanLedger{},
+	}
+
+	err := config.WithSnapshot(ctx, func(sessCtx mongo.SessionContext) error {
+		return readReconciliation(sessCtx, &report)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if fix {
+		// Corrections are held to the same limit as one admin's manual adjustments
+		threshold := AdjustmentApprovalThreshold()
+		for i, mismatch := range report.Mismatches {
+			err := correctDrift(ctx, mismatch.UserID, threshold)
+			if errors.Is(err, ErrDriftNeedsReview) {
+				report.Mismatches[i].NeedsReview = true
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to correct balance drift for %s: %v", mismatch.UserID.Hex(), err)
+				continue
+			}
+			report.Mismatches[i].Corrected = true
+			// Count the correction as the entry it posted would be
+			if mismatch.Drift > 0 {
+				report.TotalIssued += mismatch.Drift
+			} else {
+				report.TotalSpent -= mismatch.Drift
+			}
+		}
+	}
+
+	checkGlobalInvariants(&report)
+
+	report.FinishedAt = time.Now().Unix()
+	if _, err := config.GetCollection(ReconciliationReportsCollection).InsertOne(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to save reconciliation report: %v", err)
+	}
+	return &report, nil
+}
+
+// LatestReconciliationReport returns the most recent saved report
+func LatestReconciliationReport(ctx context.Context) (*ReconciliationReport, error) {
+	var report ReconciliationReport
+	err := config.GetCollection(ReconciliationReportsCollection).FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"startedAt": -1})).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reconciliation report: %v", err)
+	}
+	return &report, nil
+}
+
+// StartReconciliationJob runs Reconcile on the given interval until the process exits
+func StartReconciliationJob(interval time.Duration, fix bool) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			report, err := Reconcile(ctx, fix)
+			cancel()
+			if err != nil {
+				log.Printf("❌ Ledger reconciliation failed: %v", err)
+				continue
+			}
+			if report.Healthy() {
+				log.Printf("✅ Ledger reconciliation: %d users checked, no drift", report.UsersChecked)
+			} else {
+				log.Printf("⚠️  Ledger reconciliation: %d of %d users drifted, violations: %v", len(report.Mismatches), report.UsersChecked, report.Violations)
+			}
+		}
+	}()
+}
+
+// correctDrift re-checks one user inside a transaction and, if their balance
+// still differs from their ledger by no more than threshold, posts a
+// reconciliation entry for the difference
+func correctDrift(ctx context.Context, userID primitive.ObjectID, threshold int) error {
+	return config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var user models.User
+		if err := config.GetCollection(UsersCollection).FindOne(sessCtx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return err
+		}
+
+		ledgerSum, err := ledgerSumForUser(sessCtx, userID)
+		if err != nil {
+			return err
+		}
+		drift := user.Credits - ledgerSum
+		if drift == 0 {
+			return nil
+		}
+		if abs(drift) > threshold {
+			return ErrDriftNeedsReview
+		}
+
+		_, err = config.GetCollection(LedgerCollection).InsertOne(sessCtx, LedgerEntry{
+			ID:           primitive.NewObjectID(),
+			UserID:       userID,
+			Type:         EntryTypeReconciliation,
+			Amount:       drift,
+			BalanceAfter: user.Credits,
+			Note:         fmt.Sprintf("Balance drift of %+d corrected by reconciliation", drift),
+			CreatedAt:    time.Now().Unix(),
+		})
+		return err
+	})
+}
+
+// readReconciliation fills in a report from users, their ledgers and lots.
+// It must run in a snapshot session so all three are read as of one moment.
+func readReconciliation(sessCtx mongo.SessionContext, report *ReconciliationReport) error {
+	ledgers, err := ledgerTotalsByUser(sessCtx)
+	if err != nil {
+		return err
+	}
+
+	users, err := config.GetCollection(UsersCollection).Find(sessCtx, bson.M{}, options.Find().SetProjection(bson.M{"email": 1, "credits": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to fetch users: %v", err)
+	}
+	defer users.Close(sessCtx)
+
+	for users.Next(sessCtx) {
+		var user models.User
+		if err := users.Decode(&user); err != nil {
+			return fmt.Errorf("failed to decode user: %v", err)
+		}
+		report.UsersChecked++
+		report.TotalBalances += user.Credits
+
+		ledger := ledgers[user.ID]
+		delete(ledgers, user.ID)
+		report.TotalIssued += ledger.Issued
+		report.TotalSpent += ledger.Spent
+		if user.Credits == ledger.Sum {
+			continue
+		}
+		report.Mismatches = append(report.Mismatches, BalanceMismatch{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Balance:   user.Credits,
+			LedgerSum: ledger.Sum,
+			Drift:     user.Credits - ledger.Sum,
+		})
+	}
+	if err := users.Err(); err != nil {
+		return fmt.Errorf("failed to iterate users: %v", err)
+	}
+
+	for userID, ledger := range ledgers {
+		report.Orphans = append(report.Orphans, OrphanLedger{UserID: userID, LedgerSum: ledger.Sum})
+		report.OrphanLedgerSum += ledger.Sum
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool {
+		return report.Orphans[i].UserID.Hex() < report.Orphans[j].UserID.Hex()
+	})
+
+	cursor, err := config.GetCollection(LotsCollection).Aggregate(sessCtx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": LotActive}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "remaining": bson.M{"$sum": "$remaining"}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to total credit lots: %v", err)
+	}
+	var lots []struct {
+		Remaining int `bson:"remaining"`
+	}
+	if err := cursor.All(sessCtx, &lots); err != nil {
+		return fmt.Errorf("failed to decode credit lot totals: %v", err)
+	}
+	if len(lots) > 0 {
+		report.TotalLotRemaining = lots[0].Remaining
+	}
+	return nil
+}
+
+// checkGlobalInvariants records the invariants a report's totals break.
+// Totals include any corrections, so a fixed run should balance exactly.
+func checkGlobalInvariants(report *ReconciliationReport) {
+	net := report.TotalIssued - report.TotalSpent
+	if net != report.TotalBalances {
+		report.Violations = append(report.Violations, fmt.Sprintf(
+			"issued (%d) - spent (%d) = %d, but balances sum to %d",
+			report.TotalIssued, report.TotalSpent, net, report.TotalBalances))
+	}
+	if report.TotalLotRemaining > report.TotalBalances {
+		report.Violations = append(report.Violations, fmt.Sprintf(
+			"active lots hold %d credits but balances only sum to %d", report.TotalLotRemaining, report.TotalBalances))
+	}
+}
+
+// ledgerTotals is what a user's ledger entries add up to
+type ledgerTotals struct {
+	Sum    int
+	Issued int
+	Spent  int
+}
+
+func ledgerTotalsByUser(ctx context.Context) (map[primitive.ObjectID]ledgerTotals, error) {
+	cursor, err := config.GetCollection(LedgerCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$userId",
+			"sum":    bson.M{"$sum": "$amount"},
+			"issued": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$amount", 0}}, "$amount", 0}}},
+			"spent":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$lt": bson.A{"$amount", 0}}, bson.M{"$multiply": bson.A{"$amount", -1}}, 0}}},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum ledger: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	totals := map[primitive.ObjectID]ledgerTotals{}
+	for cursor.Next(ctx) {
+		var row struct {
+			UserID primitive.ObjectID `bson:"_id"`
+			Sum    int                `bson:"sum"`
+			Issued int                `bson:"issued"`
+			Spent  int                `bson:"spent"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, fmt.Errorf("failed to decode ledger sum: %v", err)
+		}
+		totals[row.UserID] = ledgerTotals{Sum: row.Sum, Issued: row.Issued, Spent: row.Spent}
+	}
+	return totals, cursor.Err()
+}
+
+func ledgerSumForUser(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	cursor, err := config.GetCollection(LedgerCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "sum": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum ledger: %v", err)
+	}
+	var rows []struct {
+		Sum int `bson:"sum"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return 0, fmt.Errorf("failed to decode ledger sum: %v", err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Sum, nil
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ReferralCodesCollection = "referralCodes"
+	ReferralsCollection     = "referrals"
+)
+
+// Referral statuses
+const (
+	ReferralPending  = "pending"  // Attributed, waiting for the referee to complete their profile
+	ReferralRewarded = "rewarded" // Both parties have been paid
+	ReferralRejected = "rejected" // Blocked by a fraud guard; see RejectReason
+)
+
+// Referral rejection reasons
+const (
+	ReferralSelfReferral = "self_referral"
+	ReferralSameIP       = "same_ip"
+	ReferralSameDevice   = "same_device"
+	ReferralCapReached   = "cap_reached"
+)
+
+// referralCodeAlphabet leaves out characters that are easy to confuse (0/O, 1/I/L)
+const referralCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+var ErrInvalidReferralCode = errors.New("invalid referral code")
+
+// SignupContext describes where a signup came from, for referral fraud checks
+type SignupContext struct {
+	IP       string
+	DeviceID string
+}
+
+// ReferralCode maps a shareable invite code to the user who owns it
+type ReferralCode struct {
+	Code      string             `bson:"_id" json:"code"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	CreatedAt int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// Referral records that a new user signed up with someone's invite code
+type Referral struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ReferrerID   primitive.ObjectID `bson:"referrerId" json:"referrerId"`
+	RefereeID    primitive.ObjectID `bson:"refereeId" json:"refereeId"`
+	RefereeName  string             `bson:"refereeName" json:"refereeName"`
+	Code         string             `bson:"code" json:"code"`
+	Status       string             `bson:"status" json:"status"`
+	RejectReason string             `bson:"rejectReason,omitempty" json:"rejectReason,omitempty"`
+	SignupIP     string             `bson:"signupIP,omitempty" json:"-"`
+	DeviceID     string             `bson:"deviceId,omitempty" json:"-"`
+	CreatedAt    int64              `bson:"createdAt" json:"createdAt"`
+	RewardedAt   int64              `bson:"rewardedAt,omitempty" json:"rewardedAt,omitempty"`
+}
+
+// ReferralDashboard is what a user sees about their own referrals
+type ReferralDashboard struct {
+	Code          string     `json:"code"`
+	ShareURL      string     `json:"shareUrl"`
+	Pending       int        `json:"pending"`
+	Rewarded      int        `json:"rewarded"`
+	Rejected      int        `json:"rejected"`
+	RemainingCap  int        `json:"remainingCap"`
+	CreditsEarned int        `json:"creditsEarned"`
+	ReferredBy    string     `json:"referredBy,omitempty"`
+	Referrals     []Referral `json:"referrals"`
+}
+
+// RecordSignupTx stores the signup context on a new user and, if they signed
+// up with someone else's code, attributes the referral. The user's own code is
+// created when they first ask for it. Referrals blocked by a fraud guard are still recorded, as rejected.
+// An unknown code returns ErrInvalidReferralCode.
+func RecordSignupTx(sessCtx mongo.SessionContext, user models.User, referralCode string, signup SignupContext) (*Referral, error) {
+	users := config.GetCollection(UsersCollection)
+
+	_, err := users.UpdateOne(sessCtx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"signupIP":       signup.IP,
+		"signupDeviceId": signup.DeviceID,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record signup context: %v", err)
+	}
+
+	referralCode = strings.ToUpper(strings.TrimSpace(referralCode))
+	if referralCode == "" {
+		return nil, nil
+	}
+
+	var code ReferralCode
+	err = config.GetCollection(ReferralCodesCollection).FindOne(sessCtx, bson.M{"_id": referralCode}).Decode(&code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidReferralCode
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up referral code: %v", err)
+	}
+
+	var referrer models.User
+	if err := users.FindOne(sessCtx, Visible(bson.M{"_id": code.UserID})).Decode(&referrer); err != nil {
+		return nil, ErrInvalidReferralCode
+	}
+
+	referral := Referral{
+		ID:          primitive.NewObjectID(),
+		ReferrerID:  referrer.ID,
+		RefereeID:   user.ID,
+		RefereeName: user.Name,
+		Code:        referralCode,
+		Status:      ReferralPending,
+		SignupIP:    signup.IP,
+		DeviceID:    signup.DeviceID,
+		CreatedAt:   time.Now().Unix(),
+	}
+
+	reason, err := referralFraudCheck(sessCtx, referrer, user, signup)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		referral.Status = ReferralRejected
+		referral.RejectReason = reason
+		log.Printf("⚠️  Referral of %s by %s rejected: %s", user.ID.Hex(), referrer.ID.Hex(), reason)
+	}
+
+	if _, err := config.GetCollection(ReferralsCollection).InsertOne(sessCtx, referral); err != nil {
+		return nil, fmt.Errorf("failed to record referral: %v", err)
+	}
+
+	if referral.Status == ReferralPending {
+		_, err = users.UpdateOne(sessCtx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+			"referredBy":   referrer.ID,
+			"referralCode": referralCode,
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to attribute referral: %v", err)
+		}
+	}
+
+	return &referral, nil
+}
+
+// CompleteReferral pays out the referral bonuses once the referee has completed
+// their profile. It is a no-op if the user has no pending referral.
+func CompleteReferral(ctx context.Context, refereeID primitive.ObjectID) error {
+	return config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		collection := config.GetCollection(ReferralsCollection)
+
+		var referral Referral
+		err := collection.FindOne(sessCtx, bson.M{"refereeId": refereeID, "status": ReferralPending}).Decode(&referral)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Re-check the cap at payout time, since several referees may finish around the same time
+		rewarded, err := collection.CountDocuments(sessCtx, bson.M{"referrerId": referral.ReferrerID, "status": ReferralRewarded})
+		if err != nil {
+			return err
+		}
+		if int(rewarded) >= config.ReferralMaxPerReferrer() {
+			_, err = collection.UpdateOne(sessCtx, bson.M{"_id": referral.ID}, bson.M{"$set": bson.M{
+				"status":       ReferralRejected,
+				"rejectReason": ReferralCapReached,
+			}})
+			return err
+		}
+
+		key := "referral:" + referral.ID.Hex()
+		if _, err := awardRuleTx(sessCtx, referral.ReferrerID, BonusReferralReferrer, key+":referrer"); err != nil && !errors.Is(err, ErrBonusAlreadyGranted) {
+			return err
+		}
+		if _, err := awardRuleTx(sessCtx, referral.RefereeID, BonusReferralReferee, key+":referee"); err != nil && !errors.Is(err, ErrBonusAlreadyGranted) {
+			return err
+		}
+
+		_, err = collection.UpdateOne(sessCtx, bson.M{"_id": referral.ID}, bson.M{"$set": bson.M{
+			"status":     ReferralRewarded,
+			"rewardedAt": time.Now().Unix(),
+		}})
+		return err
+	})
+}
+
+// GetReferralDashboard returns a user's referral code, share link and referral history
+func GetReferralDashboard(ctx context.Context, user models.User) (*ReferralDashboard, error) {
+	code, err := ensureReferralCode(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := config.GetCollection(ReferralsCollection).Find(ctx, bson.M{"referrerId": user.ID}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referrals: %v", err)
+	}
+	referrals := []Referral{}
+	if err := cursor.All(ctx, &referrals); err != nil {
+		return nil, fmt.Errorf("failed to decode referrals: %v", err)
+	}
+
+	dashboard := ReferralDashboard{
+		Code:      code,
+		ShareURL:  strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + "/register?ref=" + code,
+		Referrals: referrals,
+	}
+	for _, referral := range referrals {
+		switch referral.Status {
+		case ReferralPending:
+			dashboard.Pending++
+		case ReferralRewarded:
+			dashboard.Rewarded++
+		case ReferralRejected:
+			dashboard.Rejected++
+		}
+	}
+	dashboard.RemainingCap = config.ReferralMaxPerReferrer() - dashboard.Rewarded - dashboard.Pending
+	if dashboard.RemainingCap < 0 {
+		dashboard.RemainingCap = 0
+	}
+
+	cursor, err = config.GetCollection(LedgerCollection).Find(ctx, bson.M{"userId": user.ID, "type": EntryTypeBonus, "reasonCode": BonusReferralReferrer})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referral earnings: %v", err)
+	}
+	var entries []LedgerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode referral earnings: %v", err)
+	}
+	for _, entry := range entries {
+		dashboard.CreditsEarned += entry.Amount
+	}
+
+	var referredBy struct {
+		ReferralCode string `bson:"referralCode"`
+	}
+	if err := config.GetCollection(UsersCollection).FindOne(ctx, bson.M{"_id": user.ID}).Decode(&referredBy); err == nil {
+		dashboard.ReferredBy = referredBy.ReferralCode
+	}
+
+	return &dashboard, nil
+}
+
+// referralFraudCheck returns the reason a referral should be rejected, or "" if it looks legitimate
+func referralFraudCheck(ctx context.Context, referrer, referee models.User, signup SignupContext) (string, error) {
+	if referrer.ID == referee.ID || canonicalEmail(referrer.Email) == canonicalEmail(referee.Email) {
+		return ReferralSelfReferral, nil
+	}
+
+	var referrerSignup struct {
+		SignupIP       string `bson:"signupIP"`
+		SignupDeviceID string `bson:"signupDeviceId"`
+	}
+	if err := config.GetCollection(UsersCollection).FindOne(ctx, bson.M{"_id": referrer.ID}).Decode(&referrerSignup); err != nil {
+		return "", fmt.Errorf("failed to look up referrer: %v", err)
+	}
+	if signup.IP != "" && signup.IP == referrerSignup.SignupIP {
+		return ReferralSameIP, nil
+	}
+	if signup.DeviceID != "" && signup.DeviceID == referrerSignup.SignupDeviceID {
+		return ReferralSameDevice, nil
+	}
+
+	// Several referees of the same referrer signing up from one IP or device is a farm, not classmates
+	referrals := config.GetCollection(ReferralsCollection)
+	if signup.IP != "" {
+		count, err := referrals.CountDocuments(ctx, bson.M{"referrerId": referrer.ID, "signupIP": signup.IP})
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return ReferralSameIP, nil
+		}
+	}
+	if signup.DeviceID != "" {
+		count, err := referrals.CountDocuments(ctx, bson.M{"referrerId": referrer.ID, "deviceId": signup.DeviceID})
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return ReferralSameDevice, nil
+		}
+	}
+
+	count, err := referrals.CountDocuments(ctx, bson.M{
+		"referrerId": referrer.ID,
+		"status":     bson.M{"$in": []string{ReferralPending, ReferralRewarded}},
+	})
+	if err != nil {
+		return "", err
+	}
+	if int(count) >= config.ReferralMaxPerReferrer() {
+		return ReferralCapReached, nil
+	}
+
+	return "", nil
+}
+
+// canonicalEmail strips "+tag" suffixes so aliases of one mailbox compare equal
+func canonicalEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return local + domain
+}
+
+// ensureReferralCode returns the user's referral code, creating one if needed.
+// It must not run in a transaction: a code that is already taken fails the
+// insert, which would abort the transaction before the next try.
+func ensureReferralCode(ctx context.Context, userID primitive.ObjectID) (string, error) {
+	collection := config.GetCollection(ReferralCodesCollection)
+
+	var existing ReferralCode
+	err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&existing)
+	if err == nil {
+		return existing.Code, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("failed to look up referral code: %v", err)
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := generateReferralCode(8)
+		if err != nil {
+			return "", err
+		}
+		_, err = collection.InsertOne(ctx, ReferralCode{Code: code, UserID: userID, CreatedAt: time.Now().Unix()})
+		if err == nil {
+			return code, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return "", fmt.Errorf("failed to create referral code: %v", err)
+		}
+		// Either the code is taken or a concurrent request created the user's code
+		err = collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&existing)
+		if err == nil {
+			return existing.Code, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return "", fmt.Errorf("failed to look up referral code: %v", err)
+		}
+	}
+	return "", fmt.Errorf("failed to generate a unique referral code")
+}
+
+func generateReferralCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(referralCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = referralCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Refund statuses of a debit, derived from its RefundedAmount
+const (
+	RefundStatusNone     = ""
+	RefundStatusPartial  = "partially_refunded"
+	RefundStatusRefunded = "refunded"
+)
+
+var (
+	ErrEntryNotFound       = errors.New("ledger entry not found")
+	ErrEntryNotReversible  = errors.New("only deductions can be reversed")
+	ErrInvalidRefundAmount = errors.New("refund amount must be positive")
+	ErrRefundExceedsCharge = errors.New("refund exceeds the amount left to refund")
+)
+
+// RefundStatus reports whether a debit has been partially or fully refunded
+func (e LedgerEntry) RefundStatus() string {
+	switch {
+	case e.RefundedAmount <= 0:
+		return RefundStatusNone
+	case e.RefundedAmount >= -e.Amount:
+		return RefundStatusRefunded
+	default:
+		return RefundStatusPartial
+	}
+}
+
+// Refundable returns how many credits of a deduction can still be refunded
+func (e LedgerEntry) Refundable() int {
+	if e.Type != EntryTypeDeduction {
+		return 0
+	}
+	return -e.Amount - e.RefundedAmount
+}
+
+// ReversalRequest describes a full or partial refund of a deduction
+type ReversalRequest struct {
+	EntryID primitive.ObjectID
+	Amount  int // 0 refunds everything not yet refunded
+	Reason  string
+	Actor   string // Admin email or calling service
+	// IdempotencyKey makes retries safe, e.g. the ID of the cancelled booking
+	IdempotencyKey string
+}
+
+// ReverseEntry refunds credits taken by a deduction. The refund is posted as a
+// reversal entry linked to the original, goes into a non-expiring refund lot, and
+// can never push the total refunded above the amount charged. Retrying with the
+// same idempotency key returns the reversal already posted.
+func ReverseEntry(ctx context.Context, request ReversalRequest) (*LedgerEntry, *LedgerEntry, error) {
+	if request.Amount < 0 {
+		return nil, nil, ErrInvalidRefundAmount
+	}
+
+	idempotencyKey := ""
+	if request.IdempotencyKey != "" {
+		idempotencyKey = "reversal:" + request.EntryID.Hex() + ":" + request.IdempotencyKey
+		if reversal, original, err := existingReversal(ctx, idempotencyKey, request.EntryID); err != nil || reversal != nil {
+			return reversal, original, err
+		}
+	}
+
+	var reversal, original *LedgerEntry
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		entry, err := getLedgerEntry(sessCtx, request.EntryID)
+		if err != nil {
+			return err
+		}
+		if entry.Type != EntryTypeDeduction {
+			return ErrEntryNotReversible
+		}
+
+		amount := request.Amount
+		if amount == 0 {
+			amount = entry.Refundable()
+			if amount == 0 {
+				return ErrRefundExceedsCharge
+			}
+		}
+
+		// Guard on the stored total so concurrent refunds cannot overshoot the charge
+		result, err := config.GetCollection(LedgerCollection).UpdateOne(sessCtx,
+			bson.M{
+				"_id": entry.ID,
+				"$expr": bson.M{"$lte": bson.A{
+					bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$refundedAmount", 0}}, amount}},
+					bson.M{"$multiply": bson.A{"$amount", -1}},
+				}},
+			},
+			bson.M{"$inc": bson.M{"refundedAmount": amount}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record refund: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return ErrRefundExceedsCharge
+		}
+		entry.RefundedAmount += amount
+		original = entry
+
+		reversal, _, err = applyCredit(sessCtx, LedgerEntry{
+			UserID:         entry.UserID,
+			Type:           EntryTypeReversal,
+			Amount:         amount,
+			Note:           request.Reason,
+			ActorEmail:     request.Actor,
+			Reference:      entry.Reference,
+			ReversalOf:     entry.ID,
+			IdempotencyKey: idempotencyKey,
+		}, LotSourceRefund, 0)
+		return err
+	})
+	// A concurrent retry with the same key won the race; hand back its reversal
+	if mongo.IsDuplicateKeyError(err) && idempotencyKey != "" {
+		return existingReversal(ctx, idempotencyKey, request.EntryID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return reversal, original, nil
+}
+
+func getLedgerEntry(ctx context.Context, entryID primitive.ObjectID) (*LedgerEntry, error) {
+	var entry LedgerEntry
+	err := config.GetCollection(LedgerCollection).FindOne(ctx, bson.M{"_id": entryID}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entry: %v", err)
+	}
+	return &entry, nil
+}
+
+// existingReversal returns the reversal already posted under an idempotency
+// key along with its original entry, or nils if there is none yet
+func existingReversal(ctx context.Context, idempotencyKey string, entryID primitive.ObjectID) (*LedgerEntry, *LedgerEntry, error) {
+	reversal, err := entryByIdempotencyKey(ctx, idempotencyKey)
+	if err != nil || reversal == nil {
+		return nil, nil, err
+	}
+	original, err := getLedgerEntry(ctx, entryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reversal, original, nil
+}
+
+// HistoryEntry is a ledger entry as shown in a user's credit history
+type HistoryEntry struct {
+	LedgerEntry  `bson:",inline"`
+	RefundStatus string `json:"refundStatus,omitempty"`
+	Refundable   int    `json:"refundable,omitempty"`
+}
+
+// ListHistory returns a page of a user's ledger entries, newest first, with
+// the refund status of each debit, plus the total number of entries
+func ListHistory(ctx context.Context, userID primitive.ObjectID, limit, skip int64) ([]HistoryEntry, int64, error) {
+	collection := config.GetCollection(LedgerCollection)
+	filter := bson.M{"userId": userID}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count ledger entries: %v", err)
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch ledger entries: %v", err)
+	}
+	var entries []LedgerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode ledger entries: %v", err)
+	}
+
+	history := make([]HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, HistoryEntry{
+			LedgerEntry:  entry,
+			RefundStatus: entry.RefundStatus(),
+			Refundable:   entry.Refundable(),
+		})
+	}
+	return history, total, nil
+}
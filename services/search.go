@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Search limits
+const (
+	MaxSearchQueryLength = 100
+	DefaultSearchLimit   = 20
+	MaxSearchLimit       = 50
+	MaxFacetValues       = 20
+)
+
+// Search sort orders. Relevance needs a query and is the default when there is one.
+const (
+	SortRelevance = "relevance"
+	SortNewest    = "newest"
+	SortRating    = "rating"
+	SortName      = "name"
+)
+
+// SearchSorts lists every sort order
+var SearchSorts = []string{SortRelevance, SortNewest, SortRating, SortName}
+
+var (
+	ErrInvalidSearchSort = errors.New("invalid sort")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+)
+
+// UserSearch is a user search request. Query is matched against the text
+// index on name, skills, bio, college and program; the other fields filter exactly.
+// Colleges are only filtered by, counted and shown where their owner allows it.
+type UserSearch struct {
+	Query       string
+	College     string
+	Program     string
+	YearOfStudy string
+	Skill       string // Matched case-insensitively
+	Sort        string
+	Limit       int
+	Cursor      string // NextCursor of the previous page
+}
+
+// FacetCount is how many matching users share a value
+type FacetCount struct {
+	Value string `bson:"_id" json:"value"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// SearchFacets counts matching users by college, program and year of study
+type SearchFacets struct {
+	College     []FacetCount `bson:"college" json:"college"`
+	Program     []FacetCount `bson:"program" json:"program"`
+	YearOfStudy []FacetCount `bson:"yearOfStudy" json:"yearOfStudy"`
+}
+
+// UserSearchResult is one page of search results. Facets come with the first page only.
+type UserSearchResult struct {
+	Users      []PublicProfile `json:"data"`
+	Count      int             `json:"count"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+	Facets     *SearchFacets   `json:"facets,omitempty"`
+}
+
+// searchCursor marks where a page ended: the sort value and ID of its last user
+type searchCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    string      `json:"id"`
+}
+
+// NameFilter matches users whose name contains query, case-insensitively.
+// The query is matched literally, never as a pattern.
+func NameFilter(query string) bson.M {
+	return bson.M{"name": containsPattern(query)}
+}
+
+// NameOrEmailFilter is NameFilter that also matches emails. Emails may be
+// private, so it is only for admins.
+func NameOrEmailFilter(query string) bson.M {
+	return bson.M{
+		"$or": []bson.M{
+			{"name": containsPattern(query)},
+			{"email": containsPattern(query)},
+		},
+	}
+}
+
+func containsPattern(query string) bson.M {
+	return bson.M{"$regex": regexp.QuoteMeta(truncate(query, MaxSearchQueryLength)), "$options": "i"}
+}
+
+// SearchUsers finds visible users, returning them as the viewer may see them
+func SearchUsers(ctx context.Context, search UserSearch, viewer Viewer) (*UserSearchResult, error) {
+	search.Query = truncate(strings.TrimSpace(search.Query), MaxSearchQueryLength)
+	if search.Sort == "" {
+		search.Sort = SortNewest
+		if search.Query != "" {
+			search.Sort = SortRelevance
+		}
+	}
+	if !containsString(SearchSorts, search.Sort) || (search.Sort == SortRelevance && search.Query == "") {
+		return nil, ErrInvalidSearchSort
+	}
+	if search.Limit <= 0 {
+		search.Limit = DefaultSearchLimit
+	}
+	if search.Limit > MaxSearchLimit {
+		search.Limit = MaxSearchLimit
+	}
+
+	filter := Visible(bson.M{})
+	if search.Query != "" {
+		filter["$text"] = bson.M{"$search": search.Query}
+	}
+	// A hidden college can be neither filtered nor counted by
+	collegeVisible := visibleFieldFilter("college", viewer)
+	if search.College != "" {
+		filter["college"] = search.College
+		filter["$and"] = []bson.M{collegeVisible}
+	}
+	if search.Program != "" {
+		filter["program"] = search.Program
+	}
+	if search.YearOfStudy != "" {
+		filter["yearOfStudy"] = search.YearOfStudy
+	}
+	if search.Skill != "" {
+		filter["skills"] = bson.M{"$regex": "^" + regexp.QuoteMeta(search.Skill) + "$", "$options": "i"}
+	}
+
+	sortKey, direction := searchSortKey(search.Sort)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"sortKey": sortKey}}},
+	}
+	if search.Cursor != "" {
+		after, err := decodeSearchCursor(search.Cursor, search.Sort)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "sortKey", Value: direction}, {Key: "_id", Value: direction}}}},
+		bson.D{{Key: "$limit", Value: search.Limit + 1}},
+		bson.D{{Key: "$project", Value: bson.M{"password": 0}}},
+	)
+
+	cursor, err := config.GetCollection(UsersCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %v", err)
+	}
+	var matches []struct {
+		UserProfile `bson:",inline"`
+		SortKey     bson.RawValue `bson:"sortKey"`
+	}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %v", err)
+	}
+
+	result := &UserSearchResult{Users: []PublicProfile{}}
+	if len(matches) > search.Limit {
+		matches = matches[:search.Limit]
+		last := matches[len(matches)-1]
+		var value interface{}
+		if err := last.SortKey.Unmarshal(&value); err != nil {
+			return nil, fmt.Errorf("failed to build cursor: %v", err)
+		}
+		result.NextCursor, err = encodeSearchCursor(searchCursor{Sort: search.Sort, Value: value, ID: last.ID.Hex()})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, match := range matches {
+		result.Users = append(result.Users, NewPublicProfile(match.UserProfile, viewer))
+	}
+	result.Count = len(result.Users)
+
+	if search.Cursor == "" {
+		result.Facets, err = searchFacets(ctx, filter, collegeVisible)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// searchFacets counts the users matching filter by college, program and year of study
+func searchFacets(ctx context.Context, filter, collegeVisible bson.M) (*SearchFacets, error) {
+	facet := func(field string, extra ...bson.M) bson.A {
+		match := bson.M{field: bson.M{"$nin": bson.A{nil, ""}}}
+		for _, condition := range extra {
+			for key, value := range condition {
+				match[key] = value
+			}
+		}
+		return bson.A{
+			bson.M{"$match": match},
+			bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": MaxFacetValues},
+		}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"college":     facet("college", collegeVisible),
+			"program":     facet("program"),
+			"yearOfStudy": facet("yearOfStudy"),
+		}}},
+	}
+
+	cursor, err := config.GetCollection(UsersCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count facets: %v", err)
+	}
+	var facets []SearchFacets
+	if err := cursor.All(ctx, &facets); err != nil {
+		return nil, fmt.Errorf("failed to decode facets: %v", err)
+	}
+	if len(facets) == 0 {
+		return &SearchFacets{College: []FacetCount{}, Program: []FacetCount{}, YearOfStudy: []FacetCount{}}, nil
+	}
+	return &facets[0], nil
+}
+
+// searchSortKey returns the value users are sorted by, and the direction
+func searchSortKey(sort string) (interface{}, int) {
+	switch sort {
+	case SortRelevance:
+		return bson.M{"$meta": "textScore"}, -1
+	case SortRating:
+		return bson.M{"$ifNull": bson.A{"$stats.rating", 0}}, -1
+	case SortName:
+		return bson.M{"$toLower": bson.M{"$ifNull": bson.A{"$name", ""}}}, 1
+	}
+	return bson.M{"$ifNull": bson.A{"$createdAt", 0}}, -1
+}
+
+// visibleFieldFilter matches users who let the viewer see a private field.
+// Users viewing themselves are not special-cased; it only narrows searches.
+func visibleFieldFilter(field string, viewer Viewer) bson.M {
+	levels := bson.A{VisibleToEveryone}
+	if viewer.LoggedIn {
+		levels = append(levels, VisibleToLoggedIn)
+	}
+	conditions := []bson.M{{"privacy." + field: bson.M{"$in": levels}}}
+	for _, level := range levels {
+		if DefaultPrivacy[field] == level {
+			conditions = append(conditions, bson.M{"privacy." + field: bson.M{"$exists": false}})
+		}
+	}
+	return bson.M{"$or": conditions}
+}
+
+func encodeSearchCursor(cursor searchCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to build cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeSearchCursor turns a cursor into the condition matching the users after it
+func decodeSearchCursor(encoded, sort string) (bson.M, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(cursor.ID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	switch cursor.Value.(type) {
+	case float64, string:
+	default:
+		return nil, ErrInvalidCursor
+	}
+
+	_, direction := searchSortKey(sort)
+	op := "$lt"
+	if direction > 0 {
+		op = "$gt"
+	}
+	return bson.M{"$or": []bson.M{
+		{"sortKey": bson.M{op: cursor.Value}},
+		{"sortKey": cursor.Value, "_id": bson.M{op: id}},
+	}}, nil
+}
+
+// truncate cuts s to at most max runes
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
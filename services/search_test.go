@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDecodeSearchCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	encode := func(cursor searchCursor) string {
+		encoded, err := encodeSearchCursor(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	raw := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		sort    string
+		want    bson.M
+		wantErr error
+	}{
+		{
+			name:   "newest pages backwards",
+			cursor: encode(searchCursor{Sort: SortNewest, Value: float64(1700000000), ID: id.Hex()}),
+			sort:   SortNewest,
+			want: bson.M{"$or": []bson.M{
+				{"sortKey": bson.M{"$lt": float64(1700000000)}},
+				{"sortKey": float64(1700000000), "_id": bson.M{"$lt": id}},
+			}},
+		},
+		{
+			name:   "name pages forwards",
+			cursor: encode(searchCursor{Sort: SortName, Value: "ada", ID: id.Hex()}),
+			sort:   SortName,
+			want: bson.M{"$or": []bson.M{
+				{"sortKey": bson.M{"$gt": "ada"}},
+				{"sortKey": "ada", "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			name:    "not base64",
+			cursor:  "not a cursor!",
+			sort:    SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "not json",
+			cursor:  raw("{"),
+			sort:    SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "other sort",
+			cursor:  encode(searchCursor{Sort: SortRating, Value: 4.5, ID: id.Hex()}),
+			sort:    SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "bad id",
+			cursor:  encode(searchCursor{Sort: SortNewest, Value: float64(1), ID: "nope"}),
+			sort:    SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "operator value",
+			cursor:  raw(`{"s":"newest","v":{"$gt":0},"id":"` + id.Hex() + `"}`),
+			sort:    SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "missing value",
+			cursor:  raw(`{"s":"newest","id":"` + id.Hex() + `"}`),
+			sort:    SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSearchCursor(tt.cursor, tt.sort)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
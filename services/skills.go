@@ -0,0 +1,447 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SkillsCollection is the skills catalog
+const SkillsCollection = "skills"
+
+// Autocomplete limits
+const (
+	DefaultAutocompleteLimit = 10
+	MaxAutocompleteLimit     = 25
+)
+
+var (
+	ErrSkillNotFound = errors.New("skill not found")
+	ErrInvalidSkill  = errors.New("invalid skill")
+	ErrSkillConflict = errors.New("alias already belongs to another skill")
+)
+
+// Skill is a catalog entry. Users' skills are stored by Name; Keys holds the
+// normalized name and aliases it is recognised by, so "JS", "javascript" and
+// "Java Script" all become "JavaScript".
+type Skill struct {
+	ID         string   `bson:"_id" json:"id"`
+	Name       string   `bson:"name" json:"name"`
+	Aliases    []string `bson:"aliases" json:"aliases"`
+	Category   string   `bson:"category" json:"category"`
+	Keys       []string `bson:"keys" json:"-"`
+	MergedInto string   `bson:"mergedInto,omitempty" json:"mergedInto,omitempty"`
+	UpdatedBy  string   `bson:"updatedBy,omitempty" json:"updatedBy,omitempty"`
+	UpdatedAt  int64    `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// SkillMergeResult reports a merge of duplicate skills
+type SkillMergeResult struct {
+	Target       Skill    `json:"target"`
+	Merged       []string `json:"merged"`
+	UsersUpdated int      `json:"usersUpdated"`
+}
+
+// defaultSkills seed the catalog. Admins add to it and merge duplicates through the API.
+var defaultSkills = []Skill{
+	{Name: "JavaScript", Aliases: []string{"JS", "ECMAScript"}, Category: "Programming"},
+	{Name: "TypeScript", Aliases: []string{"TS"}, Category: "Programming"},
+	{Name: "Python", Aliases: []string{"Py", "Python3"}, Category: "Programming"},
+	{Name: "Java", Category: "Programming"},
+	{Name: "C", Category: "Programming"},
+	{Name: "C++", Aliases: []string{"CPP"}, Category: "Programming"},
+	{Name: "C#", Aliases: []string{"CSharp", "C Sharp"}, Category: "Programming"},
+	{Name: "Go", Aliases: []string{"Golang"}, Category: "Programming"},
+	{Name: "Rust", Category: "Programming"},
+	{Name: "SQL", Aliases: []string{"MySQL", "PostgreSQL", "Postgres"}, Category: "Programming"},
+	{Name: "HTML", Aliases: []string{"HTML5"}, Category: "Web Development"},
+	{Name: "CSS", Aliases: []string{"CSS3"}, Category: "Web Development"},
+	{Name: "React", Aliases: []string{"ReactJS", "React.js"}, Category: "Web Development"},
+	{Name: "Node.js", Aliases: []string{"Node", "NodeJS"}, Category: "Web Development"},
+	{Name: "Machine Learning", Aliases: []string{"ML"}, Category: "Data"},
+	{Name: "Data Analysis", Aliases: []string{"Data Analytics"}, Category: "Data"},
+	{Name: "Excel", Aliases: []string{"Microsoft Excel", "MS Excel"}, Category: "Data"},
+	{Name: "UI Design", Aliases: []string{"UI"}, Category: "Design"},
+	{Name: "UX Design", Aliases: []string{"UX", "User Experience"}, Category: "Design"},
+	{Name: "Figma", Category: "Design"},
+	{Name: "Photoshop", Aliases: []string{"Adobe Photoshop", "PS"}, Category: "Design"},
+	{Name: "Video Editing", Category: "Media"},
+	{Name: "Photography", Category: "Media"},
+	{Name: "Calculus", Category: "Academics"},
+	{Name: "Statistics", Aliases: []string{"Stats"}, Category: "Academics"},
+	{Name: "Physics", Category: "Academics"},
+	{Name: "Chemistry", Category: "Academics"},
+	{Name: "Essay Writing", Aliases: []string{"Academic Writing"}, Category: "Academics"},
+	{Name: "Public Speaking", Category: "Communication"},
+	{Name: "English", Category: "Languages"},
+	{Name: "French", Category: "Languages"},
+	{Name: "Spanish", Category: "Languages"},
+	{Name: "Mandarin", Aliases: []string{"Chinese"}, Category: "Languages"},
+	{Name: "Guitar", Category: "Music"},
+	{Name: "Piano", Category: "Music"},
+}
+
+// skillSeparators are ignored when comparing skills
+var skillSeparators = strings.NewReplacer(" ", "", "-", "", "_", "", ".", "", "\t", "")
+
+// SkillKey is the normalized form skills are compared by
+func SkillKey(skill string) string {
+	return skillSeparators.Replace(strings.ToLower(strings.TrimSpace(skill)))
+}
+
+// SeedSkillCatalog adds the default skills that are not in the catalog yet.
+// Skills admins have changed or merged are left alone.
+func SeedSkillCatalog(ctx context.Context) error {
+	collection := config.GetCollection(SkillsCollection)
+	for _, skill := range defaultSkills {
+		skill = newSkill(skill.Name, skill.Aliases, skill.Category)
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": skill.ID}, bson.M{"$setOnInsert": bson.M{
+			"name":     skill.Name,
+			"aliases":  skill.Aliases,
+			"category": skill.Category,
+			"keys":     skill.Keys,
+		}}, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			// An alias was taken by an admin-made skill; theirs wins
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to seed skill %s: %v", skill.Name, err)
+		}
+	}
+	return nil
+}
+
+// NormalizeSkills replaces skills found in the catalog with their canonical
+// name and drops duplicates. Skills the catalog does not know are kept as given.
+func NormalizeSkills(ctx context.Context, skills []string) ([]string, error) {
+	if len(skills) == 0 {
+		return skills, nil
+	}
+	keys := make([]string, 0, len(skills))
+	for _, skill := range skills {
+		keys = append(keys, SkillKey(skill))
+	}
+
+	known, err := lookupSkills(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := make([]string, 0, len(skills))
+	seen := map[string]bool{}
+	for i, skill := range skills {
+		name := strings.TrimSpace(skill)
+		if catalogSkill, ok := known[keys[i]]; ok {
+			name = catalogSkill.Name
+		}
+		if key := SkillKey(name); !seen[key] {
+			seen[key] = true
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized, nil
+}
+
+// lookupSkills finds the catalog skills with any of keys, indexed by each of their keys
+func lookupSkills(ctx context.Context, keys []string) (map[string]Skill, error) {
+	cursor, err := config.GetCollection(SkillsCollection).Find(ctx, bson.M{"keys": bson.M{"$in": keys}, "mergedInto": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up skills: %v", err)
+	}
+	var found []Skill
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode skills: %v", err)
+	}
+	known := map[string]Skill{}
+	for _, skill := range found {
+		for _, key := range skill.Keys {
+			known[key] = skill
+		}
+	}
+	return known, nil
+}
+
+// AutocompleteSkills returns catalog skills whose name or an alias starts with prefix
+func AutocompleteSkills(ctx context.Context, prefix string, limit int) ([]Skill, error) {
+	if limit <= 0 {
+		limit = DefaultAutocompleteLimit
+	}
+	if limit > MaxAutocompleteLimit {
+		limit = MaxAutocompleteLimit
+	}
+	key := SkillKey(truncate(prefix, MaxSkillLength))
+	if key == "" {
+		return []Skill{}, nil
+	}
+
+	cursor, err := config.GetCollection(SkillsCollection).Find(ctx,
+		bson.M{"keys": bson.M{"$regex": "^" + regexp.QuoteMeta(key)}, "mergedInto": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search skills: %v", err)
+	}
+	skills := []Skill{}
+	if err := cursor.All(ctx, &skills); err != nil {
+		return nil, fmt.Errorf("failed to decode skills: %v", err)
+	}
+
+	// Names starting with the prefix first, then shorter names
+	sort.Slice(skills, func(i, j int) bool {
+		a, b := strings.HasPrefix(SkillKey(skills[i].Name), key), strings.HasPrefix(SkillKey(skills[j].Name), key)
+		if a != b {
+			return a
+		}
+		if len(skills[i].Name) != len(skills[j].Name) {
+			return len(skills[i].Name) < len(skills[j].Name)
+		}
+		return skills[i].Name < skills[j].Name
+	})
+	if len(skills) > limit {
+		skills = skills[:limit]
+	}
+	return skills, nil
+}
+
+// ListSkills returns the catalog, merged skills included, by category and name
+func ListSkills(ctx context.Context) ([]Skill, error) {
+	cursor, err := config.GetCollection(SkillsCollection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "category", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch skills: %v", err)
+	}
+	skills := []Skill{}
+	if err := cursor.All(ctx, &skills); err != nil {
+		return nil, fmt.Errorf("failed to decode skills: %v", err)
+	}
+	return skills, nil
+}
+
+// SaveSkill adds a skill to the catalog, or replaces the aliases and
+// category of the skill with the same name
+func SaveSkill(ctx context.Context, name string, aliases []string, category, updatedBy string) (*Skill, error) {
+	name = strings.TrimSpace(name)
+	if SkillKey(name) == "" || len([]rune(name)) > MaxSkillLength {
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidSkill, MaxSkillLength)
+	}
+	for _, alias := range aliases {
+		if SkillKey(alias) == "" || len([]rune(alias)) > MaxSkillLength {
+			return nil, fmt.Errorf("%w: aliases must be 1 to %d characters", ErrInvalidSkill, MaxSkillLength)
+		}
+	}
+
+	skill := newSkill(name, aliases, strings.TrimSpace(category))
+	skill.UpdatedBy = updatedBy
+	skill.UpdatedAt = time.Now().Unix()
+	_, err := config.GetCollection(SkillsCollection).ReplaceOne(ctx, bson.M{"_id": skill.ID}, skill, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrSkillConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save skill: %v", err)
+	}
+	return &skill, nil
+}
+
+// MergeSkills folds duplicate skills into target: their names and aliases
+// become aliases of target, and every user listing one of them is rewritten
+// to list target instead. Running the same merge again finishes rewriting any
+// users an interrupted run missed.
+func MergeSkills(ctx context.Context, sourceIDs []string, targetID, mergedBy string) (*SkillMergeResult, error) {
+	if len(sourceIDs) == 0 {
+		return nil, fmt.Errorf("%w: no skills to merge", ErrInvalidSkill)
+	}
+
+	var target Skill
+	var sources []Skill
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		collection := config.GetCollection(SkillsCollection)
+		if err := collection.FindOne(sessCtx, bson.M{"_id": targetID, "mergedInto": bson.M{"$exists": false}}).Decode(&target); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ErrSkillNotFound
+			}
+			return fmt.Errorf("failed to fetch skill: %v", err)
+		}
+
+		sources = nil
+		for _, id := range sourceIDs {
+			if id == targetID {
+				return fmt.Errorf("%w: cannot merge a skill into itself", ErrInvalidSkill)
+			}
+			var source Skill
+			if err := collection.FindOne(sessCtx, bson.M{"_id": id}).Decode(&source); err != nil {
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return fmt.Errorf("%w: %s", ErrSkillNotFound, id)
+				}
+				return fmt.Errorf("failed to fetch skill: %v", err)
+			}
+			if source.MergedInto != "" && source.MergedInto != targetID {
+				return fmt.Errorf("%w: %s was already merged into %s", ErrInvalidSkill, id, source.MergedInto)
+			}
+			sources = append(sources, source)
+		}
+
+		aliases := append([]string{}, target.Aliases...)
+		keys := append([]string{}, target.Keys...)
+		for _, source := range sources {
+			if source.MergedInto != "" {
+				continue
+			}
+			aliases = append(aliases, source.Name)
+			aliases = append(aliases, source.Aliases...)
+			keys = append(keys, source.Keys...)
+			// Keys move to the target; the source stays as a record of the merge
+			_, err := collection.UpdateOne(sessCtx, bson.M{"_id": source.ID}, bson.M{"$set": bson.M{
+				"mergedInto": targetID,
+				"keys":       []string{},
+				"updatedBy":  mergedBy,
+				"updatedAt":  time.Now().Unix(),
+			}})
+			if err != nil {
+				return fmt.Errorf("failed to merge skill %s: %v", source.ID, err)
+			}
+		}
+		target.Aliases = uniqueStrings(aliases)
+		target.Keys = uniqueStrings(keys)
+		target.UpdatedBy = mergedBy
+		target.UpdatedAt = time.Now().Unix()
+		_, err := collection.UpdateOne(sessCtx, bson.M{"_id": target.ID}, bson.M{"$set": bson.M{
+			"aliases":   target.Aliases,
+			"keys":      target.Keys,
+			"updatedBy": mergedBy,
+			"updatedAt": target.UpdatedAt,
+		}})
+		if err != nil {
+			return fmt.Errorf("failed to update skill %s: %v", target.ID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SkillMergeResult{Target: target, Merged: sourceIDs}
+	result.UsersUpdated, err = rewriteUserSkills(ctx, target.Keys)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// rewriteUserSkills normalizes the skills of every user listing one of keys, in any spelling
+func rewriteUserSkills(ctx context.Context, keys []string) (int, error) {
+	patterns := make(bson.A, 0, len(keys))
+	for _, key := range keys {
+		patterns = append(patterns, primitive.Regex{Pattern: skillKeyPattern(key), Options: "i"})
+	}
+	if len(patterns) == 0 {
+		return 0, nil
+	}
+
+	users := config.GetCollection(UsersCollection)
+	cursor, err := users.Find(ctx, bson.M{"skills": bson.M{"$in": patterns}},
+		options.Find().SetProjection(bson.M{"skills": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find users with merged skills: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var user struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Skills []string           `bson:"skills"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return updated, fmt.Errorf("failed to decode user: %v", err)
+		}
+		skills, err := NormalizeSkills(ctx, user.Skills)
+		if err != nil {
+			return updated, err
+		}
+		if strings.Join(skills, "\x00") == strings.Join(user.Skills, "\x00") {
+			continue
+		}
+
+		var rewritten bool
+		err = config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// Skip users who changed their skills since they were read
+			result, err := users.UpdateOne(sessCtx, bson.M{"_id": user.ID, "skills": user.Skills}, bson.M{"$set": bson.M{"skills": skills}})
+			if err != nil {
+				return fmt.Errorf("failed to rewrite skills of %s: %v", user.ID.Hex(), err)
+			}
+			rewritten = result.ModifiedCount > 0
+			if !rewritten {
+				return nil
+			}
+			return events.Record(sessCtx, events.ProfileUpdated, user.ID, events.ProfileUpdatedData{Fields: []string{"skills"}})
+		})
+		if err != nil {
+			return updated, err
+		}
+		if rewritten {
+			updated++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, fmt.Errorf("failed to read users: %v", err)
+	}
+	log.Printf("🔀 Rewrote skills of %d users", updated)
+	return updated, nil
+}
+
+// skillKeyPattern matches any spelling of a skill key: any case, with
+// separators anywhere between its characters
+func skillKeyPattern(key string) string {
+	var pattern strings.Builder
+	pattern.WriteString(`^\s*`)
+	for i, r := range key {
+		if i > 0 {
+			pattern.WriteString(`[\s._-]*`)
+		}
+		pattern.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	pattern.WriteString(`\s*$`)
+	return pattern.String()
+}
+
+func newSkill(name string, aliases []string, category string) Skill {
+	keys := []string{SkillKey(name)}
+	for _, alias := range aliases {
+		keys = append(keys, SkillKey(alias))
+	}
+	if aliases == nil {
+		aliases = []string{}
+	}
+	return Skill{
+		ID:       SkillKey(name),
+		Name:     name,
+		Aliases:  aliases,
+		Category: category,
+		Keys:     uniqueStrings(keys),
+	}
+}
+
+func uniqueStrings(values []string) []string {
+	unique := make([]string, 0, len(values))
+	seen := map[string]bool{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSkillKey(t *testing.T) {
+	tests := []struct {
+		skill string
+		want  string
+	}{
+		{"Python", "python"},
+		{"  Machine Learning ", "machinelearning"},
+		{"node.js", "nodejs"},
+		{"Front-End_Dev", "frontenddev"},
+		{"C++", "c++"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := SkillKey(tt.skill); got != tt.want {
+			t.Errorf("SkillKey(%q) = %q, want %q", tt.skill, got, tt.want)
+		}
+	}
+}
+
+func TestSkillKeyPattern(t *testing.T) {
+	tests := []struct {
+		key   string
+		skill string
+		want  bool
+	}{
+		{"machinelearning", "Machine Learning", true},
+		{"machinelearning", "machine-learning", true},
+		{"machinelearning", " machine_learning ", true},
+		{"machinelearning", "Machine Learning 101", false},
+		{"nodejs", "Node.js", true},
+		{"c++", "C++", true},
+		{"c++", "Cpp", false},
+		{"go", "Google", false},
+		{"go", "Go", true},
+	}
+	for _, tt := range tests {
+		pattern := regexp.MustCompile("(?i)" + skillKeyPattern(tt.key))
+		if got := pattern.MatchString(tt.skill); got != tt.want {
+			t.Errorf("skillKeyPattern(%q) matching %q = %v, want %v", tt.key, tt.skill, got, tt.want)
+		}
+		// Anything the pattern finds must be the same skill by key
+		if pattern.MatchString(tt.skill) && SkillKey(tt.skill) != tt.key {
+			t.Errorf("skillKeyPattern(%q) matched %q with key %q", tt.key, tt.skill, SkillKey(tt.skill))
+		}
+	}
+}
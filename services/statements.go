@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+
+	"github.com/ElioCloud/shared-models/models"
+	"github.com/go-pdf/fpdf"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Statement is a user's credit activity over a period
+type Statement struct {
+	UserID         primitive.ObjectID `json:"userId"`
+	From           time.Time          `json:"from"`
+	To             time.Time          `json:"to"` // Exclusive
+	OpeningBalance int                `json:"openingBalance"`
+	ClosingBalance int                `json:"closingBalance"`
+	TotalCredited  int                `json:"totalCredited"`
+	TotalDebited   int                `json:"totalDebited"`
+	Entries        []LedgerEntry      `json:"entries"`
+}
+
+var statementCSVHeader = []string{"userId", "email", "date", "type", "description", "reference", "amount", "balanceAfter"}
+
+// BuildStatement collects a user's ledger entries in [from, to) along with the
+// opening and closing balances
+func BuildStatement(ctx context.Context, userID primitive.ObjectID, from, to time.Time) (*Statement, error) {
+	statement := Statement{UserID: userID, From: from, To: to, Entries: []LedgerEntry{}}
+
+	var err error
+	if statement.OpeningBalance, err = balanceAt(ctx, userID, from); err != nil {
+		return nil, err
+	}
+	if statement.ClosingBalance, err = balanceAt(ctx, userID, to); err != nil {
+		return nil, err
+	}
+
+	cursor, err := config.GetCollection(LedgerCollection).Find(ctx, periodFilter(userID, from, to), options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ledger entries: %v", err)
+	}
+	if err := cursor.All(ctx, &statement.Entries); err != nil {
+		return nil, fmt.Errorf("failed to decode ledger entries: %v", err)
+	}
+
+	for _, entry := range statement.Entries {
+		if entry.Amount > 0 {
+			statement.TotalCredited += entry.Amount
+		} else {
+			statement.TotalDebited += -entry.Amount
+		}
+	}
+
+	return &statement, nil
+}
+
+// WriteStatementCSV renders a statement as CSV, with opening and closing balance rows around the entries
+func WriteStatementCSV(w io.Writer, user models.User, statement *Statement) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(statementCSVHeader); err != nil {
+		return err
+	}
+	if err := writeStatementRows(writer, user, statement.From, statement.OpeningBalance, statement.Entries, statement.To, statement.ClosingBalance); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteStatementPDF renders a statement as a one-table PDF document
+func WriteStatementPDF(w io.Writer, user models.User, statement *Statement) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle("TradeMinutes Credit Statement", false)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.Cell(0, 10, "TradeMinutes Credit Statement")
+	pdf.Ln(12)
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.Cell(0, 6, tr(fmt.Sprintf("%s <%s>", user.Name, user.Email)))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Period: %s to %s", statement.From.Format("2006-01-02"), statement.To.AddDate(0, 0, -1).Format("2006-01-02")))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Opening balance: %d credits", statement.OpeningBalance))
+	pdf.Ln(10)
+
+	widths := []float64{28, 28, 74, 30, 30}
+	pdf.SetFont("Helvetica", "B", 10)
+	for i, heading := range []string{"Date", "Type", "Description", "Amount", "Balance"} {
+		pdf.CellFormat(widths[i], 7, heading, "1", 0, "L", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, entry := range statement.Entries {
+		description := []rune(entryDescription(entry))
+		if len(description) > 45 {
+			description = append(description[:42], []rune("...")...)
+		}
+		pdf.CellFormat(widths[0], 6, time.Unix(entry.CreatedAt, 0).UTC().Format("2006-01-02"), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 6, entry.Type, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[2], 6, tr(string(description)), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[3], 6, fmt.Sprintf("%+d", entry.Amount), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 6, strconv.Itoa(entry.BalanceAfter), "1", 0, "R", false, 0, "")
+		pdf.Ln(-1)
+	}
+	if len(statement.Entries) == 0 {
+		pdf.CellFormat(0, 6, "No credit activity in this period", "1", 0, "C", false, 0, "")
+		pdf.Ln(-1)
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.Cell(0, 6, fmt.Sprintf("Total credited: %d   Total debited: %d", statement.TotalCredited, statement.TotalDebited))
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.Cell(0, 6, fmt.Sprintf("Closing balance: %d credits", statement.ClosingBalance))
+
+	return pdf.Output(w)
+}
+
+// ExportStatementsCSV streams every user's statement for [from, to) as one CSV.
+// Users and their entries are read through cursors, so memory use stays flat
+// however many users there are; flush is called after each user.
+func ExportStatementsCSV(ctx context.Context, w io.Writer, from, to time.Time, flush func()) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(statementCSVHeader); err != nil {
+		return err
+	}
+
+	users, err := config.GetCollection(UsersCollection).Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetProjection(bson.M{"name": 1, "email": 1, "credits": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to fetch users: %v", err)
+	}
+	defer users.Close(ctx)
+
+	for users.Next(ctx) {
+		var user models.User
+		if err := users.Decode(&user); err != nil {
+			return fmt.Errorf("failed to decode user: %v", err)
+		}
+
+		opening, err := balanceAt(ctx, user.ID, from)
+		if err != nil {
+			return err
+		}
+		closing, err := balanceAt(ctx, user.ID, to)
+		if err != nil {
+			return err
+		}
+
+		entries, err := config.GetCollection(LedgerCollection).Find(ctx, periodFilter(user.ID, from, to), options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			return fmt.Errorf("failed to fetch ledger entries: %v", err)
+		}
+
+		if err := writer.Write(balanceRow(user, from, "opening_balance", opening)); err != nil {
+			entries.Close(ctx)
+			return err
+		}
+		for entries.Next(ctx) {
+			var entry LedgerEntry
+			if err := entries.Decode(&entry); err != nil {
+				entries.Close(ctx)
+				return fmt.Errorf("failed to decode ledger entry: %v", err)
+			}
+			if err := writer.Write(entryRow(user, entry)); err != nil {
+				entries.Close(ctx)
+				return err
+			}
+		}
+		entries.Close(ctx)
+		if err := writer.Write(balanceRow(user, to, "closing_balance", closing)); err != nil {
+			return err
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		if flush != nil {
+			flush()
+		}
+	}
+
+	return users.Err()
+}
+
+func writeStatementRows(writer *csv.Writer, user models.User, from time.Time, opening int, entries []LedgerEntry, to time.Time, closing int) error {
+	if err := writer.Write(balanceRow(user, from, "opening_balance", opening)); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writer.Write(entryRow(user, entry)); err != nil {
+			return err
+		}
+	}
+	return writer.Write(balanceRow(user, to, "closing_balance", closing))
+}
+
+func entryRow(user models.User, entry LedgerEntry) []string {
+	return []string{
+		user.ID.Hex(),
+		csvText(user.Email),
+		time.Unix(entry.CreatedAt, 0).UTC().Format(time.RFC3339),
+		csvText(entry.Type),
+		csvText(entryDescription(entry)),
+		csvText(entry.Reference),
+		strconv.Itoa(entry.Amount),
+		strconv.Itoa(entry.BalanceAfter),
+	}
+}
+
+func balanceRow(user models.User, at time.Time, kind string, balance int) []string {
+	return []string{user.ID.Hex(), csvText(user.Email), at.UTC().Format(time.RFC3339), kind, "", "", "", strconv.Itoa(balance)}
+}
+
+// csvText keeps a free-text cell from being run as a formula by spreadsheet
+// apps, by prefixing values that start like one with a quote
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func entryDescription(entry LedgerEntry) string {
+	switch {
+	case entry.Note != "":
+		return entry.Note
+	case entry.ReasonCode != "":
+		return entry.ReasonCode
+	default:
+		return entry.Type
+	}
+}
+
+func periodFilter(userID primitive.ObjectID, from, to time.Time) bson.M {
+	return bson.M{
+		"userId":    userID,
+		"createdAt": bson.M{"$gte": from.Unix(), "$lt": to.Unix()},
+	}
+}
+
+// balanceAt returns a user's balance at instant t, from the last ledger entry
+// before t. Balances that predate the ledger are worked out from the first
+// entry after t, or the current balance if there has been no activity since.
+func balanceAt(ctx context.Context, userID primitive.ObjectID, t time.Time) (int, error) {
+	ledger := config.GetCollection(LedgerCollection)
+
+	var entry LedgerEntry
+	err := ledger.FindOne(ctx,
+		bson.M{"userId": userID, "createdAt": bson.M{"$lt": t.Unix()}},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}),
+	).Decode(&entry)
+	if err == nil {
+		return entry.BalanceAfter, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, fmt.Errorf("failed to fetch ledger entry: %v", err)
+	}
+
+	err = ledger.FindOne(ctx,
+		bson.M{"userId": userID, "createdAt": bson.M{"$gte": t.Unix()}},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}),
+	).Decode(&entry)
+	if err == nil {
+		return entry.BalanceAfter - entry.Amount, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, fmt.Errorf("failed to fetch ledger entry: %v", err)
+	}
+
+	var user models.User
+	if err := config.GetCollection(UsersCollection).FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, ErrUserNotFound
+		}
+		return 0, fmt.Errorf("failed to fetch user: %v", err)
+	}
+	return user.Credits, nil
+}
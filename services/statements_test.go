@@ -0,0 +1,74 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEntryRow(t *testing.T) {
+	user := models.User{ID: primitive.NewObjectID(), Email: "ada@example.com"}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("EST", -5*3600))
+
+	tests := []struct {
+		name  string
+		user  models.User
+		entry LedgerEntry
+		want  []string
+	}{
+		{
+			name:  "note describes the entry",
+			user:  user,
+			entry: LedgerEntry{Type: "adjustment", Amount: -5, BalanceAfter: 15, Note: "Refund", Reference: "adj-1", CreatedAt: at.Unix()},
+			want:  []string{user.ID.Hex(), "ada@example.com", "2024-03-01T17:30:00Z", "adjustment", "Refund", "adj-1", "-5", "15"},
+		},
+		{
+			name:  "reason code without a note",
+			user:  user,
+			entry: LedgerEntry{Type: "adjustment", Amount: 3, BalanceAfter: 18, ReasonCode: "goodwill", CreatedAt: at.Unix()},
+			want:  []string{user.ID.Hex(), "ada@example.com", "2024-03-01T17:30:00Z", "adjustment", "goodwill", "", "3", "18"},
+		},
+		{
+			name:  "type without a note or reason",
+			user:  user,
+			entry: LedgerEntry{Type: "signup_bonus", Amount: 10, BalanceAfter: 10, CreatedAt: at.Unix()},
+			want:  []string{user.ID.Hex(), "ada@example.com", "2024-03-01T17:30:00Z", "signup_bonus", "signup_bonus", "", "10", "10"},
+		},
+		{
+			name:  "formulas escaped",
+			user:  models.User{ID: user.ID, Email: "=cmd@example.com"},
+			entry: LedgerEntry{Type: "adjustment", Amount: -1, BalanceAfter: 0, Note: "=HYPERLINK(\"x\")", Reference: "@ref", CreatedAt: at.Unix()},
+			want:  []string{user.ID.Hex(), "'=cmd@example.com", "2024-03-01T17:30:00Z", "adjustment", "'=HYPERLINK(\"x\")", "'@ref", "-1", "0"},
+		},
+	}
+	for _, tt := range tests {
+		if got := entryRow(tt.user, tt.entry); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"a=b", "a=b"},
+		{"=SUM(A1)", "'=SUM(A1)"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@user", "'@user"},
+		{"\tx", "'\tx"},
+		{"\rx", "'\rx"},
+	}
+	for _, tt := range tests {
+		if got := csvText(tt.value); got != tt.want {
+			t.Errorf("csvText(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
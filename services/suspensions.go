@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const SuspensionsCollection = "suspensions"
+
+// SuspensionReasonCodes lists the reason codes an admin may give for a suspension
+var SuspensionReasonCodes = []string{
+	"spam",
+	"harassment",
+	"fraud",
+	"no_show",
+	"impersonation",
+	"terms_violation",
+	"other",
+}
+
+// Appeal statuses. Granting an appeal lifts the suspension; upholding it keeps it.
+const (
+	AppealNone        = "none"
+	AppealPending     = "pending"
+	AppealUnderReview = "under_review"
+	AppealUpheld      = "upheld"
+	AppealGranted     = "granted"
+)
+
+// AppealStatuses lists every appeal status an admin may set
+var AppealStatuses = []string{AppealNone, AppealPending, AppealUnderReview, AppealUpheld, AppealGranted}
+
+var (
+	ErrInvalidSuspension   = errors.New("a suspension needs a positive duration or must be permanent")
+	ErrInvalidAppealStatus = errors.New("invalid appeal status")
+	ErrSuspensionNotFound  = errors.New("suspension not found")
+	ErrSuspensionNotActive = errors.New("suspension is no longer active")
+	ErrUserSuspended       = errors.New("account is suspended")
+)
+
+// Suspension blocks a user from logging in, using their token and moving
+// credits, either until ExpiresAt or, for a ban, until an admin lifts it
+type Suspension struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	ReasonCode  string             `bson:"reasonCode" json:"reasonCode"`
+	Note        string             `bson:"note,omitempty" json:"note,omitempty"`
+	Permanent   bool               `bson:"permanent" json:"permanent"`
+	ExpiresAt   int64              `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"` // Unset for permanent bans
+	SuspendedBy string             `bson:"suspendedBy" json:"suspendedBy"`
+	CreatedAt   int64              `bson:"createdAt" json:"createdAt"`
+	LiftedAt    int64              `bson:"liftedAt,omitempty" json:"liftedAt,omitempty"`
+	LiftedBy    string             `bson:"liftedBy,omitempty" json:"liftedBy,omitempty"`
+	// Appeals are handled outside the service; admins record their progress here
+	AppealStatus    string `bson:"appealStatus" json:"appealStatus"`
+	AppealNote      string `bson:"appealNote,omitempty" json:"appealNote,omitempty"`
+	AppealUpdatedBy string `bson:"appealUpdatedBy,omitempty" json:"appealUpdatedBy,omitempty"`
+	AppealUpdatedAt int64  `bson:"appealUpdatedAt,omitempty" json:"appealUpdatedAt,omitempty"`
+}
+
+// Active reports whether the suspension still applies
+func (s *Suspension) Active() bool {
+	return s.LiftedAt == 0 && (s.Permanent || s.ExpiresAt > time.Now().Unix())
+}
+
+// Message explains the suspension to the suspended user
+func (s *Suspension) Message() string {
+	if s.Permanent {
+		return fmt.Sprintf("Account is permanently suspended (reason: %s)", s.ReasonCode)
+	}
+	return fmt.Sprintf("Account is suspended until %s (reason: %s)",
+		time.Unix(s.ExpiresAt, 0).UTC().Format(time.RFC3339), s.ReasonCode)
+}
+
+// activeSuspension adds the conditions matching suspensions that still apply to a suspensions filter
+func activeSuspension(filter bson.M) bson.M {
+	filter["liftedAt"] = bson.M{"$exists": false}
+	filter["$or"] = []bson.M{
+		{"permanent": true},
+		{"expiresAt": bson.M{"$gt": time.Now().Unix()}},
+	}
+	return filter
+}
+
+// SuspendUser suspends a user for duration, or permanently if permanent is
+// set. A new suspension replaces any that is still active.
+func SuspendUser(ctx context.Context, userID primitive.ObjectID, reasonCode, note string, duration time.Duration, permanent bool, suspendedBy string) (*Suspension, error) {
+	if !containsString(SuspensionReasonCodes, reasonCode) {
+		return nil, ErrInvalidReasonCode
+	}
+	if !permanent && duration <= 0 {
+		return nil, ErrInvalidSuspension
+	}
+
+	now := time.Now()
+	suspension := Suspension{
+		ID:           primitive.NewObjectID(),
+		UserID:       userID,
+		ReasonCode:   reasonCode,
+		Note:         note,
+		Permanent:    permanent,
+		SuspendedBy:  suspendedBy,
+		CreatedAt:    now.Unix(),
+		AppealStatus: AppealNone,
+	}
+	if !permanent {
+		suspension.ExpiresAt = now.Add(duration).Unix()
+	}
+
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		count, err := config.GetCollection(UsersCollection).CountDocuments(sessCtx, NotDeleted(bson.M{"_id": userID}))
+		if err != nil {
+			return fmt.Errorf("failed to look up user: %v", err)
+		}
+		if count == 0 {
+			return ErrUserNotFound
+		}
+
+		suspensions := config.GetCollection(SuspensionsCollection)
+		_, err = suspensions.UpdateMany(sessCtx, activeSuspension(bson.M{"userId": userID}), bson.M{"$set": bson.M{
+			"liftedAt": suspension.CreatedAt,
+			"liftedBy": suspendedBy,
+		}})
+		if err != nil {
+			return fmt.Errorf("failed to replace active suspension: %v", err)
+		}
+		if _, err := suspensions.InsertOne(sessCtx, suspension); err != nil {
+			return fmt.Errorf("failed to create suspension: %v", err)
+		}
+		return events.Record(sessCtx, events.UserSuspended, userID, events.UserSuspendedData{
+			SuspensionID: suspension.ID.Hex(),
+			ReasonCode:   reasonCode,
+			ExpiresAt:    suspension.ExpiresAt,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &suspension, nil
+}
+
+// LiftSuspension ends an active suspension early
+func LiftSuspension(ctx context.Context, suspensionID primitive.ObjectID, liftedBy string) (*Suspension, error) {
+	var suspension *Suspension
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var err error
+		suspension, err = liftSuspension(sessCtx, suspensionID, liftedBy, nil, "lifted")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return suspension, nil
+}
+
+// UpdateAppeal records the progress of an appeal against a suspension.
+// Granting the appeal also lifts the suspension if it is still active.
+func UpdateAppeal(ctx context.Context, suspensionID primitive.ObjectID, appealStatus, note, updatedBy string) (*Suspension, error) {
+	if !containsString(AppealStatuses, appealStatus) {
+		return nil, ErrInvalidAppealStatus
+	}
+
+	update := bson.M{
+		"appealStatus":    appealStatus,
+		"appealNote":      note,
+		"appealUpdatedBy": updatedBy,
+		"appealUpdatedAt": time.Now().Unix(),
+	}
+
+	var suspension *Suspension
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if appealStatus == AppealGranted {
+			var err error
+			suspension, err = liftSuspension(sessCtx, suspensionID, updatedBy, update, "appeal_granted")
+			if !errors.Is(err, ErrSuspensionNotActive) {
+				return err
+			}
+			// Already over; the appeal is still recorded as granted
+		}
+
+		var updated Suspension
+		err := config.GetCollection(SuspensionsCollection).FindOneAndUpdate(sessCtx,
+			bson.M{"_id": suspensionID},
+			bson.M{"$set": update},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrSuspensionNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update appeal: %v", err)
+		}
+		suspension = &updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return suspension, nil
+}
+
+// ActiveSuspension returns the suspension currently applying to a user, or nil if there is none
+func ActiveSuspension(ctx context.Context, userID primitive.ObjectID) (*Suspension, error) {
+	var suspension Suspension
+	err := config.GetCollection(SuspensionsCollection).FindOne(ctx, activeSuspension(bson.M{"userId": userID}),
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})).Decode(&suspension)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch suspension: %v", err)
+	}
+	return &suspension, nil
+}
+
+// ActiveSuspensionByEmail is ActiveSuspension for the user with the given
+// email, for checks made with only a token's claims. Unknown emails have none.
+func ActiveSuspensionByEmail(ctx context.Context, email string) (*Suspension, error) {
+	var user struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err := config.GetCollection(UsersCollection).FindOne(ctx, NotDeleted(bson.M{"email": email}),
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
+	return ActiveSuspension(ctx, user.ID)
+}
+
+// suspendedUserIDs returns every user with a suspension that still applies
+func suspendedUserIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	values, err := config.GetCollection(SuspensionsCollection).Distinct(ctx, "userId", activeSuspension(bson.M{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch suspended users: %v", err)
+	}
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ListSuspensions returns a user's suspensions, newest first
+func ListSuspensions(ctx context.Context, userID primitive.ObjectID) ([]Suspension, error) {
+	cursor, err := config.GetCollection(SuspensionsCollection).Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch suspensions: %v", err)
+	}
+	suspensions := []Suspension{}
+	if err := cursor.All(ctx, &suspensions); err != nil {
+		return nil, fmt.Errorf("failed to decode suspensions: %v", err)
+	}
+	return suspensions, nil
+}
+
+// liftSuspension marks an active suspension lifted, along with any extra
+// fields to set, and records why
+func liftSuspension(sessCtx mongo.SessionContext, suspensionID primitive.ObjectID, liftedBy string, extra bson.M, reason string) (*Suspension, error) {
+	set := bson.M{"liftedAt": time.Now().Unix(), "liftedBy": liftedBy}
+	for key, value := range extra {
+		set[key] = value
+	}
+
+	collection := config.GetCollection(SuspensionsCollection)
+	var suspension Suspension
+	err := collection.FindOneAndUpdate(sessCtx, activeSuspension(bson.M{"_id": suspensionID}), bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&suspension)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, countErr := collection.CountDocuments(sessCtx, bson.M{"_id": suspensionID})
+		if countErr != nil {
+			return nil, fmt.Errorf("failed to look up suspension: %v", countErr)
+		}
+		if count == 0 {
+			return nil, ErrSuspensionNotFound
+		}
+		return nil, ErrSuspensionNotActive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lift suspension: %v", err)
+	}
+
+	err = events.Record(sessCtx, events.UserUnsuspended, suspension.UserID, events.UserUnsuspendedData{
+		SuspensionID: suspension.ID.Hex(),
+		Reason:       reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &suspension, nil
+}
+
+// ensureNotSuspended refuses credit movements a suspended user would make
+func ensureNotSuspended(ctx context.Context, userID primitive.ObjectID) error {
+	count, err := config.GetCollection(SuspensionsCollection).CountDocuments(ctx, activeSuspension(bson.M{"userId": userID}))
+	if err != nil {
+		return fmt.Errorf("failed to check suspension: %v", err)
+	}
+	if count > 0 {
+		return ErrUserSuspended
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
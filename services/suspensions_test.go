@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSuspensionActive(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		suspension Suspension
+		want       bool
+	}{
+		{"running", Suspension{ExpiresAt: now.Add(time.Hour).Unix()}, true},
+		{"expired", Suspension{ExpiresAt: now.Add(-time.Second).Unix()}, false},
+		{"lifted early", Suspension{ExpiresAt: now.Add(time.Hour).Unix(), LiftedAt: now.Unix()}, false},
+		{"permanent ban", Suspension{Permanent: true}, true},
+		{"permanent ban lifted", Suspension{Permanent: true, LiftedAt: now.Unix()}, false},
+		{"no expiry and not permanent", Suspension{}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.suspension.Active(); got != tt.want {
+			t.Errorf("%s: Active() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSuspensionMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		suspension Suspension
+		want       string
+	}{
+		{
+			name:       "until expiry, in UTC",
+			suspension: Suspension{ReasonCode: "spam", ExpiresAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Unix()},
+			want:       "Account is suspended until 2024-03-01T12:00:00Z (reason: spam)",
+		},
+		{
+			name:       "permanent ban",
+			suspension: Suspension{ReasonCode: "fraud", Permanent: true},
+			want:       "Account is permanently suspended (reason: fraud)",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.suspension.Message(); got != tt.want {
+			t.Errorf("%s: Message() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestActiveSuspensionFilter(t *testing.T) {
+	userID := primitive.NewObjectID()
+	filter := activeSuspension(bson.M{"userId": userID})
+
+	if filter["userId"] != userID {
+		t.Errorf("userId condition lost: %v", filter)
+	}
+	if _, ok := filter["liftedAt"]; !ok {
+		t.Errorf("lifted suspensions not excluded: %v", filter)
+	}
+	or, ok := filter["$or"].([]bson.M)
+	if !ok || len(or) != 2 || or[0]["permanent"] != true {
+		t.Fatalf("want permanent bans or unexpired suspensions, got %v", filter["$or"])
+	}
+	expiresAt, ok := or[1]["expiresAt"].(bson.M)["$gt"].(int64)
+	if !ok || expiresAt < time.Now().Add(-time.Minute).Unix() || expiresAt > time.Now().Unix() {
+		t.Errorf("want suspensions expiring after now, got %v", or[1])
+	}
+}
+
+// These are rejected before the database is touched
+func TestSuspendUserRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		reasonCode string
+		duration   time.Duration
+		permanent  bool
+		want       error
+	}{
+		{"unknown reason", "rude", time.Hour, false, ErrInvalidReasonCode},
+		{"no duration", "spam", 0, false, ErrInvalidSuspension},
+		{"negative duration", "spam", -time.Hour, false, ErrInvalidSuspension},
+		{"permanent ban with an unknown reason", "", 0, true, ErrInvalidReasonCode},
+	}
+	for _, tt := range tests {
+		_, err := SuspendUser(context.Background(), primitive.NewObjectID(), tt.reasonCode, "", tt.duration, tt.permanent, "admin@example.com")
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestUpdateAppealRejectsUnknownStatus(t *testing.T) {
+	for _, status := range []string{"", "approved", "GRANTED"} {
+		_, err := UpdateAppeal(context.Background(), primitive.NewObjectID(), status, "", "admin@example.com")
+		if !errors.Is(err, ErrInvalidAppealStatus) {
+			t.Errorf("UpdateAppeal(%q) = %v, want %v", status, err, ErrInvalidAppealStatus)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"trademinutes-user/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrSelfTransfer = errors.New("cannot transfer credits to the same user")
+
+// Transfer moves credits between two users in one transaction: a transfer_out
+// debit on the sender and a transfer_in credit on the recipient, each pointing
+// at the other through Reference when no reference is given. The recipient's
+// lot expires no later than the earliest-expiring lot the credits came from,
+// so transfers cannot extend credit lifetimes. Retrying with the same
+// idempotencyKey returns the entries already posted.
+func Transfer(ctx context.Context, fromID, toID primitive.ObjectID, amount int, reason, reference, idempotencyKey string) (*LedgerEntry, *LedgerEntry, error) {
+	if amount <= 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+	if fromID == toID {
+		return nil, nil, ErrSelfTransfer
+	}
+
+	outKey, inKey := "", ""
+	if idempotencyKey != "" {
+		outKey = "transfer:" + fromID.Hex() + ":" + idempotencyKey + ":out"
+		inKey = "transfer:" + fromID.Hex() + ":" + idempotencyKey + ":in"
+		if out, in, err := existingTransfer(ctx, outKey, inKey); err != nil || out != nil {
+			return out, in, err
+		}
+	}
+
+	var out, in *LedgerEntry
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		// The recipient must exist before anything is taken from the sender
+		count, err := config.GetCollection(UsersCollection).CountDocuments(sessCtx, bson.M{"_id": toID})
+		if err != nil {
+			return fmt.Errorf("failed to look up recipient: %v", err)
+		}
+		if count == 0 {
+			return ErrUserNotFound
+		}
+		if err := ensureNotSuspended(sessCtx, fromID); err != nil {
+			return err
+		}
+		if err := ensureNotSuspended(sessCtx, toID); err != nil {
+			return err
+		}
+
+		outRef, inRef := reference, reference
+		if reference == "" {
+			outRef, inRef = toID.Hex(), fromID.Hex()
+		}
+
+		out, err = applyDebit(sessCtx, LedgerEntry{
+			UserID:         fromID,
+			Type:           EntryTypeTransferOut,
+			Amount:         -amount,
+			Note:           reason,
+			Reference:      outRef,
+			IdempotencyKey: outKey,
+		})
+		if err != nil {
+			return err
+		}
+
+		expiresAt, err := earliestExpiry(sessCtx, out.Lots)
+		if err != nil {
+			return err
+		}
+
+		in, _, err = applyCredit(sessCtx, LedgerEntry{
+			UserID:         toID,
+			Type:           EntryTypeTransferIn,
+			Amount:         amount,
+			Note:           reason,
+			Reference:      inRef,
+			IdempotencyKey: inKey,
+		}, LotSourceTransfer, expiresAt)
+		return err
+	})
+	if mongo.IsDuplicateKeyError(err) && idempotencyKey != "" {
+		return existingTransfer(ctx, outKey, inKey)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, in, nil
+}
+
+// earliestExpiry returns the soonest expiry among the lots a debit consumed,
+// or 0 if none of them expire
+func earliestExpiry(sessCtx mongo.SessionContext, allocations []LotAllocation) (int64, error) {
+	if len(allocations) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(allocations))
+	for _, allocation := range allocations {
+		ids = append(ids, allocation.LotID)
+	}
+
+	cursor, err := config.GetCollection(LotsCollection).Find(sessCtx, bson.M{"_id": bson.M{"$in": ids}, "expiresAt": bson.M{"$gt": 0}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch credit lots: %v", err)
+	}
+	var lots []CreditLot
+	if err := cursor.All(sessCtx, &lots); err != nil {
+		return 0, fmt.Errorf("failed to decode credit lots: %v", err)
+	}
+
+	var earliest int64
+	for _, lot := range lots {
+		if earliest == 0 || lot.ExpiresAt < earliest {
+			earliest = lot.ExpiresAt
+		}
+	}
+	return earliest, nil
+}
+
+func existingTransfer(ctx context.Context, outKey, inKey string) (*LedgerEntry, *LedgerEntry, error) {
+	out, err := entryByIdempotencyKey(ctx, outKey)
+	if err != nil || out == nil {
+		return nil, nil, err
+	}
+	in, err := entryByIdempotencyKey(ctx, inKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, in, nil
+}
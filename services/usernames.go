@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UsernamesCollection holds every handle ever taken, keyed by its lowercase
+// form. Handles a user has moved away from stay there and redirect to their
+// current one, so old profile links keep working and nobody else can take them.
+const UsernamesCollection = "usernames"
+
+// Username length limits
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 30
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// reservedUsernames would be confused with the service, its staff or its routes
+var reservedUsernames = []string{
+	"admin", "administrator", "api", "auth", "help", "internal", "login", "logout",
+	"me", "mod", "moderator", "null", "official", "profile", "register", "root",
+	"security", "settings", "signup", "staff", "support", "system", "trademinutes",
+	"undefined", "user", "username", "users",
+}
+
+// blockedUsernameWords may not appear anywhere in a username, even spelled
+// with digits for letters (e.g. "sh1t")
+var blockedUsernameWords = []string{
+	"asshole", "bitch", "cunt", "fuck", "nazi", "nigg", "rape", "shit", "slut", "whore",
+}
+
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "_", "")
+
+var (
+	ErrInvalidUsername  = fmt.Errorf("username must be %d to %d letters, digits or underscores, starting with a letter", MinUsernameLength, MaxUsernameLength)
+	ErrUsernameReserved = errors.New("username is not allowed")
+	ErrUsernameTaken    = errors.New("username is taken")
+	ErrUsernameCooldown = errors.New("username was changed too recently")
+	ErrUsernameNotFound = errors.New("username not found")
+)
+
+// Username is a handle a user holds or used to hold
+type Username struct {
+	Key       string             `bson:"_id" json:"-"` // Lowercase, for case-insensitive uniqueness
+	Username  string             `bson:"username" json:"username"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Current   bool               `bson:"current" json:"current"`
+	CreatedAt int64              `bson:"createdAt" json:"createdAt"`
+	RetiredAt int64              `bson:"retiredAt,omitempty" json:"retiredAt,omitempty"`
+}
+
+// UserProfile is a stored user together with the fields this service added
+// to it: their username, privacy settings, skill levels and place
+type UserProfile struct {
+	models.User `bson:",inline"`
+	Username    string          `bson:"username,omitempty" json:"username,omitempty"`
+	Privacy     PrivacySettings `bson:"privacy,omitempty" json:"-"`
+	SkillLevels []SkillLevel    `bson:"skillLevels,omitempty" json:"skillLevels,omitempty"`
+	Place       *Place          `bson:"place,omitempty" json:"place,omitempty"`
+	LastLoginAt int64           `bson:"lastLoginAt,omitempty" json:"-"`
+}
+
+// ValidateUsername checks a username's format and wording, case-insensitively
+func ValidateUsername(username string) error {
+	key := strings.ToLower(username)
+	if len(key) < MinUsernameLength || len(key) > MaxUsernameLength || !usernamePattern.MatchString(key) {
+		return ErrInvalidUsername
+	}
+	if containsString(reservedUsernames, key) {
+		return ErrUsernameReserved
+	}
+	normalized := leetReplacer.Replace(key)
+	for _, word := range blockedUsernameWords {
+		if strings.Contains(normalized, word) {
+			return ErrUsernameReserved
+		}
+	}
+	return nil
+}
+
+// CheckUsername reports why a user could not take a username, or nil if they
+// can. Handles the user held before are available to them again.
+func CheckUsername(ctx context.Context, username string, userID primitive.ObjectID) error {
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+	var existing Username
+	err := config.GetCollection(UsernamesCollection).FindOne(ctx, bson.M{"_id": strings.ToLower(username)}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up username: %v", err)
+	}
+	if existing.UserID != userID {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+// UsernameCooldownEnds returns when a user may next change their username,
+// or the zero time if they may now
+func UsernameCooldownEnds(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	var user struct {
+		Username          string `bson:"username"`
+		UsernameChangedAt int64  `bson:"usernameChangedAt"`
+	}
+	err := config.GetCollection(UsersCollection).FindOne(ctx, NotDeleted(bson.M{"_id": userID}),
+		options.FindOne().SetProjection(bson.M{"username": 1, "usernameChangedAt": 1})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, ErrUserNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to fetch user: %v", err)
+	}
+	// Picking a first username is not a change
+	if user.Username == "" || user.UsernameChangedAt == 0 {
+		return time.Time{}, nil
+	}
+	ends := time.Unix(user.UsernameChangedAt, 0).Add(config.UsernameChangeCooldown())
+	if !ends.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return ends, nil
+}
+
+// SetUsername gives a user a new username. Their previous one keeps
+// redirecting to it. A user with a username may change it once per
+// USERNAME_CHANGE_COOLDOWN, though changing only its letter case is always allowed.
+func SetUsername(ctx context.Context, userID primitive.ObjectID, username string) (*Username, error) {
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(username)
+
+	var result Username
+	err := config.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var user struct {
+			Username          string `bson:"username"`
+			UsernameChangedAt int64  `bson:"usernameChangedAt"`
+		}
+		err := config.GetCollection(UsersCollection).FindOne(sessCtx, NotDeleted(bson.M{"_id": userID}),
+			options.FindOne().SetProjection(bson.M{"username": 1, "usernameChangedAt": 1})).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %v", err)
+		}
+
+		now := time.Now()
+		recasing := strings.EqualFold(user.Username, username)
+		if user.Username != "" && !recasing &&
+			now.Before(time.Unix(user.UsernameChangedAt, 0).Add(config.UsernameChangeCooldown())) {
+			return ErrUsernameCooldown
+		}
+
+		usernames := config.GetCollection(UsernamesCollection)
+		var existing Username
+		err = usernames.FindOne(sessCtx, bson.M{"_id": key}).Decode(&existing)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			result = Username{Key: key, Username: username, UserID: userID, Current: true, CreatedAt: now.Unix()}
+			if _, err := usernames.InsertOne(sessCtx, result); err != nil {
+				return fmt.Errorf("failed to claim username: %w", err)
+			}
+		case err != nil:
+			return fmt.Errorf("failed to look up username: %v", err)
+		case existing.UserID != userID:
+			return ErrUsernameTaken
+		default:
+			// Taking back one of their own handles, or changing its case
+			err := usernames.FindOneAndUpdate(sessCtx, bson.M{"_id": key},
+				bson.M{"$set": bson.M{"username": username, "current": true}, "$unset": bson.M{"retiredAt": ""}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&result)
+			if err != nil {
+				return fmt.Errorf("failed to claim username: %v", err)
+			}
+		}
+
+		_, err = usernames.UpdateMany(sessCtx,
+			bson.M{"userId": userID, "current": true, "_id": bson.M{"$ne": key}},
+			bson.M{"$set": bson.M{"current": false, "retiredAt": now.Unix()}})
+		if err != nil {
+			return fmt.Errorf("failed to retire previous username: %v", err)
+		}
+
+		set := bson.M{"username": username}
+		if !recasing {
+			set["usernameChangedAt"] = now.Unix()
+		}
+		if _, err := config.GetCollection(UsersCollection).UpdateOne(sessCtx, bson.M{"_id": userID}, bson.M{"$set": set}); err != nil {
+			return fmt.Errorf("failed to update username: %v", err)
+		}
+		return events.Record(sessCtx, events.ProfileUpdated, userID, events.ProfileUpdatedData{Fields: []string{"username"}})
+	})
+	// The unique _id catches a concurrent claim of the same handle
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ResolveUsername finds the visible user holding a username. For a handle
+// they have since moved away from, it also returns their current username to
+// redirect to; for a current handle, redirect is empty.
+func ResolveUsername(ctx context.Context, username string) (profile *UserProfile, redirect string, err error) {
+	var handle Username
+	err = config.GetCollection(UsernamesCollection).FindOne(ctx, bson.M{"_id": strings.ToLower(username)}).Decode(&handle)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", ErrUsernameNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to look up username: %v", err)
+	}
+
+	profile = &UserProfile{}
+	err = config.GetCollection(UsersCollection).FindOne(ctx, Visible(bson.M{"_id": handle.UserID}),
+		options.FindOne().SetProjection(userProjection)).Decode(profile)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", ErrUsernameNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch user: %v", err)
+	}
+
+	if !handle.Current && profile.Username != "" {
+		redirect = profile.Username
+	}
+	return profile, redirect, nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		want     error
+	}{
+		{"ada", nil},
+		{"Ada_Lovelace99", nil},
+		{"scunthorpe_fan", ErrUsernameReserved},
+		{"ab", ErrInvalidUsername},
+		{strings.Repeat("a", MaxUsernameLength), nil},
+		{strings.Repeat("a", MaxUsernameLength+1), ErrInvalidUsername},
+		{"9lives", ErrInvalidUsername},
+		{"_ada", ErrInvalidUsername},
+		{"ada.lovelace", ErrInvalidUsername},
+		{"adá", ErrInvalidUsername},
+		{"", ErrInvalidUsername},
+		{"admin", ErrUsernameReserved},
+		{"Support", ErrUsernameReserved},
+		{"admin2", nil},
+		{"sh1t_happens", ErrUsernameReserved},
+		{"f_u_c_k", ErrUsernameReserved},
+	}
+	for _, tt := range tests {
+		if got := ValidateUsername(tt.username); !errors.Is(got, tt.want) {
+			t.Errorf("ValidateUsername(%q) = %v, want %v", tt.username, got, tt.want)
+		}
+	}
+}
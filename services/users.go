@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"trademinutes-user/config"
+
+	"github.com/ElioCloud/shared-models/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxBatchUsers caps how many users one GetUsers call may ask for
+const MaxBatchUsers = 100
+
+var ErrTooManyUsers = fmt.Errorf("at most %d users can be fetched at once", MaxBatchUsers)
+
+// userProjection keeps password hashes out of lookups made for other services
+var userProjection = bson.M{"password": 0}
+
+// GetUser returns a user by ID without their password. Deleted users are not found.
+func GetUser(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	return findUser(ctx, NotDeleted(bson.M{"_id": userID}))
+}
+
+func findUser(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	err := config.GetCollection(UsersCollection).FindOne(ctx, filter, options.FindOne().SetProjection(userProjection)).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %v", err)
+	}
+	return &user, nil
+}
+
+// GetUsers returns the users with the given IDs, without passwords, in no
+// particular order. IDs that match no user, or a deleted one, are simply left out.
+func GetUsers(ctx context.Context, userIDs []primitive.ObjectID) ([]models.User, error) {
+	if len(userIDs) > MaxBatchUsers {
+		return nil, ErrTooManyUsers
+	}
+	users := []models.User{}
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+
+	cursor, err := config.GetCollection(UsersCollection).Find(ctx, NotDeleted(bson.M{"_id": bson.M{"$in": userIDs}}), options.Find().SetProjection(userProjection))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %v", err)
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %v", err)
+	}
+	return users, nil
+}
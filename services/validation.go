@@ -0,0 +1,216 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"trademinutes-user/utils"
+)
+
+// Profile field limits
+const (
+	MaxNameLength  = 100
+	MaxTextLength  = 120 // college, program and location
+	MaxBioLength   = 1000
+	MaxSkills      = 20
+	MaxSkillLength = 40
+)
+
+// AllowedYearsOfStudy are the values yearOfStudy may take
+var AllowedYearsOfStudy = []string{
+	"1st Year",
+	"2nd Year",
+	"3rd Year",
+	"4th Year",
+	"5th Year",
+	"Graduate",
+	"Postgraduate",
+	"PhD",
+	"Alumni",
+}
+
+// AllowedGenders are the values gender may take
+var AllowedGenders = []string{"male", "female", "non_binary", "other", "prefer_not_to_say"}
+
+// systemOwnedFields are user fields only the server may set
+var systemOwnedFields = []string{
+	"_id", "id", "email", "password", "credits", "heldCredits",
+	"stats", "achievements", "createdAt", "lastLoginAt", "emailVerifiedAt",
+	"username", "usernameChangedAt", "place",
+	// Set only by the upload endpoints, which own the images they point to
+	"profilePictureURL", "coverImageURL",
+}
+
+// FieldError explains why one field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field in a request
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the error if any field was invalid, or nil
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// IsSystemOwnedField reports whether a client-supplied field name, matched
+// case-insensitively, is one only the server may set
+func IsSystemOwnedField(name string) bool {
+	for _, field := range systemOwnedFields {
+		if strings.EqualFold(field, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateProfileFields checks profile values, keyed by their name in the
+// user document, and returns them cleaned up for storage: HTML is stripped,
+// year of study and gender take their canonical spelling, coordinates are
+// snapped to LocationGridDegrees and duplicate skills are dropped. Every invalid field is reported in a *ValidationError.
+func ValidateProfileFields(fields map[string]interface{}) (map[string]interface{}, error) {
+	invalid := &ValidationError{}
+	clean := make(map[string]interface{}, len(fields))
+
+	for name, value := range fields {
+		if IsSystemOwnedField(name) {
+			invalid.Add(name, "is managed by the server and cannot be set")
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			if cleaned, ok := validateProfileString(invalid, name, v); ok {
+				clean[name] = cleaned
+			}
+		case []string:
+			if name != "skills" {
+				invalid.Add(name, "must not be a list")
+				continue
+			}
+			if skills, ok := validateSkills(invalid, v); ok {
+				clean[name] = skills
+			}
+		case float64:
+			if validateCoordinate(invalid, name, v) {
+				clean[name] = coarsenCoordinate(v)
+			}
+		default:
+			invalid.Add(name, "cannot be set")
+		}
+	}
+
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+	return clean, nil
+}
+
+func validateProfileString(invalid *ValidationError, name, value string) (string, bool) {
+	value = utils.StripHTML(value)
+	switch name {
+	case "name":
+		if value == "" {
+			invalid.Add(name, "is required")
+			return "", false
+		}
+		return value, checkLength(invalid, name, value, MaxNameLength)
+	case "college", "program", "location":
+		return value, checkLength(invalid, name, value, MaxTextLength)
+	case "bio":
+		return value, checkLength(invalid, name, value, MaxBioLength)
+	case "yearOfStudy":
+		return canonicalValue(invalid, name, value, AllowedYearsOfStudy)
+	case "gender":
+		return canonicalValue(invalid, name, value, AllowedGenders)
+	}
+	invalid.Add(name, "cannot be set")
+	return "", false
+}
+
+func validateSkills(invalid *ValidationError, skills []string) ([]string, bool) {
+	clean := make([]string, 0, len(skills))
+	seen := map[string]bool{}
+	ok := true
+	for i, skill := range skills {
+		skill = utils.StripHTML(skill)
+		field := fmt.Sprintf("skills[%d]", i)
+		if skill == "" {
+			invalid.Add(field, "must not be empty")
+			ok = false
+			continue
+		}
+		if !checkLength(invalid, field, skill, MaxSkillLength) {
+			ok = false
+			continue
+		}
+		if key := strings.ToLower(skill); !seen[key] {
+			seen[key] = true
+			clean = append(clean, skill)
+		}
+	}
+	if len(clean) > MaxSkills {
+		invalid.Add("skills", "must have at most %d entries", MaxSkills)
+		ok = false
+	}
+	return clean, ok
+}
+
+func validateCoordinate(invalid *ValidationError, name string, value float64) bool {
+	switch name {
+	case "latitude":
+		if value < -90 || value > 90 {
+			invalid.Add(name, "must be between -90 and 90")
+			return false
+		}
+		return true
+	case "longitude":
+		if value < -180 || value > 180 {
+			invalid.Add(name, "must be between -180 and 180")
+			return false
+		}
+		return true
+	}
+	invalid.Add(name, "must not be a number")
+	return false
+}
+
+func checkLength(invalid *ValidationError, name, value string, max int) bool {
+	if utf8.RuneCountInString(value) > max {
+		invalid.Add(name, "must be at most %d characters", max)
+		return false
+	}
+	return true
+}
+
+// canonicalValue matches value case-insensitively against allowed and returns its canonical spelling
+func canonicalValue(invalid *ValidationError, name, value string, allowed []string) (string, bool) {
+	for _, a := range allowed {
+		if strings.EqualFold(a, value) {
+			return a, true
+		}
+	}
+	invalid.Add(name, "must be one of: %s", strings.Join(allowed, ", "))
+	return "", false
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateProfileFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]interface{}
+		want    map[string]interface{}
+		invalid []string // Fields reported in the ValidationError
+	}{
+		{
+			name:   "strips html and trims",
+			fields: map[string]interface{}{"name": "  <b>Ada</b> Lovelace ", "bio": "I like <i>maths</i>"},
+			want:   map[string]interface{}{"name": "Ada Lovelace", "bio": "I like maths"},
+		},
+		{
+			name:   "canonical year of study and gender",
+			fields: map[string]interface{}{"yearOfStudy": "2ND YEAR", "gender": "Non_Binary"},
+			want:   map[string]interface{}{"yearOfStudy": "2nd Year", "gender": "non_binary"},
+		},
+		{
+			name:    "unknown year of study",
+			fields:  map[string]interface{}{"yearOfStudy": "Sophomore"},
+			invalid: []string{"yearOfStudy"},
+		},
+		{
+			name:    "empty name",
+			fields:  map[string]interface{}{"name": "<p></p>"},
+			invalid: []string{"name"},
+		},
+		{
+			name:    "too long",
+			fields:  map[string]interface{}{"college": strings.Repeat("a", MaxTextLength+1)},
+			invalid: []string{"college"},
+		},
+		{
+			name:   "duplicate skills dropped case-insensitively",
+			fields: map[string]interface{}{"skills": []string{"Go", "go", "Python"}},
+			want:   map[string]interface{}{"skills": []string{"Go", "Python"}},
+		},
+		{
+			name:    "empty skill",
+			fields:  map[string]interface{}{"skills": []string{"Go", " "}},
+			invalid: []string{"skills[1]"},
+		},
+		{
+			name:    "list for a text field",
+			fields:  map[string]interface{}{"bio": []string{"a"}},
+			invalid: []string{"bio"},
+		},
+		{
+			name:   "coordinates snapped",
+			fields: map[string]interface{}{"latitude": 42.3601, "longitude": -71.0589},
+			want:   map[string]interface{}{"latitude": 42.36, "longitude": -71.06},
+		},
+		{
+			name:    "coordinates out of range",
+			fields:  map[string]interface{}{"latitude": 91.0, "longitude": -180.5},
+			invalid: []string{"latitude", "longitude"},
+		},
+		{
+			name:    "system-owned fields",
+			fields:  map[string]interface{}{"Credits": "100", "email": "a@b.c", "place": "x"},
+			invalid: []string{"Credits", "email", "place"},
+		},
+		{
+			name: "images set only by uploads",
+			fields: map[string]interface{}{
+				"profilePictureURL": "https://res.cloudinary.com/trademinutes/image/upload/a.png",
+				"coverImageURL":     "https://example.com/a.png",
+			},
+			invalid: []string{"profilePictureURL", "coverImageURL"},
+		},
+		{
+			name:    "unknown field",
+			fields:  map[string]interface{}{"nickname": "ada"},
+			invalid: []string{"nickname"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateProfileFields(tt.fields)
+			if len(tt.invalid) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+				return
+			}
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got error %v, want a *ValidationError", err)
+			}
+			reported := map[string]bool{}
+			for _, field := range invalid.Fields {
+				reported[field.Field] = true
+			}
+			for _, field := range tt.invalid {
+				if !reported[field] {
+					t.Errorf("%s not reported in %v", field, err)
+				}
+			}
+			if len(reported) != len(tt.invalid) {
+				t.Errorf("reported %v, want only %v", invalid.Fields, tt.invalid)
+			}
+		})
+	}
+}
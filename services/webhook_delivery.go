@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Headers sent with every webhook request
+const (
+	WebhookEventHeader     = "X-TradeMinutes-Event"
+	WebhookDeliveryHeader  = "X-TradeMinutes-Delivery"
+	WebhookTimestampHeader = "X-TradeMinutes-Timestamp"
+	WebhookSignatureHeader = "X-TradeMinutes-Signature"
+)
+
+const (
+	webhookBaseDelay = 30 * time.Second
+	webhookMaxDelay  = time.Hour
+	webhookLogLimit  = 20 // Attempts kept per delivery
+)
+
+// SignWebhook returns the signature header value for a payload sent at
+// timestamp: "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<payload>".
+// Receivers should recompute it and reject stale timestamps to stop replays.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverWebhooks sends every due webhook delivery once and returns how many succeeded
+func DeliverWebhooks(ctx context.Context, client *http.Client) (int, error) {
+	succeeded := 0
+	for {
+		delivery, err := claimDelivery(ctx)
+		if err != nil {
+			return succeeded, err
+		}
+		if delivery == nil {
+			return succeeded, nil
+		}
+
+		ok, err := attemptDelivery(ctx, client, delivery)
+		if err != nil {
+			return succeeded, err
+		}
+		if ok {
+			succeeded++
+		}
+	}
+}
+
+// StartWebhookDeliveryJob sends due webhook deliveries on the given interval until the process exits
+func StartWebhookDeliveryJob(interval time.Duration) {
+	// Subscriptions name arbitrary hosts, so deliveries never reach internal addresses
+	client := utils.NewPublicHTTPClient(config.WebhookTimeout())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			if _, err := DeliverWebhooks(ctx, client); err != nil {
+				log.Printf("❌ Webhook delivery failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// claimDelivery leases the oldest due pending delivery
+func claimDelivery(ctx context.Context) (*WebhookDelivery, error) {
+	now := time.Now()
+	var delivery WebhookDelivery
+	err := config.GetCollection(WebhookDeliveriesCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"status":        DeliveryPending,
+			"nextAttemptAt": bson.M{"$lte": now.Unix()},
+			"lockedUntil":   bson.M{"$lte": now.Unix()},
+		},
+		bson.M{"$set": bson.M{"lockedUntil": now.Add(config.WebhookTimeout() + time.Minute).Unix()}},
+		options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook delivery: %v", err)
+	}
+	return &delivery, nil
+}
+
+// attemptDelivery sends one delivery and records the outcome: succeeded on a
+// 2xx response, otherwise retried with exponential backoff until it goes dead
+func attemptDelivery(ctx context.Context, client *http.Client, delivery *WebhookDelivery) (bool, error) {
+	collection := config.GetCollection(WebhookDeliveriesCollection)
+
+	var subscription WebhookSubscription
+	err := config.GetCollection(WebhookSubscriptionsCollection).FindOne(ctx, bson.M{"_id": delivery.SubscriptionID}).Decode(&subscription)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && !subscription.Active) {
+		// Deleted or paused since the event was queued
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{"status": DeliveryDead, "lockedUntil": 0}})
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch webhook subscription: %v", err)
+	}
+
+	attempt := send(ctx, client, &subscription, delivery)
+	attempts := delivery.Attempts + 1
+
+	set := bson.M{"lockedUntil": 0}
+	succeeded := attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300
+	switch {
+	case succeeded:
+		set["status"] = DeliverySucceeded
+		set["deliveredAt"] = attempt.At
+	case attempts >= config.WebhookMaxAttempts():
+		set["status"] = DeliveryDead
+		log.Printf("⚠️  Webhook delivery %s to %s dead after %d attempts", delivery.ID.Hex(), subscription.URL, attempts)
+	default:
+		delay := webhookBaseDelay << uint(min(attempts-1, 16))
+		if delay > webhookMaxDelay {
+			delay = webhookMaxDelay
+		}
+		set["nextAttemptAt"] = time.Now().Add(delay).Unix()
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{
+		"$set":  set,
+		"$inc":  bson.M{"attempts": 1},
+		"$push": bson.M{"log": bson.M{"$each": bson.A{attempt}, "$slice": -webhookLogLimit}},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %v", err)
+	}
+	return succeeded, nil
+}
+
+func send(ctx context.Context, client *http.Client, subscription *WebhookSubscription, delivery *WebhookDelivery) DeliveryAttempt {
+	start := time.Now()
+	attempt := DeliveryAttempt{At: start.Unix()}
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TradeMinutes-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(start.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, start.Unix(), payload))
+
+	resp, err := client.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	return attempt
+}
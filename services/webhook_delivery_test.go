@@ -0,0 +1,24 @@
+package services
+
+import "testing"
+
+func TestSignWebhook(t *testing.T) {
+	payload := []byte(`{"event":"user.updated"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		payload   []byte
+		want      string
+	}{
+		{"signs timestamp and payload", "whsec_test", 1700000000, payload, "sha256=134770b4d1a55a4216642fa499eabb01a0cbb6d6bc2b184fba183f6acbe45a60"},
+		{"other secret", "other_secret", 1700000000, payload, "sha256=593b0f2b1f5d3b6fd2a6191eb05d4734a520e5e085f5f81942ab497729a49456"},
+		{"other timestamp", "whsec_test", 1700000001, payload, "sha256=a9e89d848fa221f5f06ea03cd2837b3321ce5af5e5195a3220b9f9068122b8ed"},
+		{"empty payload", "whsec_test", 1700000000, nil, "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+	}
+	for _, tt := range tests {
+		if got := SignWebhook(tt.secret, tt.timestamp, tt.payload); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"trademinutes-user/config"
+	"trademinutes-user/events"
+	"trademinutes-user/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	WebhookSubscriptionsCollection = "webhookSubscriptions"
+	WebhookDeliveriesCollection    = "webhookDeliveries"
+)
+
+// WebhookAllEvents subscribes to every event type
+const WebhookAllEvents = "*"
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead" // Gave up after WEBHOOK_MAX_ATTEMPTS; can be redelivered manually
+)
+
+var (
+	ErrWebhookNotFound     = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound    = errors.New("webhook delivery not found")
+	ErrInvalidWebhookURL   = errors.New("url must be an absolute http or https URL on a public host")
+	ErrInvalidWebhookEvent = errors.New("unknown event type")
+)
+
+// WebhookSubscription sends events of the given types to URL, signed with Secret
+type WebhookSubscription struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	URL         string             `bson:"url" json:"url"`
+	EventTypes  []string           `bson:"eventTypes" json:"eventTypes"`
+	Secret      string             `bson:"secret" json:"-"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Active      bool               `bson:"active" json:"active"`
+	CreatedBy   string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt   int64              `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   int64              `bson:"updatedAt" json:"updatedAt"`
+}
+
+// WebhookDelivery is one event sent to one subscription, with every attempt logged
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SubscriptionID primitive.ObjectID `bson:"subscriptionId" json:"subscriptionId"`
+	EventID        string             `bson:"eventId" json:"eventId"`
+	EventType      string             `bson:"eventType" json:"eventType"`
+	Payload        string             `bson:"payload" json:"payload"`
+	Status         string             `bson:"status" json:"status"`
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt  int64              `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LockedUntil    int64              `bson:"lockedUntil" json:"-"`
+	Log            []DeliveryAttempt  `bson:"log" json:"log"`
+	CreatedAt      int64              `bson:"createdAt" json:"createdAt"`
+	DeliveredAt    int64              `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+}
+
+// DeliveryAttempt records the outcome of one HTTP request
+type DeliveryAttempt struct {
+	At         int64  `bson:"at" json:"at"`
+	StatusCode int    `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64  `bson:"durationMs" json:"durationMs"`
+}
+
+// CreateWebhook registers a subscription. If secret is empty one is generated;
+// either way the secret is only ever returned here.
+func CreateWebhook(ctx context.Context, subscription WebhookSubscription, createdBy string) (*WebhookSubscription, string, error) {
+	if err := validateWebhook(&subscription); err != nil {
+		return nil, "", err
+	}
+	if subscription.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, "", err
+		}
+		subscription.Secret = secret
+	}
+
+	now := time.Now().Unix()
+	subscription.ID = primitive.NewObjectID()
+	subscription.Active = true
+	subscription.CreatedBy = createdBy
+	subscription.CreatedAt = now
+	subscription.UpdatedAt = now
+
+	if _, err := config.GetCollection(WebhookSubscriptionsCollection).InsertOne(ctx, subscription); err != nil {
+		return nil, "", fmt.Errorf("failed to create webhook: %v", err)
+	}
+	return &subscription, subscription.Secret, nil
+}
+
+// UpdateWebhook replaces a subscription's URL, event types, description and active flag.
+// The secret is kept.
+func UpdateWebhook(ctx context.Context, id primitive.ObjectID, changes WebhookSubscription) (*WebhookSubscription, error) {
+	if err := validateWebhook(&changes); err != nil {
+		return nil, err
+	}
+
+	var subscription WebhookSubscription
+	err := config.GetCollection(WebhookSubscriptionsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"url":         changes.URL,
+			"eventTypes":  changes.EventTypes,
+			"description": changes.Description,
+			"active":      changes.Active,
+			"updatedAt":   time.Now().Unix(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&subscription)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %v", err)
+	}
+	return &subscription, nil
+}
+
+// DeleteWebhook removes a subscription. Deliveries still pending for it are dropped.
+func DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+	result, err := config.GetCollection(WebhookSubscriptionsCollection).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ListWebhooks returns every subscription, newest first
+func ListWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	cursor, err := config.GetCollection(WebhookSubscriptionsCollection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhooks: %v", err)
+	}
+	subscriptions := []WebhookSubscription{}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to decode webhooks: %v", err)
+	}
+	return subscriptions, nil
+}
+
+// ListWebhookDeliveries returns a subscription's most recent deliveries,
+// optionally only those with the given status
+func ListWebhookDeliveries(ctx context.Context, subscriptionID primitive.ObjectID, status string, limit int64) ([]WebhookDelivery, error) {
+	filter := bson.M{"subscriptionId": subscriptionID}
+	if status != "" {
+		filter["status"] = status
+	}
+	cursor, err := config.GetCollection(WebhookDeliveriesCollection).Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook deliveries: %v", err)
+	}
+	deliveries := []WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+// Redeliver queues a delivery to be sent again right away, whatever its status,
+// with a fresh set of attempts
+func Redeliver(ctx context.Context, deliveryID primitive.ObjectID) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := config.GetCollection(WebhookDeliveriesCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": deliveryID},
+		bson.M{"$set": bson.M{
+			"status":        DeliveryPending,
+			"attempts":      0,
+			"nextAttemptAt": time.Now().Unix(),
+			"lockedUntil":   0,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue redelivery: %v", err)
+	}
+	return &delivery, nil
+}
+
+// WebhookSink is the events.Broker that turns published events into queued
+// webhook deliveries, one per matching active subscription. Queuing the same
+// event twice is a no-op, so relay retries never send duplicates.
+type WebhookSink struct{}
+
+func (WebhookSink) Publish(ctx context.Context, msg events.Message) error {
+	cursor, err := config.GetCollection(WebhookSubscriptionsCollection).Find(ctx, bson.M{
+		"active":     true,
+		"eventTypes": bson.M{"$in": bson.A{msg.Type, WebhookAllEvents}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch webhook subscriptions: %v", err)
+	}
+	var subscriptions []WebhookSubscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return fmt.Errorf("failed to decode webhook subscriptions: %v", err)
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, subscription := range subscriptions {
+		_, err := config.GetCollection(WebhookDeliveriesCollection).InsertOne(ctx, WebhookDelivery{
+			ID:             primitive.NewObjectID(),
+			SubscriptionID: subscription.ID,
+			EventID:        msg.ID,
+			EventType:      msg.Type,
+			Payload:        string(payload),
+			Status:         DeliveryPending,
+			NextAttemptAt:  now,
+			Log:            []DeliveryAttempt{},
+			CreatedAt:      now,
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to queue webhook delivery: %v", err)
+		}
+	}
+	return nil
+}
+
+func (WebhookSink) Close() error {
+	return nil
+}
+
+func validateWebhook(subscription *WebhookSubscription) error {
+	subscription.URL = strings.TrimSpace(subscription.URL)
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+	// Names are checked again after DNS resolution when delivering
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrInvalidWebhookURL
+	}
+	if ip := net.ParseIP(host); ip != nil && !utils.IsPublicIP(ip) {
+		return ErrInvalidWebhookURL
+	}
+
+	if len(subscription.EventTypes) == 0 {
+		subscription.EventTypes = []string{WebhookAllEvents}
+	}
+	for _, eventType := range subscription.EventTypes {
+		if eventType != WebhookAllEvents && !events.IsType(eventType) {
+			return fmt.Errorf("%w: %s", ErrInvalidWebhookEvent, eventType)
+		}
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %v", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
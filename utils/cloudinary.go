@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -164,6 +167,73 @@ func ExtractPublicIDFromURL(url string) string {
 	return strings.Join(pathParts, "/")
 }
 
+// IsUploadedBy reports whether rawURL is a profile or cover image the upload
+// endpoints stored for email, so it is theirs to replace or delete
+func IsUploadedBy(rawURL, email string) bool {
+	if !IsImageHostURL(rawURL) || email == "" {
+		return false
+	}
+	u, _ := url.Parse(rawURL)
+	publicID := strings.TrimPrefix(u.Path, "/"+os.Getenv("CLOUDINARY_CLOUD_NAME")+"/image/upload/")
+	if version, rest, ok := strings.Cut(publicID, "/"); ok && len(version) > 1 && version[0] == 'v' && strings.Trim(version[1:], "0123456789") == "" {
+		publicID = rest
+	}
+	for _, prefix := range []string{"trademinutes/profiles/profile_", "trademinutes/covers/cover_"} {
+		if rest, ok := strings.CutPrefix(publicID, prefix+email+"_"); ok && rest != "" && strings.Trim(strings.Split(rest, ".")[0], "0123456789") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// maxFetchedImageSize caps how much FetchImage reads from a remote URL
+const maxFetchedImageSize = 20 << 20
+
+// imageClient downloads stored images; it only reaches public addresses
+var imageClient = NewPublicHTTPClient(30 * time.Second)
+
+// FetchImage returns the bytes, content type and file extension of a stored
+// image, downloading URLs on our Cloudinary account and decoding base64 data
+// URLs. Any other URL is refused, including ones stored before profile
+// images were validated, so it cannot be used to reach internal services.
+func FetchImage(ctx context.Context, src string) ([]byte, string, string, error) {
+	if strings.HasPrefix(src, "data:") {
+		header, data, ok := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return nil, "", "", fmt.Errorf("invalid base64 data URL format")
+		}
+		contentType := strings.TrimSuffix(header, ";base64")
+		imageData, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("failed to decode base64 data: %v", err)
+		}
+		return imageData, contentType, getExtensionFromContentType(contentType), nil
+	}
+
+	if !IsImageHostURL(src) {
+		return nil, "", "", fmt.Errorf("unsupported image URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to download image: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+
+	imageData, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchedImageSize))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to download image: %v", err)
+	}
+	contentType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	return imageData, contentType, getExtensionFromContentType(contentType), nil
+}
+
 func getExtensionFromContentType(contentType string) string {
 	switch contentType {
 	case "image/jpeg":
@@ -178,3 +248,17 @@ func getExtensionFromContentType(contentType string) string {
 		return ".jpg" // Default to jpg
 	}
 }
+
+// IsImageHostURL reports whether rawURL is an https URL for an image in our
+// Cloudinary account, the only image host profiles may link to
+func IsImageHostURL(rawURL string) bool {
+	cloudName := os.Getenv("CLOUDINARY_CLOUD_NAME")
+	if cloudName == "" {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.Host == "res.cloudinary.com" && strings.HasPrefix(u.Path, "/"+cloudName+"/image/")
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestIsUploadedBy(t *testing.T) {
+	t.Setenv("CLOUDINARY_CLOUD_NAME", "demo")
+
+	tests := []struct {
+		name  string
+		url   string
+		email string
+		want  bool
+	}{
+		{"profile picture", "https://res.cloudinary.com/demo/image/upload/v1700000000/trademinutes/profiles/profile_ada@example.com_1700000000.jpg", "ada@example.com", true},
+		{"cover image without a version", "https://res.cloudinary.com/demo/image/upload/trademinutes/covers/cover_ada@example.com_1700000000.png", "ada@example.com", true},
+		{"someone else's picture", "https://res.cloudinary.com/demo/image/upload/v1/trademinutes/profiles/profile_bob@example.com_1700000000.jpg", "ada@example.com", false},
+		{"email that only starts the same", "https://res.cloudinary.com/demo/image/upload/v1/trademinutes/profiles/profile_ada@example.com_x_1700000000.jpg", "ada@example.com", false},
+		{"other folder", "https://res.cloudinary.com/demo/image/upload/v1/other/profile_ada@example.com_1700000000.jpg", "ada@example.com", false},
+		{"other account", "https://res.cloudinary.com/other/image/upload/v1/trademinutes/profiles/profile_ada@example.com_1700000000.jpg", "ada@example.com", false},
+		{"transformation before the folder", "https://res.cloudinary.com/demo/image/upload/w_10/trademinutes/profiles/profile_ada@example.com_1700000000.jpg", "ada@example.com", false},
+		{"not https", "http://res.cloudinary.com/demo/image/upload/v1/trademinutes/profiles/profile_ada@example.com_1700000000.jpg", "ada@example.com", false},
+		{"base64 data", "data:image/png;base64,AAAA", "ada@example.com", false},
+		{"no email", "https://res.cloudinary.com/demo/image/upload/v1/trademinutes/profiles/profile__1700000000.jpg", "", false},
+	}
+	for _, tt := range tests {
+		if got := IsUploadedBy(tt.url, tt.email); got != tt.want {
+			t.Errorf("%s: IsUploadedBy = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
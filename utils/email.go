@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrEmailNotConfigured = errors.New("SMTP is not configured")
+
+// EmailConfigured reports whether SMTP_HOST and SMTP_FROM are set
+func EmailConfigured() bool {
+	return os.Getenv("SMTP_HOST") != "" && os.Getenv("SMTP_FROM") != ""
+}
+
+// SendEmail sends a plain text email through the server configured by
+// SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func SendEmail(to, subject, body string) error {
+	if !EmailConfigured() {
+		return ErrEmailNotConfigured
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	// Header values come from our own templates, but strip newlines so a name
+	// or subject can never inject extra headers
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	message := strings.Join([]string{
+		"From: " + clean.Replace(from),
+		"To: " + clean.Replace(to),
+		"Subject: " + clean.Replace(subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
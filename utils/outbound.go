@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// IsPublicIP reports whether ip is a globally routable address. Loopback,
+// private (RFC 1918 and fc00::/7), link-local (including the cloud metadata
+// address 169.254.169.254), CGNAT, multicast and unspecified addresses are not.
+func IsPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	switch {
+	case addr.IsLoopback(), addr.IsPrivate(), addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast(),
+		addr.IsInterfaceLocalMulticast(), addr.IsMulticast(), addr.IsUnspecified():
+		return false
+	}
+	// Carrier-grade NAT, often used for internal networks too
+	if addr.Is4() && netip.MustParsePrefix("100.64.0.0/10").Contains(addr) {
+		return false
+	}
+	return true
+}
+
+// NewPublicHTTPClient returns a client for fetching user-supplied URLs. It
+// only connects to public addresses, checked after DNS resolution so a host
+// cannot be re-pointed at an internal one, never uses a proxy, and gives up
+// after timeout.
+func NewPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// StripHTML turns user input into plain text: entities are decoded, tags and
+// control characters other than newlines and tabs removed, and surrounding
+// whitespace trimmed
+func StripHTML(s string) string {
+	s = htmlTagPattern.ReplaceAllString(html.UnescapeString(s), "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}